@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+	"github.com/omriharel/deej/pkg/device"
+)
+
+const (
+	configKeySliderCalibration   = "slider_calibration"
+	configKeyNoiseReductionLevel = "noise_reduction"
+
+	// how long a slider is sampled while held at one end
+	holdDuration = 2 * time.Second
+
+	// how long to wait for the device to start talking
+	firstLineTimeout = 10 * time.Second
+
+	// jitter is rarely perfectly regular, so the recommended threshold leaves some room above it
+	jitterMargin = 1.5
+
+	// past this, sliders would only move in huge steps
+	maxNoiseReduction = 0.1
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "config file to write calibration into")
+	deviceName := flag.String("device", "", "name of the mixer in the devices section of the config, if it has one")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		printPortNames()
+		return
+	}
+
+	portName := flag.Arg(0)
+	log.Println("Opening device at:", portName)
+
+	// raw lines would drown the instructions
+	device.TraceLines = false
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	recorder := newRawRecorder()
+	connection := &device.Connection{}
+
+	go func() {
+		err := connection.ConnectAndDispatch(ctx, portName, recorder)
+		if ctx.Err() == nil {
+			log.Fatalln("Device connection lost: ", err)
+		}
+	}()
+
+	select {
+	case <-recorder.firstLine:
+	case <-time.After(firstLineTimeout):
+		log.Fatalln("Device didn't send any slider values, is it the right port?")
+	case <-ctx.Done():
+		return
+	}
+
+	input := readLines(os.Stdin)
+	calibration := map[int]sliderReading{}
+	maxJitter := 0.0
+
+	sliderCount := recorder.sliderCount()
+	fmt.Printf("Found %d sliders. Follow the instructions, or press Ctrl+C to quit without saving.\n", sliderCount)
+
+	for sliderIdx := 0; sliderIdx < sliderCount; sliderIdx++ {
+		bottom, ok := holdAndRecord(ctx, input, recorder, sliderIdx, "bottom")
+		if !ok {
+			return
+		}
+
+		top, ok := holdAndRecord(ctx, input, recorder, sliderIdx, "top")
+		if !ok {
+			return
+		}
+
+		reading, reversed, ok := combine(bottom, top)
+		if reversed {
+			fmt.Printf("Slider %d is reversed, consider setting invert_sliders in the config.\n", sliderIdx+1)
+		}
+		if !ok {
+			fmt.Printf("Slider %d didn't seem to move, skipping it.\n", sliderIdx+1)
+			continue
+		}
+
+		calibration[sliderIdx] = reading
+		maxJitter = max(maxJitter, float64(reading.jitter)/float64(reading.high-reading.low))
+
+		fmt.Printf("Slider %d: min %d, max %d, jitter %d\n", sliderIdx+1, reading.low, reading.high, reading.jitter)
+	}
+
+	noiseReduction := recommendNoiseReduction(maxJitter)
+	fmt.Printf("Recommended noise reduction: %g\n", noiseReduction)
+
+	if err := writeConfig(*configPath, strings.ToLower(*deviceName), calibration, noiseReduction); err != nil {
+		log.Fatalln("Cannot write config: ", err)
+	}
+
+	fmt.Println("Calibration saved to", *configPath)
+}
+
+// readLines reads lines typed by the user in the background, so waiting for them can be cut short by Ctrl+C.
+// the channel is closed once input ends
+func readLines(file *os.File) <-chan string {
+	lines := make(chan string)
+
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	return lines
+}
+
+// holdAndRecord asks the user to hold a slider at one end and records it.
+// it returns false when the user quits with Ctrl+C, leaving the config as it was
+func holdAndRecord(ctx context.Context, input <-chan string, recorder *rawRecorder, sliderIdx int, end string) (sliderReading, bool) {
+	for {
+		fmt.Printf("Move slider %d all the way to the %s, hold it there and press Enter.", sliderIdx+1, end)
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("\nQuitting without saving.")
+			return sliderReading{}, false
+		case _, ok := <-input:
+			if !ok {
+				log.Fatalln("Calibration aborted")
+			}
+		}
+
+		fmt.Println("Recording, keep holding...")
+		values := recorder.record(ctx, sliderIdx, holdDuration)
+		if ctx.Err() != nil {
+			fmt.Println("Quitting without saving.")
+			return sliderReading{}, false
+		}
+
+		if reading, ok := readingOf(values); ok {
+			return reading, true
+		}
+
+		fmt.Println("No values were read, let's try again.")
+	}
+}
+
+// recommendNoiseReduction picks a threshold just above the jitter, given as a fraction of the slider's travel.
+// it never goes below the "low" level, as values are rounded to whole percents anyway
+func recommendNoiseReduction(jitter float64) float64 {
+	lowest, _ := util.NoiseReductionThreshold(util.NoiseReductionLow)
+
+	threshold := math.Max(math.Ceil(jitter*jitterMargin*1000)/1000, lowest)
+	if threshold > maxNoiseReduction {
+		fmt.Println("Sliders are too noisy for noise reduction to handle well, check their wiring.")
+		threshold = maxNoiseReduction
+	}
+
+	return threshold
+}
+
+// writeConfig stores calibration into the config file, keeping curves and dead zones already configured.
+// only the slider_calibration and noise_reduction keys are rewritten, the rest of the file is left as it was
+func writeConfig(configPath string, deviceName string, calibration map[int]sliderReading, noiseReduction float64) error {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", configPath, err)
+	}
+
+	userConfig := viper.New()
+	userConfig.SetConfigType("yaml")
+
+	if err := userConfig.ReadConfig(bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("parse %s: %w", configPath, err)
+	}
+
+	block, err := calibrationBlock(userConfig.GetStringMap(configKeySliderCalibration), deviceName, calibration)
+	if err != nil {
+		return err
+	}
+
+	patched := patchConfig(string(content), configKeySliderCalibration, block)
+	patched = patchConfig(patched, configKeyNoiseReductionLevel, fmt.Sprintf("%s: %g\n", configKeyNoiseReductionLevel, noiseReduction))
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", configPath, err)
+	}
+
+	return os.WriteFile(configPath, []byte(patched), info.Mode())
+}
+
+// calibrationBlock merges readings into the calibration already configured, and returns the slider_calibration
+// block to put in the config. calibration of a named device lives under its name, e.g. slider_calibration.pedals
+func calibrationBlock(existing map[string]interface{}, deviceName string, calibration map[int]sliderReading) (string, error) {
+	sliders := existing
+	if deviceName != "" {
+		sliders, _ = existing[deviceName].(map[string]interface{})
+		if sliders == nil {
+			sliders = map[string]interface{}{}
+		}
+		existing[deviceName] = sliders
+	}
+
+	for sliderIdx, reading := range calibration {
+		key := strconv.Itoa(sliderIdx)
+
+		entry, ok := sliders[key].(map[string]interface{})
+		if !ok {
+			entry = map[string]interface{}{}
+		}
+
+		entry["min"] = reading.low
+		entry["max"] = reading.high
+		sliders[key] = entry
+	}
+
+	var block bytes.Buffer
+
+	encoder := yaml.NewEncoder(&block)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(map[string]interface{}{configKeySliderCalibration: existing}); err != nil {
+		return "", fmt.Errorf("encode calibration: %w", err)
+	}
+
+	return block.String(), nil
+}
+
+// patchConfig replaces a top-level key of a YAML document, along with everything indented under it,
+// or appends the block when the key isn't there. comments and other keys are left untouched
+func patchConfig(content string, key string, block string) string {
+	lines := strings.SplitAfter(content, "\n")
+
+	start := -1
+	for idx, line := range lines {
+		if strings.HasPrefix(line, key+":") {
+			start = idx
+			break
+		}
+	}
+
+	if start < 0 {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+
+		return content + "\n" + block
+	}
+
+	// blank lines and comments only belong to the key when something indented follows them
+	end := start + 1
+	for idx := start + 1; idx < len(lines); idx++ {
+		line := lines[idx]
+
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if strings.TrimSpace(line) != "" {
+				end = idx + 1
+			}
+			continue
+		}
+
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+			break
+		}
+	}
+
+	return strings.Join(lines[:start], "") + block + strings.Join(lines[end:], "")
+}
+
+func printPortNames() {
+	portNames, err := device.ListNames()
+	if err != nil {
+		log.Fatalln("Cannot list devices: ", err)
+	}
+
+	fmt.Println("Usage: deej-calibrate [-config config.yaml] [-device name] <port>")
+	fmt.Println("Avaliable ports:\n\t", strings.Join(portNames, "\n\t"))
+}
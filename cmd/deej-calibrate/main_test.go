@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecommendNoiseReduction(t *testing.T) {
+	type testCase struct {
+		givenJitter       float64
+		expectedThreshold float64
+	}
+
+	testCases := map[string]testCase{
+		"quiet pots stay at the low level": {givenJitter: 0.001, expectedThreshold: 0.015},
+		"just above the jitter":            {givenJitter: 0.02, expectedThreshold: 0.03},
+		"rounded up":                       {givenJitter: 0.0201, expectedThreshold: 0.031},
+		"too noisy":                        {givenJitter: 0.2, expectedThreshold: maxNoiseReduction},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			assert.InDelta(t, testCase.expectedThreshold, recommendNoiseReduction(testCase.givenJitter), 0.000001)
+		})
+	}
+}
+
+func TestHoldAndRecord_interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	input := make(chan string, 1)
+
+	// Ctrl+C while waiting for Enter, nothing ever typed
+	cancel()
+
+	_, ok := holdAndRecord(ctx, input, newRawRecorder(), 0, "bottom")
+	assert.False(t, ok)
+
+	// Ctrl+C while recording doesn't wait for the recording to finish
+	ctx, cancel = context.WithCancel(context.Background())
+	input <- ""
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	started := time.Now()
+	_, ok = holdAndRecord(ctx, input, newRawRecorder(), 0, "bottom")
+	assert.False(t, ok)
+	assert.Less(t, time.Since(started), holdDuration)
+}
+
+func TestWriteConfig(t *testing.T) {
+	type testCase struct {
+		givenConfig    string
+		givenDevice    string
+		expectedConfig string
+	}
+
+	calibration := map[int]sliderReading{0: {low: 12, high: 1010}}
+
+	testCases := map[string]testCase{
+		"only calibration and noise reduction change": {
+			givenConfig: `# sliders
+slider_mapping:
+  0: master
+
+# Calibration
+slider_calibration:
+  "0": {min: 0, max: 900, curve: log}
+  # the other one
+  "1": {min: 5, max: 1000}
+
+noise_reduction: default # or low, or high
+invert_sliders: false
+`,
+			expectedConfig: `# sliders
+slider_mapping:
+  0: master
+
+# Calibration
+slider_calibration:
+  "0":
+    curve: log
+    max: 1010
+    min: 12
+  "1":
+    max: 1000
+    min: 5
+
+noise_reduction: 0.02
+invert_sliders: false
+`,
+		},
+		"appended when missing": {
+			givenConfig: "com_port: COM4",
+			expectedConfig: `com_port: COM4
+
+slider_calibration:
+  "0":
+    max: 1010
+    min: 12
+
+noise_reduction: 0.02
+`,
+		},
+		"named device": {
+			givenConfig: `slider_calibration:
+  desk:
+    "0": {min: 3, max: 1000}
+noise_reduction: low
+`,
+			givenDevice: "pedals",
+			expectedConfig: `slider_calibration:
+  desk:
+    "0":
+      max: 1000
+      min: 3
+  pedals:
+    "0":
+      max: 1010
+      min: 12
+noise_reduction: 0.02
+`,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(configPath, []byte(testCase.givenConfig), 0o644))
+
+			require.NoError(t, writeConfig(configPath, testCase.givenDevice, calibration, 0.02))
+
+			written, err := os.ReadFile(configPath)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedConfig, string(written))
+		})
+	}
+}
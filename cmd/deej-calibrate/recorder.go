@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/omriharel/deej/pkg/device"
+)
+
+// rawRecorder keeps raw slider values read from the device, while asked to
+type rawRecorder struct {
+	lock      sync.Mutex
+	sliders   int
+	recording bool
+	samples   [][]int
+
+	firstLine chan struct{}
+	firstOnce sync.Once
+}
+
+var _ device.VolumeConsumer = (*rawRecorder)(nil)
+
+func newRawRecorder() *rawRecorder {
+	return &rawRecorder{
+		firstLine: make(chan struct{}),
+	}
+}
+
+func (rec *rawRecorder) OnVolume(volumes []int) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	rec.sliders = len(volumes)
+	if rec.recording {
+		rec.samples = append(rec.samples, volumes)
+	}
+
+	rec.firstOnce.Do(func() {
+		close(rec.firstLine)
+	})
+}
+
+func (rec *rawRecorder) OnMute([]bool) {}
+
+// sliderCount returns how many sliders the last line had
+func (rec *rawRecorder) sliderCount() int {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	return rec.sliders
+}
+
+// record collects values of a single slider for the given duration, or until ctx is done
+func (rec *rawRecorder) record(ctx context.Context, sliderIdx int, duration time.Duration) []int {
+	rec.lock.Lock()
+	rec.recording = true
+	rec.samples = nil
+	rec.lock.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	rec.recording = false
+
+	values := make([]int, 0, len(rec.samples))
+	for _, sample := range rec.samples {
+		if sliderIdx < len(sample) {
+			values = append(values, sample[sliderIdx])
+		}
+	}
+
+	return values
+}
+
+// sliderReading sums up values recorded while a slider was held still
+type sliderReading struct {
+	low    int
+	high   int
+	jitter int
+}
+
+func readingOf(values []int) (sliderReading, bool) {
+	if len(values) == 0 {
+		return sliderReading{}, false
+	}
+
+	reading := sliderReading{low: values[0], high: values[0]}
+	for _, value := range values {
+		reading.low = min(reading.low, value)
+		reading.high = max(reading.high, value)
+	}
+	reading.jitter = reading.high - reading.low
+
+	return reading, true
+}
+
+// combine sums up readings taken at both ends of a slider, using the inner edge of what was read at each
+// so both ends are always reached despite the jitter. sliders wired upside down reach their lowest raw value
+// at the top, and are reported as reversed. it fails for sliders that didn't seem to move
+func combine(bottom sliderReading, top sliderReading) (reading sliderReading, reversed bool, ok bool) {
+	lowEnd, highEnd := bottom, top
+	if bottom.low > top.high {
+		lowEnd, highEnd = top, bottom
+		reversed = true
+	}
+
+	reading = sliderReading{
+		low:    lowEnd.high,
+		high:   highEnd.low,
+		jitter: max(lowEnd.jitter, highEnd.jitter),
+	}
+
+	return reading, reversed, reading.low < reading.high
+}
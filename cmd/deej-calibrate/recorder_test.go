@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadingOf(t *testing.T) {
+	type testCase struct {
+		givenValues     []int
+		expectedReading sliderReading
+		expectedOk      bool
+	}
+
+	testCases := map[string]testCase{
+		"steady": {
+			givenValues:     []int{512, 512, 512},
+			expectedReading: sliderReading{low: 512, high: 512},
+			expectedOk:      true,
+		},
+		"jittery": {
+			givenValues:     []int{1010, 1014, 1008, 1012},
+			expectedReading: sliderReading{low: 1008, high: 1014, jitter: 6},
+			expectedOk:      true,
+		},
+		"nothing read": {
+			givenValues: []int{},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			reading, ok := readingOf(testCase.givenValues)
+
+			assert.Equal(t, testCase.expectedOk, ok)
+			assert.Equal(t, testCase.expectedReading, reading)
+		})
+	}
+}
+
+func TestCombine(t *testing.T) {
+	type testCase struct {
+		givenBottom      sliderReading
+		givenTop         sliderReading
+		expectedReading  sliderReading
+		expectedReversed bool
+		expectedOk       bool
+	}
+
+	testCases := map[string]testCase{
+		"inner edges of both ends": {
+			givenBottom:     sliderReading{low: 10, high: 14, jitter: 4},
+			givenTop:        sliderReading{low: 1008, high: 1010, jitter: 2},
+			expectedReading: sliderReading{low: 14, high: 1008, jitter: 4},
+			expectedOk:      true,
+		},
+		"reversed": {
+			givenBottom:      sliderReading{low: 1008, high: 1010, jitter: 2},
+			givenTop:         sliderReading{low: 10, high: 14, jitter: 4},
+			expectedReading:  sliderReading{low: 14, high: 1008, jitter: 4},
+			expectedReversed: true,
+			expectedOk:       true,
+		},
+		"didn't move": {
+			givenBottom:     sliderReading{low: 500, high: 510, jitter: 10},
+			givenTop:        sliderReading{low: 502, high: 508, jitter: 6},
+			expectedReading: sliderReading{low: 510, high: 502, jitter: 10},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			reading, reversed, ok := combine(testCase.givenBottom, testCase.givenTop)
+
+			assert.Equal(t, testCase.expectedReading, reading)
+			assert.Equal(t, testCase.expectedReversed, reversed)
+			assert.Equal(t, testCase.expectedOk, ok)
+		})
+	}
+}
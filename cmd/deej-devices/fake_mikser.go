@@ -11,6 +11,7 @@ import (
 type fakeMikser struct{}
 
 var _ device.VolumeConsumer = fakeMikser{}
+var _ device.EncoderConsumer = fakeMikser{}
 
 func (fakeMikser) OnVolume(volumes []int) {
 	var builder strings.Builder
@@ -40,3 +41,17 @@ func (fakeMikser) OnMute(mutes []bool) {
 	log.Println(builder.String())
 
 }
+
+func (fakeMikser) OnEncoder(deltas []int) {
+	var builder strings.Builder
+	fmt.Fprint(&builder, "OnEncoder:")
+
+	for i, delta := range deltas {
+		fmt.Fprintf(&builder, " %+d", delta)
+		if i != len(deltas)-1 {
+			fmt.Fprint(&builder, ",")
+		}
+	}
+
+	log.Println(builder.String())
+}
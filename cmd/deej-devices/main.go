@@ -32,10 +32,23 @@ func main() {
 }
 
 func printPortNames() {
-	portNames, err := device.ListNames()
+	options := device.DiscoveryOptions{
+		SerialOptions: device.DefaultSerialOptions(),
+		BaudRates:     device.DefaultProbeBaudRates,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout())
+	defer cancel()
+
+	detected, err := device.Discover(ctx, options)
 	if err != nil {
 		log.Fatalln("Cannot list devices: ", err)
 	}
 
-	fmt.Println("Avaliable ports:\n\t", strings.Join(portNames, "\n\t"))
+	descriptions := make([]string, 0, len(detected))
+	for _, device := range detected {
+		descriptions = append(descriptions, device.String())
+	}
+
+	fmt.Println("Avaliable ports:\n\t", strings.Join(descriptions, "\n\t"))
 }
@@ -8,8 +8,8 @@ require (
 	github.com/gen2brain/beeep v0.0.0-20200420150314-13046a26d502
 	github.com/getlantern/systray v1.2.2
 	github.com/go-ole/go-ole v1.2.4
+	github.com/godbus/dbus v4.1.0+incompatible
 	github.com/gonutz/wui/v2 v2.8.1
-	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
 	github.com/jfreymuth/pulse v0.0.0-20200608153616-84b2d752b9d4
 	github.com/lxn/win v0.0.0-20210218163916-a377121e959e
 	github.com/mitchellh/go-ps v1.0.0
@@ -20,6 +20,7 @@ require (
 	go.bug.st/serial v1.6.2
 	go.uber.org/zap v1.15.0
 	golang.org/x/exp v0.0.0-20240613232115-7f521ea00fb8
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -33,7 +34,6 @@ require (
 	github.com/getlantern/ops v0.0.0-20190325191751-d70cb0d6f85f // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/go-toast/toast v0.0.0-20190211030409-01e6764cf0a4 // indirect
-	github.com/godbus/dbus v4.1.0+incompatible // indirect
 	github.com/gonutz/w32/v2 v2.2.2 // indirect
 	github.com/gopherjs/gopherjs v0.0.0-20200217142428-fce0ec30dd00 // indirect
 	github.com/gopherjs/gopherwasm v1.1.0 // indirect
@@ -58,5 +58,4 @@ require (
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/ini.v1 v1.51.0 // indirect
 	gopkg.in/yaml.v2 v2.2.4 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
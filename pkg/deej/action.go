@@ -0,0 +1,107 @@
+package deej
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// Action is what a button does when it fires. actions don't touch sessions or the system
+// themselves, everything goes through an actionExecutor so they can be tested without either
+type Action interface {
+	Execute(executor actionExecutor, data actionData) error
+}
+
+// actionExecutor carries out the side effects of actions, SessionMap is the real one
+type actionExecutor interface {
+	muteTargets(targets []string, mute bool)
+	solo(device string, button int, solo bool, targets []string)
+	cycleOutputDevice(devices []string) error
+	sendMediaKey(key util.MediaKey) error
+	runCommand(command string, args []string) error
+}
+
+// actionData describes what made an action fire. templated command arguments can refer
+// to its fields, e.g. "{{.Button}}", "{{.Gesture}}" or "{{if .Active}}on{{else}}off{{end}}"
+type actionData struct {
+	Device  string // name of the mixer, empty for the only one of configs without devices
+	Button  int    // index of the button, starting at 0. for chords, the lowest one
+	Gesture string // gesture that fired, e.g. "long_press(2)", empty for mute_mapping buttons
+	Active  bool   // whether the button's mute or solo is now on
+}
+
+type muteAction struct {
+	targets []string
+}
+
+func (a muteAction) Execute(executor actionExecutor, data actionData) error {
+	executor.muteTargets(a.targets, data.Active)
+	return nil
+}
+
+type soloAction struct {
+	targets []string
+}
+
+func (a soloAction) Execute(executor actionExecutor, data actionData) error {
+	executor.solo(data.Device, data.Button, data.Active, a.targets)
+	return nil
+}
+
+type cycleOutputDeviceAction struct {
+	devices []string
+}
+
+func (a cycleOutputDeviceAction) Execute(executor actionExecutor, _ actionData) error {
+	return executor.cycleOutputDevice(a.devices)
+}
+
+type mediaKeyAction struct {
+	key util.MediaKey
+}
+
+func (a mediaKeyAction) Execute(executor actionExecutor, _ actionData) error {
+	return executor.sendMediaKey(a.key)
+}
+
+// runCommandAction runs a command, its arguments are templates filled in with actionData
+type runCommandAction struct {
+	command string
+	args    []*template.Template
+}
+
+// newRunCommandAction parses argument templates upfront, so mistakes show up when the config loads
+func newRunCommandAction(command string, args []string) (runCommandAction, error) {
+	action := runCommandAction{
+		command: command,
+		args:    make([]*template.Template, len(args)),
+	}
+
+	for i, arg := range args {
+		argTemplate, err := template.New(fmt.Sprintf("arg %d", i)).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return action, fmt.Errorf("argument %q: %w", arg, err)
+		}
+
+		action.args[i] = argTemplate
+	}
+
+	return action, nil
+}
+
+func (a runCommandAction) Execute(executor actionExecutor, data actionData) error {
+	args := make([]string, len(a.args))
+
+	for i, argTemplate := range a.args {
+		var arg strings.Builder
+		if err := argTemplate.Execute(&arg, data); err != nil {
+			return fmt.Errorf("fill in %s: %w", argTemplate.Name(), err)
+		}
+
+		args[i] = arg.String()
+	}
+
+	return executor.runCommand(a.command, args)
+}
@@ -0,0 +1,115 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExecutor records what actions asked for, instead of doing it
+type fakeExecutor struct {
+	calls []string
+	err   error
+}
+
+var _ actionExecutor = (*fakeExecutor)(nil)
+
+func (e *fakeExecutor) record(call string, args ...interface{}) error {
+	e.calls = append(e.calls, call)
+	for _, arg := range args {
+		e.calls = append(e.calls, fmt.Sprint(arg))
+	}
+
+	return e.err
+}
+
+func (e *fakeExecutor) muteTargets(targets []string, mute bool) {
+	e.record("mute", targets, mute)
+}
+
+func (e *fakeExecutor) solo(device string, button int, solo bool, targets []string) {
+	e.record("solo", device, button, solo, targets)
+}
+
+func (e *fakeExecutor) cycleOutputDevice(devices []string) error {
+	return e.record("cycle", devices)
+}
+
+func (e *fakeExecutor) sendMediaKey(key util.MediaKey) error {
+	return e.record("media", key)
+}
+
+func (e *fakeExecutor) runCommand(command string, args []string) error {
+	return e.record("run", command, args)
+}
+
+func TestAction_Execute(t *testing.T) {
+	type testCase struct {
+		givenConfig   interface{}
+		givenData     actionData
+		expectedCalls []string
+	}
+
+	testCases := map[string]testCase{
+		"mute": {
+			givenConfig:   []interface{}{"mic", "discord.exe"},
+			givenData:     actionData{Button: 0, Active: true},
+			expectedCalls: []string{"mute", "[mic discord.exe]", "true"},
+		},
+		"solo": {
+			givenConfig:   map[string]interface{}{"action": "solo", "targets": "spotify.exe"},
+			givenData:     actionData{Device: "pedals", Button: 2, Active: false},
+			expectedCalls: []string{"solo", "pedals", "2", "false", "[spotify.exe]"},
+		},
+		"cycle-output-device": {
+			givenConfig:   map[string]interface{}{"action": "cycle-output-device"},
+			expectedCalls: []string{"cycle", "[]"},
+		},
+		"media-previous": {
+			givenConfig:   map[string]interface{}{"action": "media-previous"},
+			expectedCalls: []string{"media", "2"},
+		},
+		"run-command": {
+			givenConfig: map[string]interface{}{
+				"action":  "run-command",
+				"command": "notify-send",
+				"args":    []interface{}{"deej", "button {{.Button}} is {{if .Active}}on{{else}}off{{end}}"},
+			},
+			givenData:     actionData{Button: 3, Active: true},
+			expectedCalls: []string{"run", "notify-send", "[deej button 3 is on]"},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			action, err := parseButtonAction(testCase.givenConfig)
+			require.NoError(t, err)
+
+			executor := &fakeExecutor{}
+			require.NoError(t, action.run.Execute(executor, testCase.givenData))
+
+			assert.Equal(t, testCase.expectedCalls, executor.calls)
+		})
+	}
+}
+
+func TestAction_ExecuteErrors(t *testing.T) {
+	failure := errors.New("no media player found")
+
+	action, err := parseButtonAction(map[string]interface{}{"action": "media-play-pause"})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, action.run.Execute(&fakeExecutor{err: failure}, actionData{}), failure)
+
+	// templates referring to unknown fields fail when they're filled in
+	action, err = parseButtonAction(map[string]interface{}{"action": "run-command", "command": "echo", "args": "{{.Volume}}"})
+	require.NoError(t, err)
+
+	executor := &fakeExecutor{}
+	assert.Error(t, action.run.Execute(executor, actionData{}))
+	assert.Empty(t, executor.calls, "command doesn't run with broken arguments")
+}
@@ -0,0 +1,206 @@
+package deej
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// button actions, selected with the "action" key of a mute_mapping entry
+const (
+	buttonActionMute              = "mute"                // mute targets, following the button's mode (default)
+	buttonActionSolo              = "solo"                // every press flips muting everything but the targets
+	buttonActionCycleOutputDevice = "cycle-output-device" // every press switches to the next output device
+	buttonActionMediaPlayPause    = "media-play-pause"    // every press plays or pauses the active media player
+	buttonActionMediaNext         = "media-next"          // every press skips to the next track
+	buttonActionMediaPrevious     = "media-previous"      // every press goes back to the previous track
+	buttonActionRunCommand        = "run-command"         // every press runs a command, with templated arguments
+)
+
+// media keys pressed by media actions
+var buttonActionMediaKeys = map[string]util.MediaKey{
+	buttonActionMediaPlayPause: util.MediaPlayPause,
+	buttonActionMediaNext:      util.MediaNext,
+	buttonActionMediaPrevious:  util.MediaPrevious,
+}
+
+// buttonActionSchema lists keys every action accepts in its mute_mapping entry,
+// besides "action" itself. required keys are set to true
+var buttonActionSchema = map[string]map[string]bool{
+	buttonActionMute:              {"targets": true, "mode": false},
+	buttonActionSolo:              {"targets": true},
+	buttonActionCycleOutputDevice: {"devices": false},
+	buttonActionMediaPlayPause:    {},
+	buttonActionMediaNext:         {},
+	buttonActionMediaPrevious:     {},
+	buttonActionRunCommand:        {"command": true, "args": false},
+}
+
+// buttonAction is a single button's entry under mute_mapping
+type buttonAction struct {
+	kind string
+
+	// mute and solo
+	targets []string
+	mode    string
+
+	// what the button does when it fires
+	run Action
+}
+
+// parseButtonAction reads a mute_mapping entry. a single target or a list of them is
+// a shorthand for muting them, anything else is a map following buttonActionSchema
+func parseButtonAction(value interface{}) (buttonAction, error) {
+	action := buttonAction{kind: buttonActionMute, mode: muteModeMomentary}
+
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		targets, err := parseStringList(value)
+		if err != nil {
+			return action, fmt.Errorf("targets: %w", err)
+		}
+		action.targets = targets
+		action.run = muteAction{targets: targets}
+
+		return action, nil
+	}
+
+	if kind, ok := fields["action"]; ok {
+		kindString, ok := kind.(string)
+		if !ok {
+			return action, fmt.Errorf("action %v is not a string", kind)
+		}
+		action.kind = strings.ToLower(kindString)
+	}
+
+	schema, ok := buttonActionSchema[action.kind]
+	if !ok {
+		return action, fmt.Errorf("unknown action %q, expected one of: %s", action.kind, knownButtonActions())
+	}
+
+	// validate keys first, so typos don't go unnoticed
+	for key := range fields {
+		if _, known := schema[key]; !known && key != "action" {
+			return action, fmt.Errorf("action %q doesn't take %q", action.kind, key)
+		}
+	}
+	for key, required := range schema {
+		if _, present := fields[key]; required && !present {
+			return action, fmt.Errorf("action %q needs %q", action.kind, key)
+		}
+	}
+
+	var (
+		devices []string
+		command string
+		args    []string
+		err     error
+	)
+
+	for key, value := range fields {
+		switch key {
+		case "targets":
+			action.targets, err = parseStringList(value)
+		case "devices":
+			devices, err = parseStringList(value)
+		case "args":
+			args, err = parseStringList(value)
+		case "command":
+			command, err = parseString(value)
+		case "mode":
+			action.mode, err = parseMuteMode(value)
+		}
+
+		if err != nil {
+			return action, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	switch action.kind {
+	case buttonActionMute:
+		action.run = muteAction{targets: action.targets}
+	case buttonActionSolo:
+		action.run = soloAction{targets: action.targets}
+	case buttonActionCycleOutputDevice:
+		action.run = cycleOutputDeviceAction{devices: devices}
+	case buttonActionRunCommand:
+		action.run, err = newRunCommandAction(command, args)
+	default:
+		action.run = mediaKeyAction{key: buttonActionMediaKeys[action.kind]}
+	}
+
+	return action, err
+}
+
+func parseMuteMode(value interface{}) (string, error) {
+	mode, err := parseString(value)
+	if err != nil {
+		return "", err
+	}
+
+	switch mode = strings.ToLower(mode); mode {
+	case muteModeMomentary, muteModeToggle, muteModePushToTalk, muteModePushToMute:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown mode %q", mode)
+}
+
+func parseString(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		if value == "" {
+			return "", fmt.Errorf("empty")
+		}
+		return value, nil
+	case nil:
+		return "", fmt.Errorf("empty")
+	}
+
+	return "", fmt.Errorf("%v is not a string", value)
+}
+
+// parseStringList accepts a single string or a list of them
+func parseStringList(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case string:
+		return []string{value}, nil
+	case []string:
+		return value, nil
+	case []interface{}:
+		list := make([]string, len(value))
+		for i, item := range value {
+			itemString, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v is not a string", item)
+			}
+			list[i] = itemString
+		}
+
+		return list, nil
+	case nil:
+		return nil, fmt.Errorf("empty")
+	}
+
+	return nil, fmt.Errorf("%v is neither a string nor a list of them", value)
+}
+
+func knownButtonActions() string {
+	kinds := make([]string, 0, len(buttonActionSchema))
+	for kind := range buttonActionSchema {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return strings.Join(kinds, ", ")
+}
+
+func (a buttonAction) String() string {
+	if a.kind == buttonActionMute {
+		return a.kind + " " + a.mode
+	}
+
+	return a.kind
+}
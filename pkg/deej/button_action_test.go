@@ -0,0 +1,116 @@
+package deej
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseButtonAction(t *testing.T) {
+	type testCase struct {
+		givenValue     interface{}
+		expectedAction buttonAction
+	}
+
+	testCases := map[string]testCase{
+		"scalar": {
+			givenValue:     "deej.mic",
+			expectedAction: buttonAction{kind: buttonActionMute, mode: muteModeMomentary, targets: []string{"deej.mic"}, run: muteAction{targets: []string{"deej.mic"}}},
+		},
+		"list": {
+			givenValue:     []interface{}{"master", "spotify.exe"},
+			expectedAction: buttonAction{kind: buttonActionMute, mode: muteModeMomentary, targets: []string{"master", "spotify.exe"}, run: muteAction{targets: []string{"master", "spotify.exe"}}},
+		},
+		"mute-without-action": {
+			givenValue:     map[string]interface{}{"targets": "mic", "mode": "toggle"},
+			expectedAction: buttonAction{kind: buttonActionMute, mode: muteModeToggle, targets: []string{"mic"}, run: muteAction{targets: []string{"mic"}}},
+		},
+		"solo": {
+			givenValue:     map[string]interface{}{"action": "Solo", "targets": []interface{}{"discord.exe"}},
+			expectedAction: buttonAction{kind: buttonActionSolo, mode: muteModeMomentary, targets: []string{"discord.exe"}, run: soloAction{targets: []string{"discord.exe"}}},
+		},
+		"cycle-output-device": {
+			givenValue:     map[string]interface{}{"action": "cycle-output-device", "devices": []interface{}{"Speakers", "Headphones"}},
+			expectedAction: buttonAction{kind: buttonActionCycleOutputDevice, mode: muteModeMomentary, run: cycleOutputDeviceAction{devices: []string{"Speakers", "Headphones"}}},
+		},
+		"media-play-pause": {
+			givenValue:     map[string]interface{}{"action": "media-play-pause"},
+			expectedAction: buttonAction{kind: buttonActionMediaPlayPause, mode: muteModeMomentary, run: mediaKeyAction{key: util.MediaPlayPause}},
+		},
+		"media-next": {
+			givenValue:     map[string]interface{}{"action": "media-next"},
+			expectedAction: buttonAction{kind: buttonActionMediaNext, mode: muteModeMomentary, run: mediaKeyAction{key: util.MediaNext}},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			action, err := parseButtonAction(testCase.givenValue)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedAction, action)
+		})
+	}
+}
+
+func TestParseButtonAction_invalid(t *testing.T) {
+	testCases := map[string]interface{}{
+		"unknown-action":   map[string]interface{}{"action": "self-destruct"},
+		"action-not-text":  map[string]interface{}{"action": 3},
+		"unknown-key":      map[string]interface{}{"action": "media-play-pause", "targets": "mic"},
+		"mode-on-solo":     map[string]interface{}{"action": "solo", "targets": "mic", "mode": "toggle"},
+		"missing-targets":  map[string]interface{}{"action": "mute"},
+		"missing-command":  map[string]interface{}{"action": "run-command", "args": "x"},
+		"empty-command":    map[string]interface{}{"action": "run-command", "command": ""},
+		"broken-template":  map[string]interface{}{"action": "run-command", "command": "echo", "args": "{{.Button"},
+		"unknown-mode":     map[string]interface{}{"targets": "mic", "mode": "loud"},
+		"numeric-target":   []interface{}{"mic", 3},
+		"targets-not-list": map[string]interface{}{"targets": map[string]interface{}{"a": "b"}},
+	}
+
+	for testName, givenValue := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			_, err := parseButtonAction(givenValue)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMuteMap_actionsFromYAML(t *testing.T) {
+	givenConfig := `
+mute_mapping:
+  0: deej.mic
+  1: {action: solo, targets: spotify.exe}
+  2: {action: media-play-pause}
+  3:
+    action: run-command
+    command: systemctl
+    args: [suspend]
+  4: {action: teleport}
+`
+
+	userConfig := viper.New()
+	userConfig.SetConfigType("yaml")
+	require.NoError(t, userConfig.ReadConfig(strings.NewReader(givenConfig)))
+
+	mm := muteMapFromConfigs(userConfig.GetStringMap(configKeyMuteMapping))
+	assert.Equal(t, "<4 buttons mapped: 0: mute momentary, 1: solo, 2: media-play-pause, 3: run-command>", mm.String())
+
+	// other actions fire on presses only, solo flips on each
+	mm.update([]bool{false, false, false, false})
+
+	events := mm.update([]bool{false, true, true, false})
+	require.Len(t, events, 3)
+
+	kinds := map[string]bool{}
+	for _, event := range events {
+		kinds[event.action.kind] = event.active
+	}
+	assert.Equal(t, map[string]bool{buttonActionMute: false, buttonActionSolo: true, buttonActionMediaPlayPause: false}, kinds)
+
+	assert.Len(t, mm.update([]bool{false, true, true, false}), 1, "held buttons don't fire again")
+	assert.Equal(t, []bool{false, true, false, false}, mm.states(4))
+}
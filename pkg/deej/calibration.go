@@ -0,0 +1,218 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omriharel/deej/pkg/device"
+)
+
+// supported response curves, selected with the "curve" key of a slider's calibration
+const (
+	curveLinear      = "linear"
+	curveLogarithmic = "log" // rises quickly, finer control near the top
+	curveExponential = "exp" // rises slowly, finer control at low volumes (like audio taper pots)
+	curveCustom      = "custom"
+
+	// deadzones eat into both ends of the slider, so anything past this leaves nothing to move
+	maxDeadzone = 0.45
+)
+
+// calibrationConfig is a single slider's entry under slider_calibration, as written by the user
+type calibrationConfig struct {
+	Min      *int        `mapstructure:"min"`
+	Max      *int        `mapstructure:"max"`
+	Curve    string      `mapstructure:"curve"`
+	Deadzone string      `mapstructure:"deadzone"`
+	Points   [][]float64 `mapstructure:"points"`
+}
+
+// sliderCalibration maps raw values of a single slider to volume scalars
+type sliderCalibration struct {
+	min      int
+	max      int
+	deadzone float64
+	curve    func(float64) float64
+}
+
+// sliderCalibrations holds calibration of every slider, sliders without one are mapped linearly
+type sliderCalibrations struct {
+	m map[int]sliderCalibration
+}
+
+var defaultCalibration = sliderCalibration{
+	min:   0,
+	max:   device.MaxValue,
+	curve: linearCurve,
+}
+
+func sliderCalibrationsFromConfigs(configValues map[string]calibrationConfig) (*sliderCalibrations, error) {
+	calibrations := &sliderCalibrations{
+		m: make(map[int]sliderCalibration, len(configValues)),
+	}
+
+	for sliderIdxString, value := range configValues {
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil {
+			return nil, fmt.Errorf("slider %q: not a valid slider index", sliderIdxString)
+		}
+
+		calibration, err := newSliderCalibration(value)
+		if err != nil {
+			return nil, fmt.Errorf("slider %d: %w", sliderIdx, err)
+		}
+
+		calibrations.m[sliderIdx] = calibration
+	}
+
+	return calibrations, nil
+}
+
+func newSliderCalibration(value calibrationConfig) (sliderCalibration, error) {
+	calibration := defaultCalibration
+
+	if value.Min != nil {
+		calibration.min = *value.Min
+	}
+	if value.Max != nil {
+		calibration.max = *value.Max
+	}
+	if calibration.min >= calibration.max {
+		return calibration, fmt.Errorf("min (%d) must be lower than max (%d)", calibration.min, calibration.max)
+	}
+
+	deadzone, err := parseDeadzone(value.Deadzone)
+	if err != nil {
+		return calibration, err
+	}
+	calibration.deadzone = deadzone
+
+	switch strings.ToLower(value.Curve) {
+	case "", curveLinear:
+		calibration.curve = linearCurve
+	case curveLogarithmic:
+		calibration.curve = logarithmicCurve
+	case curveExponential:
+		calibration.curve = exponentialCurve
+	case curveCustom:
+		calibration.curve, err = piecewiseCurve(value.Points)
+		if err != nil {
+			return calibration, err
+		}
+	default:
+		return calibration, fmt.Errorf("unknown curve %q", value.Curve)
+	}
+
+	return calibration, nil
+}
+
+// parseDeadzone accepts both percents ("2%") and fractions ("0.02")
+func parseDeadzone(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	divider := 1.0
+	if strings.HasSuffix(value, "%") {
+		value = strings.TrimSuffix(value, "%")
+		divider = 100
+	}
+
+	deadzone, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid deadzone %q: %w", value, err)
+	}
+
+	deadzone /= divider
+	if deadzone < 0 || deadzone > maxDeadzone {
+		return 0, fmt.Errorf("deadzone %.2f out of range 0 to %.2f", deadzone, maxDeadzone)
+	}
+
+	return deadzone, nil
+}
+
+// apply maps a raw value of the given slider to a volume scalar between 0.0 and 1.0
+func (sc *sliderCalibrations) apply(sliderIdx int, raw int) float32 {
+	calibration := defaultCalibration
+	if sc != nil {
+		if found, ok := sc.m[sliderIdx]; ok {
+			calibration = found
+		}
+	}
+
+	return calibration.apply(raw)
+}
+
+func (c sliderCalibration) apply(raw int) float32 {
+
+	// stretch whatever range the pot actually reaches over 0..1
+	x := clamp01(float64(raw-c.min) / float64(c.max-c.min))
+
+	// snap both ends, and stretch what's left in between
+	if c.deadzone > 0 {
+		x = clamp01((x - c.deadzone) / (1 - 2*c.deadzone))
+	}
+
+	return float32(clamp01(c.curve(x)))
+}
+
+func (sc *sliderCalibrations) String() string {
+	if sc == nil {
+		return "<0 sliders calibrated>"
+	}
+
+	return fmt.Sprintf("<%d sliders calibrated>", len(sc.m))
+}
+
+func linearCurve(x float64) float64 {
+	return x
+}
+
+func logarithmicCurve(x float64) float64 {
+	return math.Log10(1 + 9*x)
+}
+
+func exponentialCurve(x float64) float64 {
+	return (math.Pow(10, x) - 1) / 9
+}
+
+// piecewiseCurve interpolates linearly between user given [x, y] points,
+// keeping the first and last y beyond them
+func piecewiseCurve(points [][]float64) (func(float64) float64, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("custom curve needs at least 2 points, got %d", len(points))
+	}
+
+	for i, point := range points {
+		if len(point) != 2 {
+			return nil, fmt.Errorf("custom curve point %d: expected [x, y], got %v", i, point)
+		}
+		if i > 0 && point[0] <= points[i-1][0] {
+			return nil, fmt.Errorf("custom curve point %d: x values must be increasing", i)
+		}
+	}
+
+	return func(x float64) float64 {
+		last := len(points) - 1
+		if x <= points[0][0] {
+			return points[0][1]
+		}
+		if x >= points[last][0] {
+			return points[last][1]
+		}
+
+		// first point past x, there's always one before it thanks to the checks above
+		i := sort.Search(len(points), func(i int) bool { return points[i][0] > x })
+		from, to := points[i-1], points[i]
+
+		return from[1] + (x-from[0])*(to[1]-from[1])/(to[0]-from[0])
+	}, nil
+}
+
+func clamp01(x float64) float64 {
+	return math.Min(math.Max(x, 0), 1)
+}
@@ -0,0 +1,135 @@
+package deej
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestSliderCalibration_apply(t *testing.T) {
+	type testCase struct {
+		givenConfig    calibrationConfig
+		givenRaw       []int
+		expectedValues []float32
+	}
+
+	testCases := map[string]testCase{
+		"default-linear": {
+			givenConfig:    calibrationConfig{},
+			givenRaw:       []int{0, 512, 1023, 2000, -5},
+			expectedValues: []float32{0, 0.5004888, 1, 1, 0},
+		},
+		"min-max": {
+			givenConfig:    calibrationConfig{Min: intPtr(12), Max: intPtr(1012)},
+			givenRaw:       []int{0, 12, 512, 1012, 1023},
+			expectedValues: []float32{0, 0, 0.5, 1, 1},
+		},
+		"deadzone-percent": {
+			givenConfig:    calibrationConfig{Min: intPtr(0), Max: intPtr(1000), Deadzone: "10%"},
+			givenRaw:       []int{50, 100, 500, 900, 950},
+			expectedValues: []float32{0, 0, 0.5, 1, 1},
+		},
+		"deadzone-fraction": {
+			givenConfig:    calibrationConfig{Min: intPtr(0), Max: intPtr(1000), Deadzone: "0.1"},
+			givenRaw:       []int{100, 300},
+			expectedValues: []float32{0, 0.25},
+		},
+		"logarithmic": {
+			givenConfig:    calibrationConfig{Min: intPtr(0), Max: intPtr(1000), Curve: "log"},
+			givenRaw:       []int{0, 100, 1000},
+			expectedValues: []float32{0, 0.2787536, 1},
+		},
+		"exponential": {
+			givenConfig:    calibrationConfig{Min: intPtr(0), Max: intPtr(1000), Curve: "EXP"},
+			givenRaw:       []int{0, 500, 1000},
+			expectedValues: []float32{0, 0.24025308, 1},
+		},
+		"custom": {
+			givenConfig: calibrationConfig{
+				Min:    intPtr(0),
+				Max:    intPtr(1000),
+				Curve:  "custom",
+				Points: [][]float64{{0.2, 0}, {0.6, 0.2}, {1, 1}},
+			},
+			givenRaw:       []int{0, 200, 400, 600, 800, 1000},
+			expectedValues: []float32{0, 0, 0.1, 0.2, 0.6, 1},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			calibrations, err := sliderCalibrationsFromConfigs(map[string]calibrationConfig{
+				"2": testCase.givenConfig,
+			})
+			require.NoError(t, err)
+
+			for i, raw := range testCase.givenRaw {
+				assert.InDelta(t, testCase.expectedValues[i], calibrations.apply(2, raw), 0.000001, "raw value %d", raw)
+			}
+		})
+	}
+}
+
+func TestSliderCalibration_uncalibratedSlider(t *testing.T) {
+	calibrations, err := sliderCalibrationsFromConfigs(map[string]calibrationConfig{
+		"0": {Curve: "log"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, float32(1), calibrations.apply(1, 1023), "sliders without calibration map linearly")
+	assert.Equal(t, float32(1), (*sliderCalibrations)(nil).apply(0, 1023), "missing calibration maps linearly")
+}
+
+func TestSliderCalibration_invalid(t *testing.T) {
+	testCases := map[string]calibrationConfig{
+		"min-over-max":        {Min: intPtr(1000), Max: intPtr(10)},
+		"unknown-curve":       {Curve: "sine"},
+		"deadzone-too-big":    {Deadzone: "50%"},
+		"deadzone-gibberish":  {Deadzone: "UwU"},
+		"custom-single-point": {Curve: "custom", Points: [][]float64{{0, 0}}},
+		"custom-unordered":    {Curve: "custom", Points: [][]float64{{0, 0}, {1, 1}, {0.5, 0.5}}},
+		"custom-bad-point":    {Curve: "custom", Points: [][]float64{{0, 0}, {1}}},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			_, err := sliderCalibrationsFromConfigs(map[string]calibrationConfig{"0": testCase})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSliderCalibration_fromYAML(t *testing.T) {
+	givenConfig := `
+slider_calibration:
+  "0": {min: 12, max: 1010, curve: log, deadzone: 2%}
+  "1":
+    deadzone: 0.05
+    curve: custom
+    points: [[0, 0], [0.5, 0.2], [1, 1]]
+`
+
+	userConfig := viper.New()
+	userConfig.SetConfigType("yaml")
+	require.NoError(t, userConfig.ReadConfig(strings.NewReader(givenConfig)))
+
+	calibrationValues := map[string]calibrationConfig{}
+	require.NoError(t, userConfig.UnmarshalKey(configKeySliderCalibration, &calibrationValues))
+
+	calibrations, err := sliderCalibrationsFromConfigs(calibrationValues)
+	require.NoError(t, err)
+
+	require.Len(t, calibrations.m, 2)
+	assert.Equal(t, 12, calibrations.m[0].min)
+	assert.Equal(t, 1010, calibrations.m[0].max)
+	assert.InDelta(t, 0.02, calibrations.m[0].deadzone, 0.000001)
+	assert.InDelta(t, 0.05, calibrations.m[1].deadzone, 0.000001)
+	assert.InDelta(t, 0.2, calibrations.apply(1, 512), 0.01)
+}
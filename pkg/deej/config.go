@@ -10,6 +10,7 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/omriharel/deej/pkg/deej/util"
+	"github.com/omriharel/deej/pkg/device"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -17,23 +18,39 @@ import (
 // CanonicalConfig provides application-wide access to configuration fields,
 // as well as loading/file watching logic for deej's configuration file
 type CanonicalConfig struct {
-	SliderMapping *sliderMap
-	MuteMapping   *MuteMap
+
+	// every mixer deej talks to, the first one is the primary mixer
+	Devices []*deviceProfile
+
+	// mappings of the primary mixer
+	SliderMapping  *sliderMap
+	EncoderMapping *sliderMap
+	MuteMapping    *MuteMap
+	GestureMapping *GestureMap
+
+	SliderCalibration *sliderCalibrations
+	NoiseReduction    *noiseReduction
+	VolumeRamp        *volumeRamps
+	Encoders          *encoderSettings
 
 	ConnectionInfo struct {
-		COMPort  string
-		BaudRate int
+		COMPort        string
+		BaudRate       int
+		DataBits       int
+		Parity         string
+		StopBits       string
+		DTR            bool
+		RTS            bool
+		ProbeBaudRates []int
 	}
 
 	InvertSliders bool
 
-	NoiseReductionLevel string
-
 	logger             *zap.SugaredLogger
 	notifier           Notifier
 	stopWatcherChannel chan bool
 
-	reloadConsumers []chan bool
+	bus *eventBus
 
 	userConfig     *viper.Viper
 	internalConfig *viper.Viper
@@ -62,12 +79,29 @@ const (
 
 	configType = "yaml"
 
+	configKeyDevices             = "devices"
 	configKeySliderMapping       = "slider_mapping"
+	configKeyEncoderMapping      = "encoder_mapping"
+	configKeyEncoderStep         = "encoder_step"
+	configKeyEncoderAcceleration = "encoder_acceleration"
 	configKeyMuteMapping         = "mute_mapping"
+	configKeyGestureMapping      = "gesture_mapping"
+	configKeyGestureTiming       = "gesture_timing"
 	configKeyInvertSliders       = "invert_sliders"
 	configKeyCOMPort             = "com_port"
 	configKeyBaudRate            = "baud_rate"
+	configKeyDataBits            = "data_bits"
+	configKeyParity              = "parity"
+	configKeyStopBits            = "stop_bits"
+	configKeyDTR                 = "dtr"
+	configKeyRTS                 = "rts"
+	configKeyProbeBaudRates      = "probe_baud_rates"
 	configKeyNoiseReductionLevel = "noise_reduction"
+	configKeyNoiseFilter         = "noise_filter"
+	configKeySliderNoise         = "slider_noise_reduction"
+	configKeySliderCalibration   = "slider_calibration"
+	configKeyVolumeRamp          = "volume_ramp"
+	configKeySliderVolumeRamp    = "slider_volume_ramp"
 
 	defaultCOMPort  = "COM4"
 	defaultBaudRate = 9600
@@ -77,13 +111,13 @@ const (
 var internalConfigPath = path.Join(".", logDirectory)
 
 // NewConfig creates a config instance for the deej object and sets up viper instances for deej's config files
-func NewConfig(logger *zap.SugaredLogger, notifier Notifier) (*CanonicalConfig, error) {
+func NewConfig(logger *zap.SugaredLogger, notifier Notifier, bus *eventBus) (*CanonicalConfig, error) {
 	logger = logger.Named("config")
 
 	cc := &CanonicalConfig{
 		logger:             logger,
 		notifier:           notifier,
-		reloadConsumers:    []chan bool{},
+		bus:                bus,
 		stopWatcherChannel: make(chan bool),
 	}
 
@@ -94,13 +128,15 @@ func NewConfig(logger *zap.SugaredLogger, notifier Notifier) (*CanonicalConfig,
 	userConfig.AddConfigPath(userConfigPath)
 
 	userConfig.SetDefault(configKeySliderMapping, map[string][]string{})
-	userConfig.SetDefault(configKeyMuteMapping, map[string][]string{
-		"0": {"mic"},
-		"1": {"master"},
+	userConfig.SetDefault(configKeyEncoderMapping, map[string][]string{})
+	userConfig.SetDefault(configKeyMuteMapping, map[string]interface{}{
+		"0": []string{"mic"},
+		"1": []string{"master"},
 	})
 	userConfig.SetDefault(configKeyInvertSliders, false)
 	userConfig.SetDefault(configKeyCOMPort, defaultCOMPort)
 	userConfig.SetDefault(configKeyBaudRate, defaultBaudRate)
+	setSerialDefaults(userConfig)
 
 	internalConfig := viper.New()
 	internalConfig.SetConfigName(internalConfigName)
@@ -156,22 +192,21 @@ func (cc *CanonicalConfig) Load() error {
 
 	cc.logger.Info("Loaded config successfully")
 	cc.logger.Infow("Config values",
+		"devices", cc.Devices,
 		"sliderMapping", cc.SliderMapping,
+		"encoderMapping", cc.EncoderMapping,
+		"encoders", cc.Encoders,
 		"muteMapping", cc.MuteMapping,
+		"gestureMapping", cc.GestureMapping,
 		"connectionInfo", cc.ConnectionInfo,
+		"sliderCalibration", cc.SliderCalibration,
+		"noiseReduction", cc.NoiseReduction,
+		"volumeRamp", cc.VolumeRamp,
 		"invertSliders", cc.InvertSliders)
 
 	return nil
 }
 
-// SubscribeToChanges allows external components to receive updates when the config is reloaded
-func (cc *CanonicalConfig) SubscribeToChanges() chan bool {
-	c := make(chan bool)
-	cc.reloadConsumers = append(cc.reloadConsumers, c)
-
-	return c
-}
-
 // WatchConfigFileChanges starts watching for configuration file changes
 // and attempts reloading the config when they happen
 func (cc *CanonicalConfig) WatchConfigFileChanges() {
@@ -235,46 +270,89 @@ func (cc *CanonicalConfig) ChannelAppGet(chanId int) []string {
 	userConfig.AddConfigPath(userConfigPath)
 
 	userConfig.SetDefault(configKeySliderMapping, map[string][]string{})
-	userConfig.SetDefault(configKeyMuteMapping, map[string][]string{
-		"0": {"mic"},
-		"1": {"master"},
+	userConfig.SetDefault(configKeyMuteMapping, map[string]interface{}{
+		"0": []string{"mic"},
+		"1": []string{"master"},
 	})
 	userConfig.SetDefault(configKeyInvertSliders, false)
 	userConfig.SetDefault(configKeyCOMPort, defaultCOMPort)
 	userConfig.SetDefault(configKeyBaudRate, defaultBaudRate)
+	setSerialDefaults(userConfig)
 	userConfig.ReadInConfig()
 
 	chanStr := strconv.Itoa(chanId)
-	appMap := userConfig.GetStringMapStringSlice(configKeySliderMapping)
+	appMap := userConfig.GetStringMapStringSlice(cc.primarySliderMappingKey())
 	appList := appMap[chanStr]
 	return appList
 }
 
 func (cc *CanonicalConfig) ChannelAppsSet(chanId int, apps []string) {
 	chanStr := strconv.Itoa(chanId)
-	sliderMapping := cc.userConfig.GetStringMapStringSlice(configKeySliderMapping)
+	sliderMappingKey := cc.primarySliderMappingKey()
+	sliderMapping := cc.userConfig.GetStringMapStringSlice(sliderMappingKey)
 	sliderMapping[chanStr] = apps
-	cc.userConfig.Set(configKeySliderMapping, sliderMapping)
+	cc.userConfig.Set(sliderMappingKey, sliderMapping)
 	log.Println(chanStr, sliderMapping)
 	cc.populateFromVipers()
 }
 
 func (cc *CanonicalConfig) populateFromVipers() error {
 
-	// merge the slider mappings from the user and internal configs
-	cc.SliderMapping = sliderMapFromConfigs(
-		cc.userConfig.GetStringMapStringSlice(configKeySliderMapping),
-		cc.internalConfig.GetStringMapStringSlice(configKeySliderMapping),
-	)
-
-	// merge mute mappings from user config
-	cc.MuteMapping = muteMapFromConfigs(
-		cc.userConfig.GetStringMapStringSlice(configKeySliderMapping), //configKeyMuteMapping),
-	)
-
 	// get the rest of the config fields - viper saves us a lot of effort here
 	cc.ConnectionInfo.COMPort = cc.userConfig.GetString(configKeyCOMPort)
 
+	// every mixer has mappings of its own, configs without a devices section have a single one
+	devices, err := deviceProfilesFromConfigs(cc.userConfig, cc.ConnectionInfo.COMPort)
+	if err != nil {
+		cc.logger.Warnw("Invalid devices specified, using a single device at the configured port",
+			"key", configKeyDevices,
+			"error", err)
+
+		devices = []*deviceProfile{{COMPort: cc.ConnectionInfo.COMPort}}
+	}
+
+	// gestures keep recognizing with default timing when it's invalid, they're unusable without any
+	gestureTiming, err := gestureTimingFromConfigs(cc.userConfig.GetStringMapString(configKeyGestureTiming))
+	if err != nil {
+		cc.logger.Warnw("Invalid gesture timing specified, using default timing",
+			"key", configKeyGestureTiming,
+			"error", err)
+	}
+
+	for _, profile := range devices {
+		cc.populateSliderSettings(profile)
+
+		// merge the slider mappings from the user and internal configs
+		profile.SliderMapping = sliderMapFromConfigs(
+			cc.userConfig.GetStringMapStringSlice(profile.mappingKey(configKeySliderMapping)),
+			cc.internalConfig.GetStringMapStringSlice(profile.mappingKey(configKeySliderMapping)),
+		)
+
+		// encoders, buttons and gestures are only ever mapped in the user config
+		profile.EncoderMapping = sliderMapFromConfigs(
+			cc.userConfig.GetStringMapStringSlice(profile.mappingKey(configKeyEncoderMapping)),
+			map[string][]string{},
+		)
+		profile.MuteMapping = muteMapFromConfigs(cc.userConfig.GetStringMap(profile.mappingKey(configKeyMuteMapping)))
+		profile.GestureMapping = gestureMapFromConfigs(
+			cc.userConfig.GetStringMap(profile.mappingKey(configKeyGestureMapping)),
+			gestureTiming,
+		)
+
+		// keep state of buttons that are already in use
+		profile.carryOver(cc.device(profile.Name))
+	}
+
+	// the primary mixer's mappings are the ones shown and edited by the UI
+	cc.Devices = devices
+	cc.SliderMapping = devices[0].SliderMapping
+	cc.EncoderMapping = devices[0].EncoderMapping
+	cc.MuteMapping = devices[0].MuteMapping
+	cc.GestureMapping = devices[0].GestureMapping
+	cc.SliderCalibration = devices[0].SliderCalibration
+	cc.NoiseReduction = devices[0].NoiseReduction
+	cc.VolumeRamp = devices[0].VolumeRamp
+
 	cc.ConnectionInfo.BaudRate = cc.userConfig.GetInt(configKeyBaudRate)
 	if cc.ConnectionInfo.BaudRate <= 0 {
 		cc.logger.Warnw("Invalid baud rate specified, using default value",
@@ -285,18 +363,114 @@ func (cc *CanonicalConfig) populateFromVipers() error {
 		cc.ConnectionInfo.BaudRate = defaultBaudRate
 	}
 
+	cc.ConnectionInfo.DataBits = cc.userConfig.GetInt(configKeyDataBits)
+	cc.ConnectionInfo.Parity = cc.userConfig.GetString(configKeyParity)
+	cc.ConnectionInfo.StopBits = cc.userConfig.GetString(configKeyStopBits)
+	cc.ConnectionInfo.DTR = cc.userConfig.GetBool(configKeyDTR)
+	cc.ConnectionInfo.RTS = cc.userConfig.GetBool(configKeyRTS)
+	cc.ConnectionInfo.ProbeBaudRates = cc.userConfig.GetIntSlice(configKeyProbeBaudRates)
+
+	// validate serial framing right away, rather than on every connection attempt
+	if _, err := cc.SerialOptions().Mode(); err != nil {
+		cc.logger.Warnw("Invalid serial framing specified, using default values",
+			"error", err,
+			"defaultValue", device.DefaultSerialOptions())
+
+		defaults := device.DefaultSerialOptions()
+		cc.ConnectionInfo.DataBits = defaults.DataBits
+		cc.ConnectionInfo.Parity = defaults.Parity
+		cc.ConnectionInfo.StopBits = defaults.StopBits
+	}
+
+	// nil falls back to the default step without acceleration
+	encoders, err := encoderSettingsFromConfigs(
+		cc.userConfig.GetString(configKeyEncoderStep),
+		cc.userConfig.GetFloat64(configKeyEncoderAcceleration),
+	)
+	if err != nil {
+		cc.logger.Warnw("Invalid encoder settings specified, using default step without acceleration",
+			"error", err)
+	}
+	cc.Encoders = encoders
+
 	cc.InvertSliders = cc.userConfig.GetBool(configKeyInvertSliders)
-	cc.NoiseReductionLevel = cc.userConfig.GetString(configKeyNoiseReductionLevel)
 
 	cc.logger.Debug("Populated config fields from vipers")
 
 	return nil
 }
 
+// populateSliderSettings reads calibration, noise reduction and volume ramps of a mixer's sliders.
+// the levels, filter and ramp they fall back to are shared by every mixer
+func (cc *CanonicalConfig) populateSliderSettings(profile *deviceProfile) {
+	logger := cc.logger.With("device", profile)
+
+	// calibration is all or nothing, a half-applied one would be more confusing than none
+	calibrationKey := profile.mappingKey(configKeySliderCalibration)
+	calibrationValues := map[string]calibrationConfig{}
+	if err := cc.userConfig.UnmarshalKey(calibrationKey, &calibrationValues); err != nil {
+		logger.Warnw("Failed to parse slider calibration, sliders will be mapped linearly",
+			"key", calibrationKey,
+			"error", err)
+	} else if profile.SliderCalibration, err = sliderCalibrationsFromConfigs(calibrationValues); err != nil {
+		logger.Warnw("Invalid slider calibration specified, sliders will be mapped linearly",
+			"key", calibrationKey,
+			"error", err)
+	}
+
+	// same goes for noise reduction, nil falls back to the default level for every slider
+	noiseKey := profile.mappingKey(configKeySliderNoise)
+	noiseValues := map[string]noiseConfig{}
+	if err := cc.userConfig.UnmarshalKey(noiseKey, &noiseValues); err != nil {
+		logger.Warnw("Failed to parse per-slider noise reduction, using default noise reduction",
+			"key", noiseKey,
+			"error", err)
+	} else if profile.NoiseReduction, err = noiseReductionFromConfigs(
+		cc.userConfig.GetString(configKeyNoiseReductionLevel),
+		cc.userConfig.GetString(configKeyNoiseFilter),
+		noiseValues,
+	); err != nil {
+		logger.Warnw("Invalid noise reduction specified, using default noise reduction",
+			"error", err)
+	}
+
+	// an invalid ramp shouldn't make sliders unusable, so fall back to instant volume changes
+	volumeRamp, err := volumeRampsFromConfigs(
+		cc.userConfig.GetString(configKeyVolumeRamp),
+		cc.userConfig.GetStringMapString(profile.mappingKey(configKeySliderVolumeRamp)),
+	)
+	if err != nil {
+		logger.Warnw("Invalid volume ramp specified, volume will change instantly",
+			"error", err)
+	}
+	profile.VolumeRamp = volumeRamp
+}
+
+// SerialOptions returns framing used to open serial connections
+func (cc *CanonicalConfig) SerialOptions() device.SerialOptions {
+	return device.SerialOptions{
+		BaudRate: cc.ConnectionInfo.BaudRate,
+		DataBits: cc.ConnectionInfo.DataBits,
+		Parity:   cc.ConnectionInfo.Parity,
+		StopBits: cc.ConnectionInfo.StopBits,
+		DTR:      cc.ConnectionInfo.DTR,
+		RTS:      cc.ConnectionInfo.RTS,
+	}
+}
+
+func setSerialDefaults(userConfig *viper.Viper) {
+	defaults := device.DefaultSerialOptions()
+
+	userConfig.SetDefault(configKeyDataBits, defaults.DataBits)
+	userConfig.SetDefault(configKeyParity, defaults.Parity)
+	userConfig.SetDefault(configKeyStopBits, defaults.StopBits)
+	userConfig.SetDefault(configKeyDTR, defaults.DTR)
+	userConfig.SetDefault(configKeyRTS, defaults.RTS)
+	userConfig.SetDefault(configKeyProbeBaudRates, device.DefaultProbeBaudRates)
+}
+
 func (cc *CanonicalConfig) onConfigReloaded() {
 	cc.logger.Debug("Notifying consumers about configuration reload")
 
-	for _, consumer := range cc.reloadConsumers {
-		consumer <- true
-	}
+	cc.bus.configReloads.publish(configReloadEvent{})
 }
@@ -5,10 +5,9 @@ package deej
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"sort"
-	"time"
+	"sync"
 
 	"go.uber.org/zap"
 
@@ -28,14 +27,19 @@ type Deej struct {
 	logger   *zap.SugaredLogger
 	notifier Notifier
 	config   *CanonicalConfig
-	serial   *SerialIO
 	sessions *SessionMap
 
 	stopChannel chan bool
 	version     string
 	verbose     bool
 
-	connection *device.Connection
+	// everything running in the background stops with this context, event subscriptions included
+	ctx    context.Context
+	cancel context.CancelFunc
+	bus    *eventBus
+
+	// every device deej talks to, set up once the config is loaded
+	mixers []*mixer
 }
 
 // NewDeej creates a Deej instance
@@ -48,8 +52,12 @@ func NewDeej(logger *zap.SugaredLogger, verbose bool) (*Deej, error) {
 		return nil, fmt.Errorf("create new ToastNotifier: %w", err)
 	}
 
-	config, err := NewConfig(logger, notifier)
+	ctx, cancel := context.WithCancel(context.Background())
+	bus := newEventBus(ctx, logger)
+
+	config, err := NewConfig(logger, notifier, bus)
 	if err != nil {
+		cancel()
 		logger.Errorw("Failed to create Config", "error", err)
 		return nil, fmt.Errorf("create new Config: %w", err)
 	}
@@ -60,17 +68,11 @@ func NewDeej(logger *zap.SugaredLogger, verbose bool) (*Deej, error) {
 		config:      config,
 		stopChannel: make(chan bool),
 		verbose:     verbose,
-		connection:  &device.Connection{},
-	}
-
-	serial, err := NewSerialIO(d, logger)
-	if err != nil {
-		logger.Errorw("Failed to create SerialIO", "error", err)
-		return nil, fmt.Errorf("create new SerialIO: %w", err)
+		ctx:         ctx,
+		cancel:      cancel,
+		bus:         bus,
 	}
 
-	d.serial = serial
-
 	sessionFinder, err := newSessionFinder(logger)
 	if err != nil {
 		logger.Errorw("Failed to create SessionFinder", "error", err)
@@ -100,6 +102,12 @@ func (d *Deej) Initialize() error {
 		return fmt.Errorf("load config during init: %w", err)
 	}
 
+	// every configured device gets a mixer of its own
+	if err := d.createMixers(); err != nil {
+		d.logger.Errorw("Failed to create mixers", "error", err)
+		return fmt.Errorf("create mixers: %w", err)
+	}
+
 	// initialize the session map
 	if err := d.sessions.initialize(); err != nil {
 		d.logger.Errorw("Failed to initialize session map", "error", err)
@@ -172,72 +180,95 @@ func (d *Deej) setupInterruptHandler() {
 	}()
 }
 
+// DetectPorts looks for mixers using configured serial framing, mixers configured
+// by their USB adapters are told apart without being probed
+func (d *Deej) DetectPorts() ([]string, error) {
+	var known []device.USBFilter
+	for _, profile := range d.config.devices() {
+		if !profile.USB.IsZero() {
+			known = append(known, profile.USB)
+		}
+	}
+
+	options := device.DiscoveryOptions{
+		SerialOptions: d.config.SerialOptions(),
+		BaudRates:     d.config.ConnectionInfo.ProbeBaudRates,
+		Known:         known,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout())
+	defer cancel()
+
+	detected, err := device.Discover(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("discover devices: %w", err)
+	}
+
+	d.logger.Infow("Detected devices", "devices", detected)
+
+	return device.Addresses(detected), nil
+}
+
+// DevicePortSet points the primary mixer at another port
 func (d *Deej) DevicePortSet(deviceName string) {
-	d.connection.DevicePortSet(deviceName)
+	primary := d.primaryMixer()
+	if primary == nil {
+		return
+	}
+
+	// the port is looked up in the config when reconnecting, so it goes there first
+	d.config.setDevicePort(primary.name, deviceName)
 	fmt.Println("\033[31;1;4mUwU\033[0m")
-	d.config.userConfig.Set(configKeyCOMPort, deviceName)
+	primary.connection.DevicePortSet(deviceName)
+}
+
+// DeviceInfo returns what the primary mixer told about itself
+func (d *Deej) DeviceInfo() (device.DeviceInfo, bool) {
+	primary := d.primaryMixer()
+	if primary == nil {
+		return device.DeviceInfo{}, false
+	}
+
+	return primary.connection.DeviceInfo()
 }
 
 func (d *Deej) run() {
 	d.logger.Info("Run loop starting")
 
+	// keep connection parameters in sync with the config
+	go d.watchMixerConfigs()
+
 	// watch the config file for changes
 	go d.config.WatchConfigFileChanges()
 
-	// connect to the arduino for the first time
+	// keep the mixers informed about real volumes
+	go d.runFeedback(d.ctx)
+
+	// tell how to set the mixer up the first time any of them can't be connected
+	mixerStates := d.bus.mixerStates.subscribe(d.ctx, subscribeOptions[MixerState]{overflow: dropOldest})
 	go func() {
-		comPort := d.config.ConnectionInfo.COMPort
-		//var lock sync.Mutex
-		infoWindowShown := false
-		for {
-			err := d.connection.ConnectAndDispatch(context.TODO(), comPort, d.sessions) // TODO: make
-			if err != nil {
-				log.Print("connection failed:", err)
-			}
-			if !infoWindowShown {
-				ui.ConfigInfo()
-				infoWindowShown = true
+		var infoWindowShown sync.Once
+		for state := range mixerStates.events {
+			if state.State == device.StateDisconnected {
+				infoWindowShown.Do(func() {
+					go ui.ConfigInfo()
+				})
 			}
-			time.Sleep(3 * time.Second)
-			// tutaj nic nie słucha na port change
-			// go func() {
-			// 	if !lock.TryLock() {
-			// 		return
-			// 	}
-			// 	defer lock.Unlock()
-
-			// 	ui.ShowUI(nil, d, d, d.config, d.config)
-			// }()
 		}
-		// if err := d.serial.Start(); err != nil {
-		// 	d.logger.Warnw("Failed to start first-time serial connection", "error", err)
-
-		// 	// If the port is busy, that's because something else is connected - notify and quit
-		// 	if errors.Is(err, os.ErrPermission) {
-		// 		d.logger.Warnw("Serial port seems busy, notifying user and closing",
-		// 			"comPort", d.config.ConnectionInfo.COMPort)
-
-		// 		d.notifier.Notify(fmt.Sprintf("Can't connect to %s!", d.config.ConnectionInfo.COMPort),
-		// 			"This serial port is busy, make sure to close any serial monitor or other deej instance.")
-
-		// 		d.signalStop()
-
-		// 		// also notify if the COM port they gave isn't found, maybe their config is wrong
-		// 	} else if errors.Is(err, os.ErrNotExist) {
-		// 		d.logger.Warnw("Provided COM port seems wrong, notifying user and closing",
-		// 			"comPort", d.config.ConnectionInfo.COMPort)
+	}()
 
-		// 		d.notifier.Notify(fmt.Sprintf("Can't connect to %s!", d.config.ConnectionInfo.COMPort),
-		// 			"This serial port doesn't exist, check your configuration and make sure it's set correctly.")
+	// connect to every mixer for the first time, each one reconnects on its own from then on
+	for _, mixer := range d.mixers {
+		go d.runMixer(d.ctx, mixer)
+	}
 
-		// 		d.signalStop()
-		// 	}
-		// }
-	}()
+	// and right away once it's plugged back in, where the platform tells about it
+	go d.watchHotplug(d.ctx, device.DefaultHotplugSource())
 
 	// wait until stopped (gracefully)
 	<-d.stopChannel
 	d.logger.Debug("Stop channel signaled, terminating")
+	d.cancel()
 
 	if err := d.stop(); err != nil {
 		d.logger.Warnw("Failed to stop deej", "error", err)
@@ -257,7 +288,6 @@ func (d *Deej) stop() error {
 	d.logger.Info("Stopping")
 
 	d.config.StopWatchingConfigFile()
-	d.serial.Stop()
 
 	// release the session map
 	if err := d.sessions.release(); err != nil {
@@ -0,0 +1,202 @@
+package deej
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/omriharel/deej/pkg/device"
+)
+
+// deviceProfile is what deej knows about one of the mixers it talks to: where to find it,
+// and its own namespace of sliders, buttons and encoders along with the settings of its sliders
+type deviceProfile struct {
+
+	// empty for the only mixer of configs without a devices section
+	Name string
+
+	// a mixer is found by its USB IDs or serial number when set, by its port otherwise
+	COMPort string
+	USB     device.USBFilter
+
+	SliderMapping  *sliderMap
+	MuteMapping    *MuteMap
+	EncoderMapping *sliderMap
+	GestureMapping *GestureMap
+
+	SliderCalibration *sliderCalibrations
+	NoiseReduction    *noiseReduction
+	VolumeRamp        *volumeRamps
+}
+
+// deviceConfig is a single entry of the devices section
+type deviceConfig struct {
+	COMPort      string `mapstructure:"com_port"`
+	SerialNumber string `mapstructure:"serial_number"`
+	USBID        string `mapstructure:"usb_id"`
+}
+
+// deviceProfilesFromConfigs reads the devices section, returning profiles without their mappings
+// sorted by name. the first one is the primary mixer. without a devices section, there's a single
+// unnamed mixer at the given port
+func deviceProfilesFromConfigs(userConfig *viper.Viper, comPort string) ([]*deviceProfile, error) {
+	if !userConfig.IsSet(configKeyDevices) {
+		return []*deviceProfile{{COMPort: comPort}}, nil
+	}
+
+	configValues := map[string]deviceConfig{}
+	if err := userConfig.UnmarshalKey(configKeyDevices, &configValues); err != nil {
+		return nil, fmt.Errorf("parse devices: %w", err)
+	}
+
+	if len(configValues) == 0 {
+		return nil, fmt.Errorf("devices section is empty")
+	}
+
+	profiles := make([]*deviceProfile, 0, len(configValues))
+	for name, value := range configValues {
+		profile := &deviceProfile{
+			Name:    strings.ToLower(name),
+			COMPort: strings.TrimSpace(value.COMPort),
+		}
+
+		if value.USBID != "" {
+			usb, err := device.ParseUSBID(value.USBID)
+			if err != nil {
+				return nil, fmt.Errorf("device %q: %w", name, err)
+			}
+			profile.USB = usb
+		}
+		profile.USB.SerialNumber = strings.TrimSpace(value.SerialNumber)
+
+		if (profile.COMPort == "") == profile.USB.IsZero() {
+			return nil, fmt.Errorf("device %q needs either com_port, or usb_id and/or serial_number", name)
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles, nil
+}
+
+// pluggedIn tells if a hotplug event is about this profile's mixer
+func (p *deviceProfile) pluggedIn(event device.HotplugEvent) bool {
+	if event.Action != device.HotplugAdd {
+		return false
+	}
+
+	if !p.USB.IsZero() {
+		return event.Matches(p.USB)
+	}
+
+	return event.IsPort(p.COMPort)
+}
+
+// mappingKey returns where the profile's mapping or per-slider settings of given kind live in the config.
+// named devices keep them under their names, e.g. slider_mapping.pedals
+func (p *deviceProfile) mappingKey(key string) string {
+	if p.Name == "" {
+		return key
+	}
+
+	return key + "." + p.Name
+}
+
+// carryOver keeps state of buttons and gestures from the same mixer's previous profile
+func (p *deviceProfile) carryOver(previous *deviceProfile) {
+	if previous == nil {
+		return
+	}
+
+	p.MuteMapping.carryOver(previous.MuteMapping)
+	p.GestureMapping.carryOver(previous.GestureMapping)
+}
+
+func (p *deviceProfile) String() string {
+	name := p.Name
+	if name == "" {
+		name = "default"
+	}
+
+	if !p.USB.IsZero() {
+		return fmt.Sprintf("%s (%s)", name, p.USB)
+	}
+
+	return fmt.Sprintf("%s (%s)", name, p.COMPort)
+}
+
+// device returns the profile of the named mixer, nil for mixers no longer in the config.
+// configs put together without any devices have a single unnamed one, made of the primary mappings
+func (cc *CanonicalConfig) device(name string) *deviceProfile {
+	for _, profile := range cc.devices() {
+		if profile.Name == name {
+			return profile
+		}
+	}
+
+	return nil
+}
+
+// devices returns profiles of every mixer, see device
+func (cc *CanonicalConfig) devices() []*deviceProfile {
+	if len(cc.Devices) > 0 {
+		return cc.Devices
+	}
+
+	return []*deviceProfile{{
+		COMPort:        cc.ConnectionInfo.COMPort,
+		SliderMapping:  cc.SliderMapping,
+		MuteMapping:    cc.MuteMapping,
+		EncoderMapping: cc.EncoderMapping,
+		GestureMapping: cc.GestureMapping,
+
+		SliderCalibration: cc.SliderCalibration,
+		NoiseReduction:    cc.NoiseReduction,
+		VolumeRamp:        cc.VolumeRamp,
+	}}
+}
+
+// sliderCalibration and sliderNoise return settings of the named mixer's sliders,
+// the defaults for mixers no longer in the config
+func (cc *CanonicalConfig) sliderCalibration(name string) *sliderCalibrations {
+	if profile := cc.device(name); profile != nil {
+		return profile.SliderCalibration
+	}
+
+	return nil
+}
+
+func (cc *CanonicalConfig) sliderNoise(name string) *noiseReduction {
+	if profile := cc.device(name); profile != nil {
+		return profile.NoiseReduction
+	}
+
+	return nil
+}
+
+// primarySliderMappingKey tells where the UI should read and write slider mappings
+func (cc *CanonicalConfig) primarySliderMappingKey() string {
+	return cc.devices()[0].mappingKey(configKeySliderMapping)
+}
+
+// setDevicePort points the named mixer at another port, as picked in the UI
+func (cc *CanonicalConfig) setDevicePort(name string, port string) {
+	if profile := cc.device(name); profile != nil {
+		profile.COMPort = port
+		profile.USB = device.USBFilter{}
+	}
+
+	if name == "" {
+		cc.userConfig.Set(configKeyCOMPort, port)
+		cc.ConnectionInfo.COMPort = port
+		return
+	}
+
+	cc.userConfig.Set(configKeyDevices+"."+name, map[string]interface{}{"com_port": port})
+}
@@ -0,0 +1,151 @@
+package deej
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDeviceProfilesFromConfigs(t *testing.T) {
+	type testCase struct {
+		givenConfig      string
+		expectedProfiles []string
+		expectedKeys     []string
+		expectedError    bool
+	}
+
+	testCases := map[string]testCase{
+		"no devices": {
+			givenConfig:      "com_port: COM4",
+			expectedProfiles: []string{"default (COM4)"},
+			expectedKeys:     []string{"slider_mapping"},
+		},
+		"named devices": {
+			givenConfig: `
+devices:
+  Pedals: {serial_number: " A10K3UBJ "}
+  desk: {com_port: COM16}
+`,
+			expectedProfiles: []string{"desk (COM16)", "pedals (serial number A10K3UBJ)"},
+			expectedKeys:     []string{"slider_mapping.desk", "slider_mapping.pedals"},
+		},
+		"usb ids": {
+			givenConfig: `
+devices:
+  desk: {usb_id: "2341:8036"}
+  pedals: {usb_id: "2341:8036", serial_number: A10K3UBJ}
+`,
+			expectedProfiles: []string{"desk (USB ID 2341:8036)", "pedals (USB ID 2341:8036, serial number A10K3UBJ)"},
+			expectedKeys:     []string{"slider_mapping.desk", "slider_mapping.pedals"},
+		},
+		"invalid usb id": {
+			givenConfig:   "devices: {desk: {usb_id: arduino}}",
+			expectedError: true,
+		},
+		"both port and usb id": {
+			givenConfig:   "devices: {desk: {com_port: COM16, usb_id: \"2341:8036\"}}",
+			expectedError: true,
+		},
+		"both port and serial number": {
+			givenConfig:   "devices: {desk: {com_port: COM16, serial_number: A10K3UBJ}}",
+			expectedError: true,
+		},
+		"neither port nor serial number": {
+			givenConfig:   "devices: {desk: {baud_rate: 9600}}",
+			expectedError: true,
+		},
+		"empty devices": {
+			givenConfig:   "devices: {}",
+			expectedError: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			userConfig := viper.New()
+			userConfig.SetConfigType("yaml")
+			require.NoError(t, userConfig.ReadConfig(strings.NewReader(testCase.givenConfig)))
+
+			profiles, err := deviceProfilesFromConfigs(userConfig, userConfig.GetString(configKeyCOMPort))
+			if testCase.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			names, keys := []string{}, []string{}
+			for _, profile := range profiles {
+				names = append(names, profile.String())
+				keys = append(keys, profile.mappingKey(configKeySliderMapping))
+			}
+
+			assert.Equal(t, testCase.expectedProfiles, names)
+			assert.Equal(t, testCase.expectedKeys, keys)
+		})
+	}
+}
+
+func TestCanonicalConfig_sliderSettingsPerDevice(t *testing.T) {
+	givenConfig := `
+devices:
+  desk: {com_port: COM16}
+  pedals: {com_port: COM17}
+slider_calibration:
+  desk:
+    "0": {min: 0, max: 511}
+slider_noise_reduction:
+  pedals:
+    "0": {filter: "median:3"}
+slider_volume_ramp:
+  desk:
+    "0": 300ms
+`
+
+	userConfig := viper.New()
+	userConfig.SetConfigType("yaml")
+	require.NoError(t, userConfig.ReadConfig(strings.NewReader(givenConfig)))
+
+	cc := &CanonicalConfig{
+		logger:         zap.NewNop().Sugar(),
+		userConfig:     userConfig,
+		internalConfig: viper.New(),
+	}
+	require.NoError(t, cc.populateFromVipers())
+
+	desk, pedals := cc.device("desk"), cc.device("pedals")
+	require.NotNil(t, desk)
+	require.NotNil(t, pedals)
+
+	// slider 0 of one mixer has nothing to do with slider 0 of the other
+	assert.Equal(t, float32(1), desk.SliderCalibration.apply(0, 511))
+	assert.InDelta(t, 0.5, pedals.SliderCalibration.apply(0, 511), 0.01)
+
+	assert.Equal(t, noiseFilterNone, desk.NoiseReduction.forSlider(0).filter)
+	assert.Equal(t, noiseFilterMedian, pedals.NoiseReduction.forSlider(0).filter)
+
+	assert.Equal(t, 300*time.Millisecond, desk.VolumeRamp.forSlider(0))
+	assert.Zero(t, pedals.VolumeRamp.forSlider(0))
+
+	// and each mixer's values go through its own calibration
+	deej := newTestDeej(t, cc)
+	sliderMoves := deej.bus.sliderMoves.subscribe(deej.ctx, subscribeOptions[SliderMoveEvent]{})
+
+	for _, name := range []string{"desk", "pedals"} {
+		sio, err := NewSerialIO(deej, zap.NewNop().Sugar(), name)
+		require.NoError(t, err)
+		sio.OnVolume([]int{511})
+	}
+
+	values := map[string]float32{}
+	for _, event := range receive(sliderMoves) {
+		values[event.Device] = event.PercentValue
+	}
+
+	assert.Equal(t, map[string]float32{"desk": 1, "pedals": 0.49}, values)
+}
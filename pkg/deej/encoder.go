@@ -0,0 +1,89 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// volume a single detent turns by default, the same as most keyboard volume keys
+	defaultEncoderStep = 0.02
+
+	// anything bigger makes a single detent skip most of the range
+	maxEncoderStep = 0.25
+
+	// beyond this, a quick flick slams the volume to either end
+	maxEncoderAcceleration = 2
+)
+
+// encoderSettings holds how detents of rotary encoders turn into volume changes
+type encoderSettings struct {
+	step         float64
+	acceleration float64
+}
+
+var defaultEncoderSettings = encoderSettings{step: defaultEncoderStep}
+
+func encoderSettingsFromConfigs(step string, acceleration float64) (*encoderSettings, error) {
+	settings := &encoderSettings{step: defaultEncoderStep, acceleration: acceleration}
+
+	if strings.TrimSpace(step) != "" {
+		var err error
+		if settings.step, err = parseEncoderStep(step); err != nil {
+			return nil, err
+		}
+	}
+
+	if acceleration < 0 || acceleration > maxEncoderAcceleration {
+		return nil, fmt.Errorf("encoder acceleration %.2f out of range 0 to %d", acceleration, maxEncoderAcceleration)
+	}
+
+	return settings, nil
+}
+
+// parseEncoderStep accepts both percents ("2%") and fractions ("0.02")
+func parseEncoderStep(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+
+	divider := 1.0
+	if strings.HasSuffix(value, "%") {
+		value = strings.TrimSuffix(value, "%")
+		divider = 100
+	}
+
+	step, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid encoder step %q: %w", value, err)
+	}
+
+	step /= divider
+	if step <= 0 || step > maxEncoderStep {
+		return 0, fmt.Errorf("encoder step %.2f out of range 0 to %.2f", step, maxEncoderStep)
+	}
+
+	return step, nil
+}
+
+// change returns how much volume the given detents, turned since the previous frame, add or take.
+// the more detents arrive in a single frame the faster the encoder spins, and acceleration
+// makes each of them count for more, so big changes don't take forever
+func (es *encoderSettings) change(delta int) float32 {
+	if es == nil {
+		es = &defaultEncoderSettings
+	}
+
+	detents := math.Abs(float64(delta))
+	change := es.step * detents * (1 + es.acceleration*(detents-1))
+
+	return float32(math.Copysign(change, float64(delta)))
+}
+
+func (es *encoderSettings) String() string {
+	if es == nil {
+		es = &defaultEncoderSettings
+	}
+
+	return fmt.Sprintf("<step %.3f, acceleration %.2f>", es.step, es.acceleration)
+}
@@ -0,0 +1,68 @@
+package deej
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoderSettingsFromConfigs(t *testing.T) {
+	type testCase struct {
+		givenStep         string
+		givenAcceleration float64
+		expectedSettings  *encoderSettings
+		expectedError     bool
+	}
+
+	testCases := map[string]testCase{
+		"defaults":       {expectedSettings: &encoderSettings{step: defaultEncoderStep}},
+		"percent":        {givenStep: "5%", expectedSettings: &encoderSettings{step: 0.05}},
+		"fraction":       {givenStep: "0.01", givenAcceleration: 0.5, expectedSettings: &encoderSettings{step: 0.01, acceleration: 0.5}},
+		"zero step":      {givenStep: "0", expectedError: true},
+		"huge step":      {givenStep: "50%", expectedError: true},
+		"not a number":   {givenStep: "lots", expectedError: true},
+		"negative accel": {givenAcceleration: -1, expectedError: true},
+		"huge accel":     {givenAcceleration: 10, expectedError: true},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			settings, err := encoderSettingsFromConfigs(testCase.givenStep, testCase.givenAcceleration)
+			if testCase.expectedError {
+				assert.Error(t, err)
+				assert.Nil(t, settings)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.InDelta(t, testCase.expectedSettings.step, settings.step, 1e-9)
+			assert.Equal(t, testCase.expectedSettings.acceleration, settings.acceleration)
+		})
+	}
+}
+
+func TestEncoderSettings_change(t *testing.T) {
+	type testCase struct {
+		givenSettings  *encoderSettings
+		givenDelta     int
+		expectedChange float32
+	}
+
+	testCases := map[string]testCase{
+		"nothing turned":        {givenSettings: &encoderSettings{step: 0.02, acceleration: 1}, givenDelta: 0, expectedChange: 0},
+		"default single detent": {givenSettings: nil, givenDelta: 1, expectedChange: 0.02},
+		"default counter":       {givenSettings: nil, givenDelta: -3, expectedChange: -0.06},
+		"linear without accel":  {givenSettings: &encoderSettings{step: 0.01}, givenDelta: 5, expectedChange: 0.05},
+		"single detent accel":   {givenSettings: &encoderSettings{step: 0.01, acceleration: 1}, givenDelta: 1, expectedChange: 0.01},
+		"fast spin accelerates": {givenSettings: &encoderSettings{step: 0.01, acceleration: 1}, givenDelta: 5, expectedChange: 0.25},
+		"fast spin counter":     {givenSettings: &encoderSettings{step: 0.01, acceleration: 0.5}, givenDelta: -3, expectedChange: -0.06},
+		"partial acceleration":  {givenSettings: &encoderSettings{step: 0.02, acceleration: 0.25}, givenDelta: 2, expectedChange: 0.05},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			assert.InDelta(t, testCase.expectedChange, testCase.givenSettings.change(testCase.givenDelta), 1e-6)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package deej
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/device"
+)
+
+// eventBus carries every event flowing between parts of deej. publishing never blocks: each
+// subscription queues events for its consumer, and a full queue drops or coalesces them rather
+// than stalling serial reads or config reloads. every subscription ends with the bus context
+type eventBus struct {
+	sliderMoves   *topic[SliderMoveEvent]
+	buttons       *topic[buttonsEvent]
+	encoders      *topic[encoderEvent]
+	deviceInfo    *topic[deviceInfoEvent]
+	mixerStates   *topic[MixerState]
+	configReloads *topic[configReloadEvent]
+	sessions      *topic[sessionEvent]
+}
+
+// buttonsEvent is a snapshot of every button of a mixer
+type buttonsEvent struct {
+	Device  string
+	Buttons []bool
+}
+
+// encoderEvent holds detents turned by every encoder of a mixer since its previous line
+type encoderEvent struct {
+	Device string
+	Deltas []int
+}
+
+// deviceInfoEvent tells what a mixer told about itself once connected
+type deviceInfoEvent struct {
+	Device string
+	Info   device.DeviceInfo
+}
+
+// configReloadEvent tells that the config was reloaded, consumers read the new values from it
+type configReloadEvent struct{}
+
+// sessionEvent tells about an audio session that was added to or removed from the session map
+type sessionEvent struct {
+	Key     string
+	Removed bool
+}
+
+func newEventBus(ctx context.Context, logger *zap.SugaredLogger) *eventBus {
+	logger = logger.Named("events")
+
+	return &eventBus{
+		sliderMoves:   newTopic[SliderMoveEvent](ctx, logger, "slider moves"),
+		buttons:       newTopic[buttonsEvent](ctx, logger, "buttons"),
+		encoders:      newTopic[encoderEvent](ctx, logger, "encoders"),
+		deviceInfo:    newTopic[deviceInfoEvent](ctx, logger, "device info"),
+		mixerStates:   newTopic[MixerState](ctx, logger, "mixer states"),
+		configReloads: newTopic[configReloadEvent](ctx, logger, "config reloads"),
+		sessions:      newTopic[sessionEvent](ctx, logger, "sessions"),
+	}
+}
+
+// overflowPolicy tells what happens to an event published while a subscription's queue is full
+type overflowPolicy int
+
+const (
+	// dropNewest drops events that don't fit, keeping the queued ones in order. for events
+	// that can't be merged, like button snapshots, where a burst is better cut short than skipped
+	dropNewest overflowPolicy = iota
+
+	// dropOldest makes room for new events, for consumers only interested in the latest ones
+	dropOldest
+)
+
+// default length of subscription queues, enough to ride out a consumer busy for a moment
+const defaultQueueSize = 64
+
+// subscribeOptions tell how a subscription queues events for its consumer
+type subscribeOptions[T any] struct {
+	size     int
+	overflow overflowPolicy
+
+	// coalesce returns a key of the event, queued events with the same key are replaced by newer ones.
+	// e.g. slider moves keyed by slider keep only its latest position, wherever it's queued
+	coalesce func(T) any
+}
+
+// topic delivers events of a single type to every subscription
+type topic[T any] struct {
+	name   string
+	logger *zap.SugaredLogger
+
+	lock          sync.Mutex
+	subscriptions map[*subscription[T]]struct{}
+	closed        bool
+}
+
+func newTopic[T any](ctx context.Context, logger *zap.SugaredLogger, name string) *topic[T] {
+	t := &topic[T]{
+		name:          name,
+		logger:        logger.With("topic", name),
+		subscriptions: map[*subscription[T]]struct{}{},
+	}
+
+	context.AfterFunc(ctx, t.close)
+
+	return t
+}
+
+// subscribe returns a subscription receiving every event published from now on, until it's
+// unsubscribed or the context ends. its channel is closed then, so consumers can range over it
+func (t *topic[T]) subscribe(ctx context.Context, options subscribeOptions[T]) *subscription[T] {
+	if options.size <= 0 {
+		options.size = defaultQueueSize
+	}
+
+	events := make(chan T)
+	s := &subscription[T]{
+		events:  events,
+		topic:   t,
+		options: options,
+		send:    events,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	t.lock.Lock()
+	closed := t.closed
+	if !closed {
+		t.subscriptions[s] = struct{}{}
+	}
+	t.lock.Unlock()
+
+	if closed {
+		close(s.done)
+		close(events)
+		return s
+	}
+
+	go s.deliver()
+	context.AfterFunc(ctx, s.unsubscribe)
+
+	return s
+}
+
+// publish queues the event for every subscription, without ever waiting for consumers
+func (t *topic[T]) publish(event T) {
+	t.lock.Lock()
+	subscriptions := make([]*subscription[T], 0, len(t.subscriptions))
+	for s := range t.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	t.lock.Unlock()
+
+	for _, s := range subscriptions {
+		s.push(event)
+	}
+}
+
+// close ends every subscription, and the ones made later right away
+func (t *topic[T]) close() {
+	t.lock.Lock()
+	t.closed = true
+	subscriptions := t.subscriptions
+	t.subscriptions = map[*subscription[T]]struct{}{}
+	t.lock.Unlock()
+
+	for s := range subscriptions {
+		s.stop()
+	}
+}
+
+// subscription queues events of a topic for a single consumer, reading them from events
+type subscription[T any] struct {
+	events <-chan T
+
+	topic   *topic[T]
+	options subscribeOptions[T]
+	send    chan T
+
+	lock    sync.Mutex
+	queue   []T
+	dropped int
+
+	// wake tells the delivering goroutine there's something queued, done stops it
+	wake     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// unsubscribe stops delivering events, events still queued are dropped
+func (s *subscription[T]) unsubscribe() {
+	s.topic.lock.Lock()
+	delete(s.topic.subscriptions, s)
+	s.topic.lock.Unlock()
+
+	s.stop()
+}
+
+func (s *subscription[T]) stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *subscription[T]) push(event T) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.options.coalesce != nil {
+		key := s.options.coalesce(event)
+		for i, queued := range s.queue {
+			if s.options.coalesce(queued) == key {
+				s.queue[i] = event
+				return
+			}
+		}
+	}
+
+	if len(s.queue) >= s.options.size {
+		s.dropped++
+		if s.dropped == 1 {
+			s.topic.logger.Warn("Consumer can't keep up, dropping events")
+		}
+
+		if s.options.overflow == dropNewest {
+			return
+		}
+		s.queue = s.queue[1:]
+	}
+
+	s.queue = append(s.queue, event)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription[T]) pop() (T, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queue) == 0 {
+		var none T
+		return none, false
+	}
+
+	event := s.queue[0]
+	s.queue = s.queue[1:]
+
+	return event, true
+}
+
+// deliver hands queued events to the consumer one by one, until the subscription stops
+func (s *subscription[T]) deliver() {
+	defer close(s.send)
+
+	for {
+		event, ok := s.pop()
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case s.send <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
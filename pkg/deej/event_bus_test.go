@@ -0,0 +1,141 @@
+package deej
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// receive collects everything delivered to the subscription until nothing came for a moment
+func receive[T any](s *subscription[T]) []T {
+	received := []T{}
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				return received
+			}
+			received = append(received, event)
+		case <-time.After(50 * time.Millisecond):
+			return received
+		}
+	}
+}
+
+func TestSubscription_push(t *testing.T) {
+	type testCase struct {
+		givenOptions   subscribeOptions[SliderMoveEvent]
+		expectedValues []float32
+	}
+
+	slider := func(event SliderMoveEvent) any { return event.SliderID }
+
+	testCases := map[string]testCase{
+		"drop newest": {
+			givenOptions:   subscribeOptions[SliderMoveEvent]{size: 2, overflow: dropNewest},
+			expectedValues: []float32{0, 1},
+		},
+		"drop oldest": {
+			givenOptions:   subscribeOptions[SliderMoveEvent]{size: 2, overflow: dropOldest},
+			expectedValues: []float32{3, 4},
+		},
+		"coalesce": {
+			givenOptions:   subscribeOptions[SliderMoveEvent]{size: 2, coalesce: slider},
+			expectedValues: []float32{4, 2},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			moves := newTopic[SliderMoveEvent](context.Background(), zap.NewNop().Sugar(), "slider moves")
+
+			// nobody's delivering, so the queue fills up
+			s := &subscription[SliderMoveEvent]{
+				topic:   moves,
+				options: testCase.givenOptions,
+				wake:    make(chan struct{}, 1),
+			}
+
+			for i, sliderID := range []int{0, 1, 1, 3, 0} {
+				s.push(SliderMoveEvent{SliderID: sliderID, PercentValue: float32(i)})
+			}
+
+			values := []float32{}
+			for _, event := range s.queue {
+				values = append(values, event.PercentValue)
+			}
+
+			assert.Equal(t, testCase.expectedValues, values)
+		})
+	}
+}
+
+func TestTopic_coalesceKeepsLatest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	moves := newTopic[SliderMoveEvent](ctx, zap.NewNop().Sugar(), "slider moves")
+	s := moves.subscribe(ctx, subscribeOptions[SliderMoveEvent]{
+		coalesce: func(event SliderMoveEvent) any { return event.SliderID },
+	})
+
+	for _, value := range []float32{0.1, 0.2, 0.3, 0.4} {
+		moves.publish(SliderMoveEvent{SliderID: 1, PercentValue: value})
+	}
+
+	received := receive(s)
+	if assert.NotEmpty(t, received) {
+		assert.Equal(t, float32(0.4), received[len(received)-1].PercentValue)
+	}
+	assert.LessOrEqual(t, len(received), 2, "at most one event in flight and one queued")
+}
+
+func TestTopic_unsubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := newTopic[configReloadEvent](ctx, zap.NewNop().Sugar(), "config reloads")
+	kept := reloads.subscribe(ctx, subscribeOptions[configReloadEvent]{})
+	dropped := reloads.subscribe(ctx, subscribeOptions[configReloadEvent]{})
+
+	dropped.unsubscribe()
+	dropped.unsubscribe()
+	reloads.publish(configReloadEvent{})
+
+	_, ok := <-dropped.events
+	assert.False(t, ok, "channel of a subscription that ended is closed")
+	assert.Len(t, receive(kept), 1)
+}
+
+func TestTopic_contextEnds(t *testing.T) {
+	busCtx, stopBus := context.WithCancel(context.Background())
+	defer stopBus()
+
+	states := newTopic[MixerState](busCtx, zap.NewNop().Sugar(), "mixer states")
+
+	// a consumer going away only ends its own subscription
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	consumer := states.subscribe(consumerCtx, subscribeOptions[MixerState]{})
+	other := states.subscribe(context.Background(), subscribeOptions[MixerState]{})
+
+	stopConsumer()
+	_, ok := <-consumer.events
+	assert.False(t, ok)
+
+	states.publish(MixerState{Device: "desk"})
+	assert.Len(t, receive(other), 1)
+
+	// the bus going away ends all of them, and ones made later right away
+	stopBus()
+	_, ok = <-other.events
+	assert.False(t, ok)
+
+	_, ok = <-states.subscribe(context.Background(), subscribeOptions[MixerState]{}).events
+	assert.False(t, ok)
+
+	// publishing to nobody is fine
+	states.publish(MixerState{Device: "desk"})
+}
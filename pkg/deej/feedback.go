@@ -0,0 +1,45 @@
+package deej
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/omriharel/deej/pkg/device"
+)
+
+// how often the mixer gets told about real session volumes and mute states. they can change
+// from outside of deej (OS mixer, the app itself), so this can't be event driven
+const feedbackInterval = time.Second
+
+// runFeedback periodically pushes real volumes and mute states back to every mixer,
+// so it can drive motorized faders, show levels and keep mute LEDs honest. Stops with the context.
+func (d *Deej) runFeedback(ctx context.Context) {
+	ticker := time.NewTicker(feedbackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			for _, mixer := range d.mixers {
+				d.sendFeedback(mixer.name, device.StateCommand(d.sessions.sliderVolumes(mixer.name)))
+				d.sendFeedback(mixer.name, device.LEDCommand(d.sessions.muteStates(mixer.name)))
+			}
+		}
+	}
+}
+
+// sendFeedback writes a command to the named mixer, silently skipping it when it isn't connected
+func (d *Deej) sendFeedback(deviceName string, command device.Command) {
+	mixer := d.mixer(deviceName)
+	if mixer == nil || len(command.Fields) == 0 {
+		return
+	}
+
+	if err := mixer.connection.Send(command); err != nil && !errors.Is(err, device.ErrNotConnected) {
+		d.logger.Warnw("Failed to send feedback to device", "name", deviceName, "command", command, "error", err)
+	}
+}
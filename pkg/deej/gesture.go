@@ -0,0 +1,466 @@
+package deej
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gestures recognized on buttons, selected with keys of gesture_mapping such as "long_press(2)"
+const (
+	gesturePress       = "press"        // a short press, fired on release
+	gestureLongPress   = "long_press"   // held down for long, fired as soon as it's long enough
+	gestureDoublePress = "double_press" // pressed twice in a row, fired on the second press
+	gestureChord       = "chord"        // several buttons pressed together, e.g. "chord(0+3)"
+)
+
+// default timing, close to what desktops use for mouse clicks
+const (
+	defaultLongPress   = 500 * time.Millisecond
+	defaultDoublePress = 300 * time.Millisecond
+	defaultChordWindow = 100 * time.Millisecond
+
+	// anything longer makes buttons feel unresponsive
+	maxGestureTiming = 3 * time.Second
+)
+
+var gesturePattern = regexp.MustCompile(`^(press|long_press|double_press|chord)\(\s*(\d+(?:\s*\+\s*\d+)*)\s*\)$`)
+
+// gesture is something done with one or more buttons, chords are the only ones using several
+type gesture struct {
+	kind    string
+	buttons []int
+}
+
+func parseGesture(key string) (gesture, error) {
+	match := gesturePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(key)))
+	if match == nil {
+		return gesture{}, fmt.Errorf("expected press(n), long_press(n), double_press(n) or chord(n+m)")
+	}
+
+	g := gesture{kind: match[1]}
+	seen := map[int]bool{}
+
+	for _, buttonString := range strings.Split(match[2], "+") {
+		button, _ := strconv.Atoi(strings.TrimSpace(buttonString))
+		if seen[button] {
+			return g, fmt.Errorf("button %d appears twice", button)
+		}
+
+		seen[button] = true
+		g.buttons = append(g.buttons, button)
+	}
+	sort.Ints(g.buttons)
+
+	if g.kind == gestureChord && len(g.buttons) < 2 {
+		return g, fmt.Errorf("chords need at least two buttons")
+	}
+	if g.kind != gestureChord && len(g.buttons) > 1 {
+		return g, fmt.Errorf("%s takes a single button", g.kind)
+	}
+
+	return g, nil
+}
+
+func (g gesture) String() string {
+	buttons := make([]string, len(g.buttons))
+	for i, button := range g.buttons {
+		buttons[i] = strconv.Itoa(button)
+	}
+
+	return fmt.Sprintf("%s(%s)", g.kind, strings.Join(buttons, "+"))
+}
+
+// gestureTiming holds how long presses take to count as long, how close presses have to be
+// to count as double, and how close together chord buttons have to go down
+type gestureTiming struct {
+	longPress   time.Duration
+	doublePress time.Duration
+	chord       time.Duration
+}
+
+func defaultGestureTiming() gestureTiming {
+	return gestureTiming{
+		longPress:   defaultLongPress,
+		doublePress: defaultDoublePress,
+		chord:       defaultChordWindow,
+	}
+}
+
+func gestureTimingFromConfigs(configValues map[string]string) (gestureTiming, error) {
+	timing := defaultGestureTiming()
+
+	for key, value := range configValues {
+		duration, err := parseGestureTiming(value)
+		if err != nil {
+			return defaultGestureTiming(), fmt.Errorf("%s: %w", key, err)
+		}
+
+		switch strings.ToLower(key) {
+		case gestureLongPress:
+			timing.longPress = duration
+		case gestureDoublePress:
+			timing.doublePress = duration
+		case gestureChord:
+			timing.chord = duration
+		default:
+			return defaultGestureTiming(), fmt.Errorf("unknown gesture %q, expected one of: %s, %s, %s",
+				key, gestureLongPress, gestureDoublePress, gestureChord)
+		}
+	}
+
+	return timing, nil
+}
+
+// parseGestureTiming accepts durations ("400ms", "0.5s") and plain numbers of milliseconds
+func parseGestureTiming(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		milliseconds, numberErr := strconv.ParseFloat(value, 64)
+		if numberErr != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+
+		duration = time.Duration(milliseconds * float64(time.Millisecond))
+	}
+
+	if duration <= 0 || duration > maxGestureTiming {
+		return 0, fmt.Errorf("duration %s out of range 0 to %s", duration, maxGestureTiming)
+	}
+
+	return duration, nil
+}
+
+// buttonGestureState tracks a single button between snapshots
+type buttonGestureState struct {
+	pressed   bool
+	pressedAt time.Time
+
+	// set once the current press turned into a long press, double press or chord,
+	// so letting go of the button doesn't also count as a press
+	consumed bool
+
+	// a short press waiting to see whether a second one follows
+	pending    bool
+	releasedAt time.Time
+}
+
+// GestureMap recognizes gestures in successive button snapshots and maps them to actions
+type GestureMap struct {
+	lock    sync.Mutex
+	timing  gestureTiming
+	actions map[string]buttonAction
+
+	// gestures by kind, to tell which ones a button takes part in
+	gestures map[string][]gesture
+
+	buttons map[int]*buttonGestureState
+
+	// gestures don't have a held state, so mute actions flip their targets every time
+	toggled map[string]bool
+}
+
+// Load values from viper configuration, and make new instance of GestureMap.
+// Entries with invalid gestures or actions are skipped.
+func gestureMapFromConfigs(configValues map[string]interface{}, timing gestureTiming) *GestureMap {
+	gm := &GestureMap{
+		timing:   timing,
+		actions:  make(map[string]buttonAction, len(configValues)),
+		gestures: map[string][]gesture{},
+		buttons:  map[int]*buttonGestureState{},
+		toggled:  map[string]bool{},
+	}
+
+	for key, value := range configValues {
+		g, err := parseGesture(key)
+		if err != nil {
+			log.Printf("Gesture %q, is not valid: %s", key, err.Error())
+			continue
+		}
+
+		action, err := parseButtonAction(value)
+		if err == nil {
+			err = checkGestureAction(action)
+		}
+		if err != nil {
+			log.Printf("Gesture %s, is not a valid mapping: %s", g, err.Error())
+			continue
+		}
+
+		gm.actions[g.String()] = action
+		gm.gestures[g.kind] = append(gm.gestures[g.kind], g)
+	}
+
+	return gm
+}
+
+// checkGestureAction rejects actions that need a button of their own to hold their state
+func checkGestureAction(action buttonAction) error {
+	if action.kind == buttonActionSolo {
+		return fmt.Errorf("%s needs a button of its own, map it under mute_mapping", buttonActionSolo)
+	}
+	if action.kind == buttonActionMute && action.mode != muteModeMomentary {
+		return fmt.Errorf("gestures always toggle muting, mode %q doesn't apply", action.mode)
+	}
+
+	return nil
+}
+
+// mapped tells whether a single button gesture has an action
+func (gm *GestureMap) mapped(kind string, button int) bool {
+	_, ok := gm.actions[gesture{kind: kind, buttons: []int{button}}.String()]
+	return ok
+}
+
+// update takes raw button states read from the device at the given time, and returns
+// the events of gestures they complete
+func (gm *GestureMap) update(states []bool, now time.Time) []buttonEvent {
+	if gm == nil {
+		return nil
+	}
+
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	events := gm.expireLocked(now)
+
+	for button, pressed := range states {
+		state, seen := gm.buttons[button]
+		if !seen {
+
+			// the first snapshot tells where buttons are, not what was done with them
+			gm.buttons[button] = &buttonGestureState{pressed: pressed, pressedAt: now, consumed: pressed}
+			continue
+		}
+
+		if pressed == state.pressed {
+			continue
+		}
+		state.pressed = pressed
+
+		if pressed {
+			events = append(events, gm.pressLocked(button, state, now)...)
+		} else {
+			events = append(events, gm.releaseLocked(button, state, now)...)
+		}
+	}
+
+	return events
+}
+
+func (gm *GestureMap) pressLocked(button int, state *buttonGestureState, now time.Time) []buttonEvent {
+	state.pressedAt = now
+	state.consumed = false
+
+	// a second press soon enough after the first completes a double press
+	if state.pending {
+		state.pending = false
+
+		if now.Sub(state.releasedAt) < gm.timing.doublePress {
+			state.consumed = true
+			return []buttonEvent{gm.fireLocked(gesture{kind: gestureDoublePress, buttons: []int{button}})}
+		}
+	}
+
+	events := []buttonEvent{}
+
+	for _, chord := range gm.gestures[gestureChord] {
+		if gm.chordCompleteLocked(chord, button, now) {
+			for _, chordButton := range chord.buttons {
+				gm.buttons[chordButton].consumed = true
+				gm.buttons[chordButton].pending = false
+			}
+
+			events = append(events, gm.fireLocked(chord))
+		}
+	}
+
+	return events
+}
+
+// chordCompleteLocked tells whether the button going down completes the chord: every other
+// button of it has to be held already, pressed recently and not used for anything else
+func (gm *GestureMap) chordCompleteLocked(chord gesture, button int, now time.Time) bool {
+	partOfChord := false
+
+	for _, chordButton := range chord.buttons {
+		if chordButton == button {
+			partOfChord = true
+			continue
+		}
+
+		state, ok := gm.buttons[chordButton]
+		if !ok || !state.pressed || state.consumed || now.Sub(state.pressedAt) > gm.timing.chord {
+			return false
+		}
+	}
+
+	return partOfChord
+}
+
+func (gm *GestureMap) releaseLocked(button int, state *buttonGestureState, now time.Time) []buttonEvent {
+	if state.consumed {
+		return nil
+	}
+
+	// catch long presses released before anything checked on them
+	if gm.mapped(gestureLongPress, button) && now.Sub(state.pressedAt) >= gm.timing.longPress {
+		return []buttonEvent{gm.fireLocked(gesture{kind: gestureLongPress, buttons: []int{button}})}
+	}
+
+	// a press can only fire once it's clear no second one follows
+	if gm.mapped(gestureDoublePress, button) {
+		state.pending = true
+		state.releasedAt = now
+		return nil
+	}
+
+	return gm.pressEventsLocked(button)
+}
+
+func (gm *GestureMap) pressEventsLocked(button int) []buttonEvent {
+	if !gm.mapped(gesturePress, button) {
+		return nil
+	}
+
+	return []buttonEvent{gm.fireLocked(gesture{kind: gesturePress, buttons: []int{button}})}
+}
+
+// expire returns events of gestures completed by time passing alone: buttons held long enough
+// and presses no longer followed by a second one
+func (gm *GestureMap) expire(now time.Time) []buttonEvent {
+	if gm == nil {
+		return nil
+	}
+
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	return gm.expireLocked(now)
+}
+
+func (gm *GestureMap) expireLocked(now time.Time) []buttonEvent {
+	events := []buttonEvent{}
+
+	for _, button := range gm.sortedButtonsLocked() {
+		state := gm.buttons[button]
+
+		if state.pending && now.Sub(state.releasedAt) >= gm.timing.doublePress {
+			state.pending = false
+			events = append(events, gm.pressEventsLocked(button)...)
+		}
+
+		if state.pressed && !state.consumed && gm.mapped(gestureLongPress, button) &&
+			now.Sub(state.pressedAt) >= gm.timing.longPress {
+
+			state.consumed = true
+			events = append(events, gm.fireLocked(gesture{kind: gestureLongPress, buttons: []int{button}}))
+		}
+	}
+
+	return events
+}
+
+// deadline returns when expire should be called next, if anything is waiting on time to pass
+func (gm *GestureMap) deadline() (time.Time, bool) {
+	if gm == nil {
+		return time.Time{}, false
+	}
+
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	var next time.Time
+	for button, state := range gm.buttons {
+		candidates := []time.Time{}
+
+		if state.pending {
+			candidates = append(candidates, state.releasedAt.Add(gm.timing.doublePress))
+		}
+		if state.pressed && !state.consumed && gm.mapped(gestureLongPress, button) {
+			candidates = append(candidates, state.pressedAt.Add(gm.timing.longPress))
+		}
+
+		for _, candidate := range candidates {
+			if next.IsZero() || candidate.Before(next) {
+				next = candidate
+			}
+		}
+	}
+
+	return next, !next.IsZero()
+}
+
+func (gm *GestureMap) fireLocked(g gesture) buttonEvent {
+	key := g.String()
+	action := gm.actions[key]
+
+	if action.kind == buttonActionMute {
+		gm.toggled[key] = !gm.toggled[key]
+	}
+
+	return buttonEvent{
+		button:  g.buttons[0],
+		gesture: key,
+		action:  action,
+		active:  gm.toggled[key],
+	}
+}
+
+func (gm *GestureMap) sortedButtonsLocked() []int {
+	buttons := make([]int, 0, len(gm.buttons))
+	for button := range gm.buttons {
+		buttons = append(buttons, button)
+	}
+	sort.Ints(buttons)
+
+	return buttons
+}
+
+// carryOver keeps button state of a previous gesture map, so reloading the config
+// doesn't mistake held buttons for new presses or forget what gestures muted
+func (gm *GestureMap) carryOver(previous *GestureMap) {
+	if gm == nil || previous == nil {
+		return
+	}
+
+	previous.lock.Lock()
+	defer previous.lock.Unlock()
+
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	for button, state := range previous.buttons {
+		gm.buttons[button] = &buttonGestureState{pressed: state.pressed, pressedAt: state.pressedAt, consumed: true}
+	}
+
+	for key, toggled := range previous.toggled {
+		if action, ok := gm.actions[key]; ok && action.String() == previous.actions[key].String() {
+			gm.toggled[key] = toggled
+		}
+	}
+}
+
+func (gm *GestureMap) String() string {
+	if gm == nil {
+		return "<no gestures>"
+	}
+
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	keys := make([]string, 0, len(gm.actions))
+	for key, action := range gm.actions {
+		keys = append(keys, fmt.Sprintf("%s: %s", key, action))
+	}
+	sort.Strings(keys)
+
+	return fmt.Sprintf("<%d gestures mapped: %s>", len(keys), strings.Join(keys, ", "))
+}
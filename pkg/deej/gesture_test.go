@@ -0,0 +1,273 @@
+package deej
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGesture(t *testing.T) {
+	type testCase struct {
+		givenKey        string
+		expectedGesture string
+		expectedError   bool
+	}
+
+	testCases := map[string]testCase{
+		"press":             {givenKey: "press(0)", expectedGesture: "press(0)"},
+		"long press":        {givenKey: "Long_Press( 4 )", expectedGesture: "long_press(4)"},
+		"double press":      {givenKey: "double_press(12)", expectedGesture: "double_press(12)"},
+		"chord":             {givenKey: "chord(3+0)", expectedGesture: "chord(0+3)"},
+		"three way chord":   {givenKey: "chord(1 + 2 + 5)", expectedGesture: "chord(1+2+5)"},
+		"chord of one":      {givenKey: "chord(2)", expectedError: true},
+		"repeated button":   {givenKey: "chord(2+2)", expectedError: true},
+		"press of two":      {givenKey: "press(0+1)", expectedError: true},
+		"unknown gesture":   {givenKey: "triple_press(0)", expectedError: true},
+		"missing button":    {givenKey: "press()", expectedError: true},
+		"negative button":   {givenKey: "press(-1)", expectedError: true},
+		"missing parenthes": {givenKey: "press 0", expectedError: true},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g, err := parseGesture(testCase.givenKey)
+			if testCase.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedGesture, g.String())
+		})
+	}
+}
+
+func TestGestureTimingFromConfigs(t *testing.T) {
+	timing, err := gestureTimingFromConfigs(map[string]string{"long_press": "800ms", "chord": "50"})
+	require.NoError(t, err)
+	assert.Equal(t, gestureTiming{
+		longPress:   800 * time.Millisecond,
+		doublePress: defaultDoublePress,
+		chord:       50 * time.Millisecond,
+	}, timing)
+
+	for _, invalid := range []map[string]string{
+		{"long_press": "soon"},
+		{"double_press": "0"},
+		{"chord": "10s"},
+		{"triple_press": "200ms"},
+	} {
+		timing, err := gestureTimingFromConfigs(invalid)
+		assert.Error(t, err, "%v", invalid)
+		assert.Equal(t, defaultGestureTiming(), timing)
+	}
+}
+
+// step is a button snapshot read some time after the previous one
+type step struct {
+	after  time.Duration
+	states []bool
+}
+
+func TestGestureMap_update(t *testing.T) {
+	type testCase struct {
+		givenGestures    []string
+		givenSteps       []step
+		expectedGestures []string
+	}
+
+	mapped := func(gestures ...string) []string { return gestures }
+	ms := time.Millisecond
+
+	testCases := map[string]testCase{
+		"press fires on release": {
+			givenGestures:    mapped("press(0)"),
+			givenSteps:       []step{{0, []bool{false}}, {10 * ms, []bool{true}}, {100 * ms, []bool{false}}},
+			expectedGestures: []string{"press(0)"},
+		},
+		"held at startup isn't a press": {
+			givenGestures:    mapped("press(0)"),
+			givenSteps:       []step{{0, []bool{true}}, {100 * ms, []bool{false}}},
+			expectedGestures: []string{},
+		},
+		"long press fires while held": {
+			givenGestures: mapped("press(0)", "long_press(0)"),
+			givenSteps: []step{
+				{0, []bool{false}}, {10 * ms, []bool{true}}, {400 * ms, []bool{true}},
+				{200 * ms, []bool{true}}, {500 * ms, []bool{false}},
+			},
+			expectedGestures: []string{"long_press(0)"},
+		},
+		"long press released before anyone looked": {
+			givenGestures:    mapped("press(0)", "long_press(0)"),
+			givenSteps:       []step{{0, []bool{false}}, {10 * ms, []bool{true}}, {900 * ms, []bool{false}}},
+			expectedGestures: []string{"long_press(0)"},
+		},
+		"long hold without long press mapped is a press": {
+			givenGestures:    mapped("press(0)"),
+			givenSteps:       []step{{0, []bool{false}}, {10 * ms, []bool{true}}, {900 * ms, []bool{false}}},
+			expectedGestures: []string{"press(0)"},
+		},
+		"double press": {
+			givenGestures: mapped("press(0)", "double_press(0)"),
+			givenSteps: []step{
+				{0, []bool{false}}, {10 * ms, []bool{true}}, {80 * ms, []bool{false}},
+				{150 * ms, []bool{true}}, {80 * ms, []bool{false}},
+			},
+			expectedGestures: []string{"double_press(0)"},
+		},
+		"presses too far apart": {
+			givenGestures: mapped("press(0)", "double_press(0)"),
+			givenSteps: []step{
+				{0, []bool{false}}, {10 * ms, []bool{true}}, {80 * ms, []bool{false}},
+				{400 * ms, []bool{true}}, {80 * ms, []bool{false}}, {400 * ms, []bool{false}},
+			},
+			expectedGestures: []string{"press(0)", "press(0)"},
+		},
+		"chord": {
+			givenGestures: mapped("press(0)", "press(3)", "chord(0+3)"),
+			givenSteps: []step{
+				{0, []bool{false, false, false, false}},
+				{10 * ms, []bool{true, false, false, false}},
+				{40 * ms, []bool{true, false, false, true}},
+				{200 * ms, []bool{false, false, false, false}},
+			},
+			expectedGestures: []string{"chord(0+3)"},
+		},
+		"chord pressed in one snapshot": {
+			givenGestures: mapped("chord(0+3)"),
+			givenSteps: []step{
+				{0, []bool{false, false, false, false}},
+				{10 * ms, []bool{true, false, false, true}},
+			},
+			expectedGestures: []string{"chord(0+3)"},
+		},
+		"buttons pressed too far apart aren't a chord": {
+			givenGestures: mapped("press(0)", "press(3)", "chord(0+3)"),
+			givenSteps: []step{
+				{0, []bool{false, false, false, false}},
+				{10 * ms, []bool{true, false, false, false}},
+				{300 * ms, []bool{true, false, false, true}},
+				{100 * ms, []bool{false, false, false, false}},
+			},
+			expectedGestures: []string{"press(0)", "press(3)"},
+		},
+		"unmapped buttons do nothing": {
+			givenGestures:    mapped("press(1)"),
+			givenSteps:       []step{{0, []bool{false, false}}, {10 * ms, []bool{true, false}}, {10 * ms, []bool{false, false}}},
+			expectedGestures: []string{},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			configValues := map[string]interface{}{}
+			for _, key := range testCase.givenGestures {
+				configValues[key] = map[string]interface{}{"action": "media-play-pause"}
+			}
+			gm := gestureMapFromConfigs(configValues, defaultGestureTiming())
+
+			now := time.Unix(0, 0)
+			gestures := []string{}
+			for _, step := range testCase.givenSteps {
+				now = now.Add(step.after)
+				for _, event := range gm.update(step.states, now) {
+					gestures = append(gestures, event.gesture)
+				}
+			}
+
+			assert.Equal(t, testCase.expectedGestures, gestures)
+		})
+	}
+}
+
+func TestGestureMap_expire(t *testing.T) {
+	gm := gestureMapFromConfigs(map[string]interface{}{
+		"press(0)":        map[string]interface{}{"action": "media-play-pause"},
+		"double_press(0)": map[string]interface{}{"action": "media-next"},
+		"long_press(1)":   map[string]interface{}{"action": "media-previous"},
+	}, defaultGestureTiming())
+
+	start := time.Unix(0, 0)
+	_, waiting := gm.deadline()
+	assert.False(t, waiting)
+
+	gm.update([]bool{false, false}, start)
+	assert.Empty(t, gm.update([]bool{true, true}, start.Add(10*time.Millisecond)))
+	assert.Empty(t, gm.update([]bool{false, true}, start.Add(50*time.Millisecond)))
+
+	// no snapshot comes in while button 1 is held and button 0 waits for a second press
+	deadline, waiting := gm.deadline()
+	require.True(t, waiting)
+	assert.Equal(t, start.Add(50*time.Millisecond+defaultDoublePress), deadline, "pending press of button 0 comes first")
+
+	events := gm.expire(start.Add(time.Second))
+	require.Len(t, events, 2)
+	assert.Equal(t, "press(0)", events[0].gesture)
+	assert.Equal(t, "long_press(1)", events[1].gesture)
+	assert.Equal(t, 1, events[1].button)
+
+	_, waiting = gm.deadline()
+	assert.False(t, waiting)
+}
+
+func TestGestureMap_fromYAML(t *testing.T) {
+	givenConfig := `
+gesture_mapping:
+  press(0): {action: media-play-pause}
+  long_press(0): [mic]
+  chord(0+3): {action: run-command, command: notify-send, args: ["{{.Gesture}}"]}
+  double_press(1): {action: solo, targets: spotify.exe}
+  long_press(2): {targets: mic, mode: toggle}
+  hold(2): master
+`
+
+	userConfig := viper.New()
+	userConfig.SetConfigType("yaml")
+	require.NoError(t, userConfig.ReadConfig(strings.NewReader(givenConfig)))
+
+	gm := gestureMapFromConfigs(userConfig.GetStringMap(configKeyGestureMapping), defaultGestureTiming())
+	assert.Equal(t, "<3 gestures mapped: chord(0+3): run-command, long_press(0): mute momentary, press(0): media-play-pause>",
+		gm.String())
+
+	// mute gestures flip their targets every time they fire
+	now := time.Unix(0, 0)
+	gm.update([]bool{false}, now)
+	active := []bool{}
+	for i := 1; i <= 4; i += 2 {
+		gm.update([]bool{true}, now.Add(time.Duration(i)*time.Second))
+		for _, event := range gm.expire(now.Add(time.Duration(i+1) * time.Second)) {
+			active = append(active, event.active)
+		}
+		gm.update([]bool{false}, now.Add(time.Duration(i+1)*time.Second))
+	}
+	assert.Equal(t, []bool{true, false}, active)
+}
+
+func TestGestureMap_carryOver(t *testing.T) {
+	configValues := map[string]interface{}{"press(0)": []string{"mic"}}
+	now := time.Unix(0, 0)
+
+	previous := gestureMapFromConfigs(configValues, defaultGestureTiming())
+	previous.update([]bool{false}, now)
+	previous.update([]bool{true}, now.Add(10*time.Millisecond))
+	events := previous.update([]bool{false}, now.Add(20*time.Millisecond))
+	require.Len(t, events, 1)
+	assert.True(t, events[0].active)
+	previous.update([]bool{true}, now.Add(30*time.Millisecond))
+
+	reloaded := gestureMapFromConfigs(configValues, defaultGestureTiming())
+	reloaded.carryOver(previous)
+
+	// the held button was pressed before the reload, letting go of it doesn't finish a press
+	assert.Empty(t, reloaded.update([]bool{false}, now.Add(40*time.Millisecond)))
+
+	reloaded.update([]bool{true}, now.Add(50*time.Millisecond))
+	events = reloaded.update([]bool{false}, now.Add(60*time.Millisecond))
+	require.Len(t, events, 1)
+	assert.False(t, events[0].active, "mute state from before the reload is kept")
+}
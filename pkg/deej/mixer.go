@@ -0,0 +1,238 @@
+package deej
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/omriharel/deej/pkg/device"
+)
+
+// mixer is a single device deej talks to, with a connection and an input pipeline of its own
+type mixer struct {
+	name       string
+	serial     *SerialIO
+	connection *device.Connection
+
+	// last state the connection reported, and the reason it last failed for
+	lock       sync.Mutex
+	state      device.StateChange
+	lastReason error
+}
+
+// MixerState is a change of connection state of one of the mixers
+type MixerState struct {
+	Device string
+	device.StateChange
+}
+
+// createMixers sets up a mixer for every device in the config, the first one is the primary mixer
+func (d *Deej) createMixers() error {
+	for _, profile := range d.config.devices() {
+		serial, err := NewSerialIO(d, d.logger, profile.Name)
+		if err != nil {
+			return fmt.Errorf("create SerialIO for device %s: %w", profile, err)
+		}
+
+		connection := &device.Connection{}
+		connection.SetSerialOptions(d.config.SerialOptions())
+
+		d.mixers = append(d.mixers, &mixer{
+			name:       profile.Name,
+			serial:     serial,
+			connection: connection,
+		})
+	}
+
+	d.logger.Debugw("Created mixers", "devices", d.config.devices())
+
+	return nil
+}
+
+// mixer returns the named mixer, nil for mixers that aren't set up
+func (d *Deej) mixer(name string) *mixer {
+	for _, mixer := range d.mixers {
+		if mixer.name == name {
+			return mixer
+		}
+	}
+
+	return nil
+}
+
+// primaryMixer returns the mixer shown and set up by the UI, nil before mixers are set up
+func (d *Deej) primaryMixer() *mixer {
+	if len(d.mixers) == 0 {
+		return nil
+	}
+
+	return d.mixers[0]
+}
+
+// mixerPort tells where to connect to the named mixer right now, looking USB devices up by serial number
+func (d *Deej) mixerPort(name string) (string, error) {
+	profile := d.config.device(name)
+	if profile == nil {
+		return "", fmt.Errorf("device %q is no longer in the config", name)
+	}
+
+	if !profile.USB.IsZero() {
+		return device.FindUSBPort(profile.USB)
+	}
+
+	return profile.COMPort, nil
+}
+
+// runMixer keeps the mixer connected until the context ends, backing off while it can't be.
+// every mixer reconnects on its own, so one of them going away doesn't disturb the others
+func (d *Deej) runMixer(ctx context.Context, mixer *mixer) {
+
+	// port could have been changed by the UI or a config reload since last attempt
+	resolvePort := func() (string, error) {
+		return d.mixerPort(mixer.name)
+	}
+
+	// every line read goes through the mixer's serial i/o normalizer before reaching the session map
+	mixer.connection.KeepConnected(ctx, resolvePort, mixer.serial, device.DefaultBackoff)
+}
+
+// watchHotplug connects to mixers as soon as they're plugged in, rather than once their backoff runs out
+func (d *Deej) watchHotplug(ctx context.Context, source device.HotplugSource) {
+	events, err := source.Events(ctx)
+	if err != nil {
+		d.logger.Infow("Hotplug detection unavailable, mixers will be reconnected periodically", "error", err)
+		return
+	}
+
+	for event := range events {
+		for _, mixer := range d.mixers {
+			profile := d.config.device(mixer.name)
+			if profile == nil || !profile.pluggedIn(event) {
+				continue
+			}
+
+			d.logger.Infow("Mixer plugged in, connecting", "name", mixer.name, "port", event.Port)
+			mixer.connection.RetryNow()
+		}
+	}
+}
+
+// onMixerState notes the new connection state of the named mixer and publishes it.
+// a mixer failing over and over for the same reason, like a port held while flashing firmware,
+// is only reported the first time
+func (d *Deej) onMixerState(name string, change device.StateChange) {
+	mixer := d.mixer(name)
+	if mixer == nil {
+		return
+	}
+
+	reason := device.Reason(change.Err)
+
+	mixer.lock.Lock()
+	previous := mixer.state
+	mixer.state = change
+	repeated := reason != nil && mixer.lastReason != nil && reason.Error() == mixer.lastReason.Error()
+	switch change.State {
+	case device.StateConnected:
+		mixer.lastReason = nil
+	case device.StateDisconnected:
+		mixer.lastReason = reason
+	}
+	mixer.lock.Unlock()
+
+	logger := d.logger.With("name", name, "port", change.Port)
+	switch {
+	case change.State == device.StateConnected && previous.State == device.StateStalled:
+		logger.Info("Mixer is talking again")
+	case change.State == device.StateConnected:
+		logger.Info("Mixer connected")
+	case change.State == device.StateStalled:
+		logger.Warn("Mixer went quiet")
+	case change.State == device.StateDisconnected && !repeated && change.Err != nil &&
+		!errors.Is(change.Err, context.Canceled):
+		logger.Warnw("Mixer disconnected", "error", change.Err)
+	default:
+		logger.Debugw("Mixer connection state changed", "state", change.State, "error", change.Err)
+	}
+
+	d.bus.mixerStates.publish(MixerState{Device: name, StateChange: change})
+}
+
+// MixerStatus describes the connection of every mixer in a single line, e.g. for the tray
+func (d *Deej) MixerStatus() string {
+	statuses := []string{}
+	for _, mixer := range d.mixers {
+		mixer.lock.Lock()
+		status := mixer.state.State.String()
+		if reason := mixer.lastReason; reason != nil && mixer.state.State != device.StateConnected {
+			status = fmt.Sprintf("%s (%s)", status, reason)
+		}
+		mixer.lock.Unlock()
+
+		if mixer.name != "" {
+			status = mixer.name + " " + status
+		}
+		statuses = append(statuses, status)
+	}
+
+	return strings.Join(statuses, ", ")
+}
+
+// watchMixerConfigs keeps connection parameters of every mixer in sync with the config
+func (d *Deej) watchMixerConfigs() {
+	type connectionParameters struct {
+		comPort       string
+		usb           device.USBFilter
+		serialOptions device.SerialOptions
+	}
+
+	current := func(name string) (connectionParameters, bool) {
+		profile := d.config.device(name)
+		if profile == nil {
+			return connectionParameters{}, false
+		}
+
+		return connectionParameters{profile.COMPort, profile.USB, d.config.SerialOptions()}, true
+	}
+
+	parameters := make([]connectionParameters, len(d.mixers))
+	for i, mixer := range d.mixers {
+		parameters[i], _ = current(mixer.name)
+	}
+
+	configReloads := d.bus.configReloads.subscribe(d.ctx, subscribeOptions[configReloadEvent]{
+		size:     1,
+		overflow: dropOldest,
+	})
+
+	for range configReloads.events {
+		for _, profile := range d.config.devices() {
+			if d.mixer(profile.Name) == nil {
+				d.logger.Warnw("New device found in config, restart deej to connect to it", "device", profile)
+			}
+		}
+
+		for i, mixer := range d.mixers {
+			renewed, ok := current(mixer.name)
+			if !ok {
+				d.logger.Warnw("Device removed from config, restart deej to disconnect from it", "name", mixer.name)
+				continue
+			}
+
+			if renewed == parameters[i] {
+				continue
+			}
+
+			d.logger.Infow("Detected change in connection parameters, attempting to renew connection",
+				"device", d.config.device(mixer.name))
+			parameters[i] = renewed
+
+			mixer.connection.SetSerialOptions(renewed.serialOptions)
+			if port, err := d.mixerPort(mixer.name); err == nil {
+				mixer.connection.DevicePortSet(port)
+			}
+		}
+	}
+}
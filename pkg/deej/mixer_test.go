@@ -0,0 +1,106 @@
+package deej
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/omriharel/deej/pkg/device"
+)
+
+func TestDeej_onMixerState(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	d := &Deej{
+		logger: zap.New(core).Sugar(),
+		bus:    newEventBus(context.Background(), zap.NewNop().Sugar()),
+		mixers: []*mixer{{name: "desk"}, {name: "pedals"}},
+	}
+
+	busy := func() error { return fmt.Errorf("%w: Serial port busy", device.ErrPortBusy) }
+
+	// a port held while flashing fails over and over, with probing in between
+	for i := 0; i < 3; i++ {
+		d.onMixerState("pedals", device.StateChange{Port: "COM5", State: device.StateProbing})
+		d.onMixerState("pedals", device.StateChange{Port: "COM5", State: device.StateDisconnected, Err: busy()})
+	}
+	d.onMixerState("desk", device.StateChange{Port: "COM4", State: device.StateConnected})
+
+	assert.Equal(t, 1, logs.FilterMessage("Mixer disconnected").Len(), "repeated failures are reported once")
+	assert.Equal(t, "desk connected, pedals disconnected (port busy)", d.MixerStatus())
+
+	d.onMixerState("pedals", device.StateChange{Port: "COM5", State: device.StateConnected})
+	d.onMixerState("pedals", device.StateChange{Port: "COM5", State: device.StateDisconnected, Err: busy()})
+
+	assert.Equal(t, 2, logs.FilterMessage("Mixer disconnected").Len(), "failing again after connecting is reported")
+}
+
+// fakeHotplugSource hands over events sent by the test
+type fakeHotplugSource struct {
+	events chan device.HotplugEvent
+}
+
+func (s fakeHotplugSource) Events(ctx context.Context) (<-chan device.HotplugEvent, error) {
+	return s.events, nil
+}
+
+func TestDeej_watchHotplug(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &Deej{
+		logger: zap.NewNop().Sugar(),
+		ctx:    ctx,
+		bus:    newEventBus(ctx, zap.NewNop().Sugar()),
+		config: &CanonicalConfig{Devices: []*deviceProfile{
+			{Name: "desk", USB: device.USBFilter{VID: "2341", PID: "8036"}},
+			{Name: "pedals", COMPort: "/dev/ttyUSB0"},
+		}},
+	}
+	for _, profile := range d.config.Devices {
+		d.mixers = append(d.mixers, &mixer{
+			name:       profile.Name,
+			serial:     &SerialIO{deej: d, device: profile.Name, logger: d.logger},
+			connection: &device.Connection{},
+		})
+	}
+
+	// neither mixer is plugged in, so both keep failing and wait a while before trying again
+	states := d.bus.mixerStates.subscribe(ctx, subscribeOptions[MixerState]{})
+	for _, mixer := range d.mixers {
+		go d.runMixer(ctx, mixer)
+	}
+
+	// counted well before the second attempt of either, which comes a second after the first
+	failures := map[string]int{}
+	waitForFailures := func(expected map[string]int) {
+		timeout := time.After(300 * time.Millisecond)
+		for {
+			select {
+			case state := <-states.events:
+				if state.State == device.StateDisconnected {
+					failures[state.Device]++
+				}
+			case <-timeout:
+				require.Equal(t, expected, failures)
+				return
+			}
+		}
+	}
+	waitForFailures(map[string]int{"desk": 1, "pedals": 1})
+
+	source := fakeHotplugSource{events: make(chan device.HotplugEvent)}
+	go d.watchHotplug(ctx, source)
+
+	source.events <- device.HotplugEvent{Action: device.HotplugAdd, Port: "/dev/ttyACM0", VID: "2341", PID: "0043"}
+	source.events <- device.HotplugEvent{Action: device.HotplugRemove, Port: "/dev/ttyUSB0"}
+	source.events <- device.HotplugEvent{Action: device.HotplugAdd, Port: "/dev/ttyACM1", VID: "2341", PID: "8036"}
+
+	// the right board got tried right away, even though it still isn't there
+	waitForFailures(map[string]int{"desk": 2, "pedals": 1})
+}
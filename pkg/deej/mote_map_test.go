@@ -1,11 +1,126 @@
 package deej
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 func TestMuteMap(t *testing.T) {
-	givenConfig := map[string][]string{
-		"0": {"mic"},
-		"1": {"master"},
+	givenConfig := map[string]interface{}{
+		"0": []string{"mic"},
+		"1": []string{"master"},
 	}
 	muteMapFromConfigs(givenConfig)
 }
+
+func TestMuteMap_fromYAML(t *testing.T) {
+	givenConfig := `
+mute_mapping:
+  0: deej.mic
+  1: [master, spotify.exe]
+  2:
+    targets: discord.exe
+    mode: Push-To-Talk
+  3: {targets: [mic], mode: loud}
+  four: master
+`
+
+	userConfig := viper.New()
+	userConfig.SetConfigType("yaml")
+	require.NoError(t, userConfig.ReadConfig(strings.NewReader(givenConfig)))
+
+	mm := muteMapFromConfigs(userConfig.GetStringMap(configKeyMuteMapping))
+
+	targets, ok := mm.Get(0)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"deej.mic"}, targets)
+
+	targets, _ = mm.Get(1)
+	assert.Equal(t, []string{"master", "spotify.exe"}, targets)
+
+	targets, _ = mm.Get(2)
+	assert.Equal(t, []string{"discord.exe"}, targets)
+	assert.Equal(t, muteModePushToTalk, mm.buttons[2].mode)
+
+	_, ok = mm.Get(3)
+	assert.False(t, ok, "unknown modes are skipped")
+	assert.Len(t, mm.buttons, 3)
+}
+
+func TestMuteMap_update(t *testing.T) {
+	type testCase struct {
+		givenMode       string
+		givenStates     []bool
+		expectedChanges [][]bool
+	}
+
+	// a single button pressed twice, every line read is a step
+	presses := []bool{false, true, true, false, false, true, false}
+
+	testCases := map[string]testCase{
+		"momentary": {
+			givenMode:       muteModeMomentary,
+			givenStates:     presses,
+			expectedChanges: [][]bool{{false}, {true}, {true}, {false}, {false}, {true}, {false}},
+		},
+		"toggle": {
+			givenMode:       muteModeToggle,
+			givenStates:     presses,
+			expectedChanges: [][]bool{{}, {true}, {}, {}, {}, {false}, {}},
+		},
+		"push-to-talk": {
+			givenMode:       muteModePushToTalk,
+			givenStates:     presses,
+			expectedChanges: [][]bool{{true}, {false}, {}, {true}, {}, {false}, {true}},
+		},
+		"push-to-mute": {
+			givenMode:       muteModePushToMute,
+			givenStates:     presses,
+			expectedChanges: [][]bool{{false}, {true}, {}, {false}, {}, {true}, {false}},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			mm := muteMapFromConfigs(map[string]interface{}{
+				"0": map[string]interface{}{"targets": "mic", "mode": testCase.givenMode},
+			})
+
+			for step, pressed := range testCase.givenStates {
+				mutes := []bool{}
+				for _, event := range mm.update([]bool{pressed}) {
+					assert.Equal(t, 0, event.button)
+					mutes = append(mutes, event.active)
+				}
+
+				assert.Equal(t, testCase.expectedChanges[step], mutes, "step %d", step)
+			}
+		})
+	}
+}
+
+func TestMuteMap_carryOver(t *testing.T) {
+	toggleConfig := map[string]interface{}{
+		"0": map[string]interface{}{"targets": "mic", "mode": muteModeToggle},
+		"1": map[string]interface{}{"targets": "master", "mode": muteModeToggle},
+	}
+
+	previous := muteMapFromConfigs(toggleConfig)
+	previous.update([]bool{false, false})
+	previous.update([]bool{true, true})
+	assert.Equal(t, []bool{true, true}, previous.states(2))
+
+	// button 1 switches to another mode, its toggled state means nothing there
+	reloaded := muteMapFromConfigs(map[string]interface{}{
+		"0": toggleConfig["0"],
+		"1": []string{"master"},
+	})
+	reloaded.carryOver(previous)
+
+	assert.Equal(t, []bool{true, false, false}, reloaded.states(3))
+	assert.Empty(t, reloaded.update([]bool{true}), "held button isn't a new press")
+}
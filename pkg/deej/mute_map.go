@@ -1,33 +1,214 @@
 package deej
 
 import (
+	"fmt"
 	"log"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+)
+
+// button modes, selected with the "mode" key of a mute_mapping entry
+const (
+	muteModeMomentary  = "momentary"    // muted while the button reads 1, for latching switches (default)
+	muteModeToggle     = "toggle"       // every press flips the mute, for tactile buttons
+	muteModePushToTalk = "push-to-talk" // muted, except while the button is held
+	muteModePushToMute = "push-to-mute" // unmuted, except while the button is held
 )
 
 type MuteMap struct {
-	targets map[int][]string
+	lock    sync.Mutex
+	buttons map[int]buttonAction
+
+	// last raw state of every button, to tell presses and releases apart
+	pressed map[int]bool
+
+	// mute (or solo) state asked for by every button. toggle buttons have to remember it
+	// between presses, so it's carried over config reloads and device reconnects
+	muted map[int]bool
+}
+
+// buttonEvent asks for a button's action to be carried out. active tells mute buttons whether to
+// mute or unmute their targets, and solo buttons whether to start or stop soloing them
+type buttonEvent struct {
+	button  int
+	gesture string // the gesture that fired, empty for mute_mapping buttons
+	action  buttonAction
+	active  bool
 }
 
 func (mm *MuteMap) Get(position int) ([]string, bool) {
-	targets, exists := mm.targets[position]
-	return targets, exists
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	button, exists := mm.buttons[position]
+	return button.targets, exists
+}
+
+func (mm *MuteMap) action(position int) (buttonAction, bool) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	button, exists := mm.buttons[position]
+	return button, exists
+}
+
+func (mm *MuteMap) iterate(f func(int, []string)) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	for position, button := range mm.buttons {
+		f(position, button.targets)
+	}
+}
+
+// update takes raw button states read from the device and returns the events they cause,
+// depending on every button's action and mode
+func (mm *MuteMap) update(states []bool) []buttonEvent {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	events := []buttonEvent{}
+
+	for position, pressed := range states {
+		wasPressed, seen := mm.pressed[position]
+		mm.pressed[position] = pressed
+
+		button, ok := mm.buttons[position]
+		if !ok {
+			continue
+		}
+
+		edge := !seen || pressed != wasPressed
+		press := pressed && seen && !wasPressed
+
+		// actions other than mute only care about presses
+		if button.kind != buttonActionMute {
+			if !press {
+				continue
+			}
+
+			if button.kind == buttonActionSolo {
+				mm.muted[position] = !mm.muted[position]
+			}
+
+			events = append(events, buttonEvent{button: position, action: button, active: mm.muted[position]})
+			continue
+		}
+
+		switch button.mode {
+		case muteModeToggle:
+
+			// only presses count, the state set by the last one stays until the next
+			if !press {
+				continue
+			}
+			mm.muted[position] = !mm.muted[position]
+
+		case muteModePushToTalk:
+			if !edge {
+				continue
+			}
+			mm.muted[position] = !pressed
+
+		case muteModePushToMute:
+			if !edge {
+				continue
+			}
+			mm.muted[position] = pressed
+
+		default:
+
+			// latching switches are the truth, so keep applying them even when nothing changed
+			mm.muted[position] = pressed
+		}
+
+		events = append(events, buttonEvent{button: position, action: button, active: mm.muted[position]})
+	}
+
+	return events
+}
+
+// states returns the mute (or solo) state asked for by each of the first count buttons, to light their LEDs
+func (mm *MuteMap) states(count int) []bool {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	mutes := make([]bool, count)
+	for position := range mutes {
+		mutes[position] = mm.muted[position]
+	}
+
+	return mutes
+}
+
+// carryOver keeps button state of a previous mute map, so reloading the config doesn't
+// forget toggled buttons or mistake a held button for a new press
+func (mm *MuteMap) carryOver(previous *MuteMap) {
+	if previous == nil {
+		return
+	}
+
+	previous.lock.Lock()
+	defer previous.lock.Unlock()
+
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	for position, pressed := range previous.pressed {
+		mm.pressed[position] = pressed
+	}
+
+	for position, muted := range previous.muted {
+		if button, ok := mm.buttons[position]; ok && button.String() == previous.buttons[position].String() {
+			mm.muted[position] = muted
+		}
+	}
+}
+
+func (mm *MuteMap) String() string {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	positions := make([]int, 0, len(mm.buttons))
+	for position := range mm.buttons {
+		positions = append(positions, position)
+	}
+	sort.Ints(positions)
+
+	modes := make([]string, len(positions))
+	for i, position := range positions {
+		modes[i] = fmt.Sprintf("%d: %s", position, mm.buttons[position])
+	}
+
+	return fmt.Sprintf("<%d buttons mapped: %s>", len(mm.buttons), strings.Join(modes, ", "))
 }
 
 // Load values from viper configuration, and make new instance of muteMap.
-func muteMapFromConfigs(configValues map[string][]string) *MuteMap {
-	targets := make(map[int][]string, len(configValues))
+// Entries that don't follow the schema of their action are skipped.
+func muteMapFromConfigs(configValues map[string]interface{}) *MuteMap {
+	buttons := make(map[int]buttonAction, len(configValues))
 
-	for key, values := range configValues {
+	for key, value := range configValues {
 		intKey, err := strconv.Atoi(key)
 		if err != nil {
 			log.Printf("Key %q, is not a valid integer: %s", key, err.Error())
 			continue
 		}
-		targets[intKey] = values
+
+		button, err := parseButtonAction(value)
+		if err != nil {
+			log.Printf("Button %d, is not a valid mapping: %s", intKey, err.Error())
+			continue
+		}
+
+		buttons[intKey] = button
 	}
 
 	return &MuteMap{
-		targets: targets,
+		buttons: buttons,
+		pressed: map[int]bool{},
+		muted:   map[int]bool{},
 	}
 }
@@ -0,0 +1,273 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// noise reduction modes and filters, besides the levels and numbers known to util.NoiseReductionThreshold
+const (
+	noiseReductionAdaptive = "adaptive"
+
+	noiseFilterNone   = "none"
+	noiseFilterEMA    = "ema"    // exponential moving average, "ema:0.3" sets how much each new value weighs
+	noiseFilterMedian = "median" // median of the last few values, "median:5" sets how many
+
+	defaultEMAAlpha     = 0.3
+	defaultMedianWindow = 5
+	maxMedianWindow     = 31
+
+	// adaptive mode watches this many recent values to tell jitter from intended moves,
+	// split into chunks short enough to mostly catch the slider at rest
+	adaptiveWindow = 50
+	adaptiveChunk  = 10
+
+	// jitter is rarely perfectly regular, so leave some room above what was seen
+	adaptiveMargin = 1.5
+
+	// however noisy the pot, adaptive mode stays between the "low" level and a threshold that still feels responsive
+	adaptiveMinThreshold = 0.015
+	adaptiveMaxThreshold = 0.06
+)
+
+// noiseConfig is a single slider's entry under slider_noise_reduction, as written by the user
+type noiseConfig struct {
+	Level  string `mapstructure:"level"`
+	Filter string `mapstructure:"filter"`
+}
+
+// noiseSettings describes how a single slider's values are smoothed and when they count as a move
+type noiseSettings struct {
+	threshold float64
+	adaptive  bool
+
+	filter      string
+	emaAlpha    float64
+	medianWidth int
+}
+
+// noiseReduction holds noise settings of every slider, sliders without an override use the defaults
+type noiseReduction struct {
+	defaults noiseSettings
+	m        map[int]noiseSettings
+}
+
+func noiseReductionFromConfigs(level string, filter string, configValues map[string]noiseConfig) (*noiseReduction, error) {
+	defaults := noiseSettings{}
+	if err := defaults.parseLevel(level); err != nil {
+		return nil, err
+	}
+	if err := defaults.parseFilter(filter); err != nil {
+		return nil, err
+	}
+
+	nr := &noiseReduction{
+		defaults: defaults,
+		m:        make(map[int]noiseSettings, len(configValues)),
+	}
+
+	for sliderIdxString, value := range configValues {
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil {
+			return nil, fmt.Errorf("slider %q: not a valid slider index", sliderIdxString)
+		}
+
+		// whatever the override leaves out is inherited from the defaults
+		settings := defaults
+		if value.Level != "" {
+			err = settings.parseLevel(value.Level)
+		}
+		if err == nil && value.Filter != "" {
+			err = settings.parseFilter(value.Filter)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("slider %d: %w", sliderIdx, err)
+		}
+
+		nr.m[sliderIdx] = settings
+	}
+
+	return nr, nil
+}
+
+// parseLevel reads "adaptive", or anything util.NoiseReductionThreshold understands
+func (s *noiseSettings) parseLevel(value string) error {
+	s.adaptive = strings.EqualFold(strings.TrimSpace(value), noiseReductionAdaptive)
+
+	// adaptive mode starts from the default threshold, until it has seen enough values
+	if s.adaptive {
+		value = util.NoiseReductionDefault
+	}
+
+	threshold, err := util.NoiseReductionThreshold(value)
+	if err != nil {
+		return err
+	}
+
+	s.threshold = threshold
+
+	return nil
+}
+
+// parseFilter reads filters written as "none", "ema", "ema:0.3", "median" or "median:5"
+func (s *noiseSettings) parseFilter(value string) error {
+	kind, param, hasParam := strings.Cut(strings.ToLower(strings.TrimSpace(value)), ":")
+	if kind == "" {
+		kind = noiseFilterNone
+	}
+
+	s.filter = kind
+	s.emaAlpha = defaultEMAAlpha
+	s.medianWidth = defaultMedianWindow
+
+	switch kind {
+	case noiseFilterNone:
+		if hasParam {
+			return fmt.Errorf("filter %q takes no parameter", value)
+		}
+	case noiseFilterEMA:
+		if !hasParam {
+			return nil
+		}
+
+		alpha, err := strconv.ParseFloat(param, 64)
+		if err != nil || alpha <= 0 || alpha > 1 {
+			return fmt.Errorf("filter %q: ema weight must be above 0 and at most 1", value)
+		}
+		s.emaAlpha = alpha
+	case noiseFilterMedian:
+		if !hasParam {
+			return nil
+		}
+
+		width, err := strconv.Atoi(param)
+		if err != nil || width < 1 || width > maxMedianWindow {
+			return fmt.Errorf("filter %q: median window must be between 1 and %d", value, maxMedianWindow)
+		}
+		s.medianWidth = width
+	default:
+		return fmt.Errorf("unknown filter %q", value)
+	}
+
+	return nil
+}
+
+// forSlider returns noise settings of the given slider
+func (nr *noiseReduction) forSlider(sliderIdx int) noiseSettings {
+	if nr == nil {
+		threshold, _ := util.NoiseReductionThreshold(util.NoiseReductionDefault)
+		return noiseSettings{threshold: threshold}
+	}
+
+	if settings, ok := nr.m[sliderIdx]; ok {
+		return settings
+	}
+
+	return nr.defaults
+}
+
+func (nr *noiseReduction) String() string {
+	if nr == nil {
+		return "<default>"
+	}
+
+	return fmt.Sprintf("<%s, %d slider overrides>", nr.defaults, len(nr.m))
+}
+
+func (s noiseSettings) String() string {
+	level := strconv.FormatFloat(s.threshold, 'f', -1, 64)
+	if s.adaptive {
+		level = noiseReductionAdaptive
+	}
+
+	switch s.filter {
+	case noiseFilterEMA:
+		return fmt.Sprintf("%s, ema:%g", level, s.emaAlpha)
+	case noiseFilterMedian:
+		return fmt.Sprintf("%s, median:%d", level, s.medianWidth)
+	}
+
+	return level
+}
+
+// sliderNoise keeps what's needed to clean up values of a single slider, as they arrive
+type sliderNoise struct {
+	settings noiseSettings
+
+	// filter state
+	ema     float64
+	emaSet  bool
+	samples []float64
+
+	// adaptive state, most recent normalized values
+	recent []float32
+}
+
+func newSliderNoise(settings noiseSettings) *sliderNoise {
+	return &sliderNoise{settings: settings}
+}
+
+// smooth runs a raw volume scalar through the slider's filter, if it has one
+func (sn *sliderNoise) smooth(value float32) float32 {
+	switch sn.settings.filter {
+	case noiseFilterEMA:
+		if !sn.emaSet {
+			sn.ema, sn.emaSet = float64(value), true
+		} else {
+			sn.ema += sn.settings.emaAlpha * (float64(value) - sn.ema)
+		}
+
+		return float32(sn.ema)
+
+	case noiseFilterMedian:
+		sn.samples = append(sn.samples, float64(value))
+		if len(sn.samples) > sn.settings.medianWidth {
+			sn.samples = sn.samples[1:]
+		}
+
+		sorted := slices.Clone(sn.samples)
+		slices.Sort(sorted)
+
+		return float32(sorted[len(sorted)/2])
+	}
+
+	return value
+}
+
+// significantlyDifferent tells whether a new normalized value is a move, rather than noise.
+// it has to see every value read, so adaptive mode can learn the pot's jitter
+func (sn *sliderNoise) significantlyDifferent(old float32, new float32) bool {
+	if sn.settings.adaptive {
+		sn.recent = append(sn.recent, new)
+		if len(sn.recent) > adaptiveWindow {
+			sn.recent = sn.recent[1:]
+		}
+	}
+
+	return util.SignificantlyDifferent(old, new, sn.threshold())
+}
+
+// threshold returns the slider's current threshold. in adaptive mode, recent values are split into
+// short chunks and the jitter is the median spread among them: a pot at rest only spreads as far as
+// it jitters, while moves are short bursts that don't last long enough to drag the median up
+func (sn *sliderNoise) threshold() float64 {
+	if !sn.settings.adaptive || len(sn.recent) < adaptiveChunk {
+		return sn.settings.threshold
+	}
+
+	spreads := make([]float64, 0, len(sn.recent)/adaptiveChunk)
+	for start := 0; start+adaptiveChunk <= len(sn.recent); start += adaptiveChunk {
+		chunk := sn.recent[start : start+adaptiveChunk]
+		spreads = append(spreads, float64(slices.Max(chunk)-slices.Min(chunk)))
+	}
+
+	slices.Sort(spreads)
+	jitter := spreads[len(spreads)/2]
+
+	return math.Min(math.Max(jitter*adaptiveMargin, adaptiveMinThreshold), adaptiveMaxThreshold)
+}
@@ -0,0 +1,176 @@
+package deej
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoiseReduction_levels(t *testing.T) {
+	type testCase struct {
+		givenLevel        string
+		expectedThreshold float64
+		expectedAdaptive  bool
+	}
+
+	testCases := map[string]testCase{
+		"empty":    {givenLevel: "", expectedThreshold: 0.025},
+		"low":      {givenLevel: "low", expectedThreshold: 0.015},
+		"default":  {givenLevel: "default", expectedThreshold: 0.025},
+		"high":     {givenLevel: "HIGH", expectedThreshold: 0.035},
+		"numeric":  {givenLevel: "0.02", expectedThreshold: 0.02},
+		"adaptive": {givenLevel: "adaptive", expectedThreshold: 0.025, expectedAdaptive: true},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			nr, err := noiseReductionFromConfigs(testCase.givenLevel, "", nil)
+			require.NoError(t, err)
+
+			settings := nr.forSlider(0)
+			assert.InDelta(t, testCase.expectedThreshold, settings.threshold, 0.000001)
+			assert.Equal(t, testCase.expectedAdaptive, settings.adaptive)
+		})
+	}
+}
+
+func TestNoiseReduction_invalid(t *testing.T) {
+	type testCase struct {
+		givenLevel   string
+		givenFilter  string
+		givenSliders map[string]noiseConfig
+	}
+
+	testCases := map[string]testCase{
+		"level-gibberish":     {givenLevel: "UwU"},
+		"level-negative":      {givenLevel: "-0.1"},
+		"level-too-big":       {givenLevel: "0.7"},
+		"filter-unknown":      {givenFilter: "kalman"},
+		"filter-ema-weight":   {givenFilter: "ema:1.5"},
+		"filter-median-width": {givenFilter: "median:0"},
+		"filter-none-param":   {givenFilter: "none:3"},
+		"slider-index":        {givenSliders: map[string]noiseConfig{"first": {Level: "low"}}},
+		"slider-level":        {givenSliders: map[string]noiseConfig{"1": {Level: "loud"}}},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			_, err := noiseReductionFromConfigs(testCase.givenLevel, testCase.givenFilter, testCase.givenSliders)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNoiseReduction_sliderOverrides(t *testing.T) {
+	nr, err := noiseReductionFromConfigs("high", "ema:0.5", map[string]noiseConfig{
+		"1": {Level: "0.01"},
+		"2": {Filter: "median:3"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, noiseSettings{threshold: 0.035, filter: noiseFilterEMA, emaAlpha: 0.5, medianWidth: defaultMedianWindow}, nr.forSlider(0))
+	assert.Equal(t, noiseSettings{threshold: 0.01, filter: noiseFilterEMA, emaAlpha: 0.5, medianWidth: defaultMedianWindow}, nr.forSlider(1), "filter is inherited")
+	assert.Equal(t, noiseSettings{threshold: 0.035, filter: noiseFilterMedian, emaAlpha: defaultEMAAlpha, medianWidth: 3}, nr.forSlider(2), "level is inherited")
+
+	assert.InDelta(t, 0.025, (*noiseReduction)(nil).forSlider(0).threshold, 0.000001, "missing noise reduction uses the default level")
+}
+
+func TestNoiseReduction_fromYAML(t *testing.T) {
+	givenConfig := `
+noise_reduction: 0.03
+noise_filter: median
+slider_noise_reduction:
+  "3": {level: adaptive, filter: "ema:0.2"}
+`
+
+	userConfig := viper.New()
+	userConfig.SetConfigType("yaml")
+	require.NoError(t, userConfig.ReadConfig(strings.NewReader(givenConfig)))
+
+	noiseValues := map[string]noiseConfig{}
+	require.NoError(t, userConfig.UnmarshalKey(configKeySliderNoise, &noiseValues))
+
+	nr, err := noiseReductionFromConfigs(
+		userConfig.GetString(configKeyNoiseReductionLevel),
+		userConfig.GetString(configKeyNoiseFilter),
+		noiseValues,
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "<0.03, median:5, 1 slider overrides>", nr.String())
+	assert.Equal(t, "adaptive, ema:0.2", nr.forSlider(3).String())
+}
+
+func TestSliderNoise_smooth(t *testing.T) {
+	type testCase struct {
+		givenFilter    string
+		givenValues    []float32
+		expectedValues []float32
+	}
+
+	testCases := map[string]testCase{
+		"none": {
+			givenFilter:    "none",
+			givenValues:    []float32{0.1, 0.9, 0.1},
+			expectedValues: []float32{0.1, 0.9, 0.1},
+		},
+		"ema": {
+			givenFilter:    "ema:0.5",
+			givenValues:    []float32{0.2, 0.4, 0.4, 0.8},
+			expectedValues: []float32{0.2, 0.3, 0.35, 0.575},
+		},
+		"median": {
+			givenFilter:    "median:3",
+			givenValues:    []float32{0.5, 0.9, 0.5, 0.5, 0.1, 0.6, 0.7},
+			expectedValues: []float32{0.5, 0.9, 0.5, 0.5, 0.5, 0.5, 0.6},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			nr, err := noiseReductionFromConfigs("", testCase.givenFilter, nil)
+			require.NoError(t, err)
+
+			noise := newSliderNoise(nr.forSlider(0))
+			for i, value := range testCase.givenValues {
+				assert.InDelta(t, testCase.expectedValues[i], noise.smooth(value), 0.000001, "value %d", i)
+			}
+		})
+	}
+}
+
+func TestSliderNoise_adaptive(t *testing.T) {
+	nr, err := noiseReductionFromConfigs("adaptive", "", nil)
+	require.NoError(t, err)
+
+	noise := newSliderNoise(nr.forSlider(0))
+	assert.InDelta(t, 0.025, noise.threshold(), 0.000001, "starts from the default threshold")
+
+	// a pot jittering by 3% at rest, with a short move in between
+	for i := 0; i < adaptiveWindow; i++ {
+		value := float32(0.5)
+		if i%2 == 1 {
+			value = 0.53
+		}
+		if i >= 20 && i < 25 {
+			value = 0.5 + float32(i-19)*0.05
+		}
+
+		noise.significantlyDifferent(0.5, value)
+	}
+
+	assert.InDelta(t, 0.045, noise.threshold(), 0.000001, "learns the jitter, ignoring the move")
+	assert.False(t, noise.significantlyDifferent(0.5, 0.53), "jitter isn't a move anymore")
+	assert.True(t, noise.significantlyDifferent(0.5, 0.6))
+
+	// a quiet pot settles at the lowest threshold
+	quiet := newSliderNoise(nr.forSlider(0))
+	for i := 0; i < adaptiveWindow; i++ {
+		quiet.significantlyDifferent(0.3, 0.3+float32(i%2)*0.01)
+	}
+
+	assert.InDelta(t, adaptiveMinThreshold, quiet.threshold(), 0.000001)
+}
@@ -0,0 +1,218 @@
+package deej
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// how often a ramping session gets a new volume, fast enough for steps not to be heard
+	volumeRampInterval = 20 * time.Millisecond
+
+	// anything longer makes sliders feel broken rather than smooth
+	maxVolumeRamp = 5 * time.Second
+)
+
+// volumeRamps holds how long every slider takes to bring its sessions to a new volume,
+// sliders without an override use the default, and zero means instant changes
+type volumeRamps struct {
+	defaults time.Duration
+	m        map[int]time.Duration
+}
+
+func volumeRampsFromConfigs(defaultValue string, configValues map[string]string) (*volumeRamps, error) {
+	defaults, err := parseVolumeRamp(defaultValue)
+	if err != nil {
+		return nil, err
+	}
+
+	ramps := &volumeRamps{
+		defaults: defaults,
+		m:        make(map[int]time.Duration, len(configValues)),
+	}
+
+	for sliderIdxString, value := range configValues {
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil {
+			return nil, fmt.Errorf("slider %q: not a valid slider index", sliderIdxString)
+		}
+
+		ramp, err := parseVolumeRamp(value)
+		if err != nil {
+			return nil, fmt.Errorf("slider %d: %w", sliderIdx, err)
+		}
+
+		ramps.m[sliderIdx] = ramp
+	}
+
+	return ramps, nil
+}
+
+// parseVolumeRamp accepts durations ("150ms", "0.2s") and plain numbers of milliseconds
+func parseVolumeRamp(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	ramp, err := time.ParseDuration(value)
+	if err != nil {
+		milliseconds, numberErr := strconv.ParseFloat(value, 64)
+		if numberErr != nil {
+			return 0, fmt.Errorf("invalid volume ramp %q: %w", value, err)
+		}
+
+		ramp = time.Duration(milliseconds * float64(time.Millisecond))
+	}
+
+	if ramp < 0 || ramp > maxVolumeRamp {
+		return 0, fmt.Errorf("volume ramp %s out of range 0 to %s", ramp, maxVolumeRamp)
+	}
+
+	return ramp, nil
+}
+
+// forSlider returns the ramp duration of the given slider
+func (vr *volumeRamps) forSlider(sliderIdx int) time.Duration {
+	if vr == nil {
+		return 0
+	}
+
+	if ramp, ok := vr.m[sliderIdx]; ok {
+		return ramp
+	}
+
+	return vr.defaults
+}
+
+func (vr *volumeRamps) String() string {
+	if vr == nil {
+		return "<instant>"
+	}
+
+	return fmt.Sprintf("<%s, %d slider overrides>", vr.defaults, len(vr.m))
+}
+
+// volumeRamper moves session volumes towards their targets gradually, each session on its own
+// ticker goroutine. a newer target for the same session cancels the ramp in progress
+type volumeRamper struct {
+	logger *zap.SugaredLogger
+
+	lock  sync.Mutex
+	ramps map[Session]*volumeRamp
+}
+
+// volumeRamp is a single session's ramp, running or finished
+type volumeRamp struct {
+	stop chan struct{}
+	done chan struct{}
+
+	// set when the ramp couldn't finish, reported with the session's next volume change
+	err error
+}
+
+func newVolumeRamper(logger *zap.SugaredLogger) *volumeRamper {
+	return &volumeRamper{
+		logger: logger,
+		ramps:  make(map[Session]*volumeRamp),
+	}
+}
+
+// setVolume brings the session to the target volume over the given duration, or right away if it's zero.
+// ramps run in the background, so their errors are returned by the next call for the same session
+func (vr *volumeRamper) setVolume(session Session, target float32, duration time.Duration) error {
+	vr.lock.Lock()
+	defer vr.lock.Unlock()
+
+	var previousErr error
+	if previous, ok := vr.ramps[session]; ok {
+		previous.cancel()
+		previousErr = previous.err
+
+		delete(vr.ramps, session)
+	}
+
+	if duration <= 0 {
+		if err := session.SetVolume(target); err != nil {
+			return err
+		}
+
+		return previousErr
+	}
+
+	ramp := &volumeRamp{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	vr.ramps[session] = ramp
+
+	go ramp.run(vr.logger, session, session.GetVolume(), target, duration)
+
+	return previousErr
+}
+
+// stopAll cancels every ramp in progress, before their sessions get released
+func (vr *volumeRamper) stopAll() {
+	vr.lock.Lock()
+	defer vr.lock.Unlock()
+
+	for session, ramp := range vr.ramps {
+		ramp.cancel()
+		delete(vr.ramps, session)
+	}
+}
+
+// stop cancels the session's ramp in progress, if any, before the session goes away
+func (vr *volumeRamper) stop(session Session) {
+	vr.lock.Lock()
+	defer vr.lock.Unlock()
+
+	if ramp, ok := vr.ramps[session]; ok {
+		ramp.cancel()
+		delete(vr.ramps, session)
+	}
+}
+
+// cancel stops the ramp and waits for its goroutine, so it can't race with whatever comes next
+func (r *volumeRamp) cancel() {
+	select {
+	case <-r.done:
+	default:
+		close(r.stop)
+		<-r.done
+	}
+}
+
+func (r *volumeRamp) run(logger *zap.SugaredLogger, session Session, from float32, to float32, duration time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(volumeRampInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			progress := min(float32(now.Sub(start))/float32(duration), 1)
+
+			if err := session.SetVolume(from + (to-from)*progress); err != nil {
+				logger.Warnw("Failed to ramp session volume", "session", session, "error", err)
+				r.err = err
+
+				return
+			}
+
+			if progress >= 1 {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package deej
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestVolumeRamps_fromConfigs(t *testing.T) {
+	ramps, err := volumeRampsFromConfigs("150ms", map[string]string{
+		"1": "0",
+		"2": "300",
+		"3": "0.5s",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 150*time.Millisecond, ramps.forSlider(0))
+	assert.Equal(t, time.Duration(0), ramps.forSlider(1))
+	assert.Equal(t, 300*time.Millisecond, ramps.forSlider(2), "plain numbers are milliseconds")
+	assert.Equal(t, 500*time.Millisecond, ramps.forSlider(3))
+	assert.Equal(t, time.Duration(0), (*volumeRamps)(nil).forSlider(0), "missing ramps change volume instantly")
+
+	invalid := map[string]map[string]string{
+		"gibberish": {"0": "slowly"},
+		"negative":  {"0": "-1s"},
+		"too-long":  {"0": "1m"},
+		"index":     {"first": "1s"},
+	}
+	for testName, configValues := range invalid {
+		t.Run(testName, func(t *testing.T) {
+			_, err := volumeRampsFromConfigs("", configValues)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestVolumeRamper_instant(t *testing.T) {
+	ramper := newVolumeRamper(zap.NewNop().Sugar())
+	session := &fakeSession{}
+
+	require.NoError(t, ramper.setVolume(session, 0.7, 0))
+	assert.Equal(t, []float32{0.7}, session.volumes())
+}
+
+func TestVolumeRamper_ramp(t *testing.T) {
+	ramper := newVolumeRamper(zap.NewNop().Sugar())
+	session := &fakeSession{volume: 0.2}
+
+	require.NoError(t, ramper.setVolume(session, 0.8, 10*volumeRampInterval))
+
+	require.Eventually(t, func() bool {
+		return session.GetVolume() == 0.8
+	}, time.Second, volumeRampInterval)
+
+	volumes := session.volumes()
+	assert.Greater(t, len(volumes), 1, "ramps in steps")
+	for i := 1; i < len(volumes); i++ {
+		assert.GreaterOrEqual(t, volumes[i], volumes[i-1], "steps only go up")
+	}
+	assert.Greater(t, volumes[0], float32(0.2))
+}
+
+func TestVolumeRamper_newerTargetCancels(t *testing.T) {
+	ramper := newVolumeRamper(zap.NewNop().Sugar())
+	session := &fakeSession{volume: 0}
+
+	require.NoError(t, ramper.setVolume(session, 1, time.Minute))
+	time.Sleep(3 * volumeRampInterval)
+
+	require.NoError(t, ramper.setVolume(session, 0.1, 0))
+	time.Sleep(3 * volumeRampInterval)
+
+	assert.Equal(t, float32(0.1), session.GetVolume(), "cancelled ramp doesn't touch the session anymore")
+
+	ramper.stopAll()
+}
+
+func TestVolumeRamper_reportsFailure(t *testing.T) {
+	ramper := newVolumeRamper(zap.NewNop().Sugar())
+	failure := errors.New("stale session")
+	session := &fakeSession{failing: failure}
+
+	require.NoError(t, ramper.setVolume(session, 1, 10*volumeRampInterval))
+	time.Sleep(3 * volumeRampInterval)
+
+	session.lock.Lock()
+	session.failing = nil
+	session.lock.Unlock()
+
+	assert.ErrorIs(t, ramper.setVolume(session, 0.5, 0), failure, "next change reports the failed ramp")
+	assert.Equal(t, float32(0.5), session.GetVolume())
+}
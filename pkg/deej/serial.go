@@ -1,62 +1,59 @@
 package deej
 
 import (
-	"bufio"
-	"errors"
-	"fmt"
-	"io"
-	"log"
-	"regexp"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/jacobsa/go-serial/serial"
 	"go.uber.org/zap"
 
 	"github.com/omriharel/deej/pkg/deej/util"
+	"github.com/omriharel/deej/pkg/device"
 )
 
-// SerialIO provides a deej-aware abstraction layer to managing serial I/O
+// SerialIO is the deej-aware part of the input pipeline. Raw values parsed by device.Connection,
+// whatever transport they came from, are normalized here (calibration, filtering, inversion, noise reduction) and
+// published as events on the event bus, for every subscribed consumer:
+//
+//	transport -> device.Connection (parser) -> SerialIO (normalizer) -> event bus -> consumers
+//
+// every mixer has a pipeline of its own, so their sliders never mix
 type SerialIO struct {
 	deej   *Deej
 	logger *zap.SugaredLogger
 
-	stopChannel chan bool
-	connected   bool
-	connOptions serial.OpenOptions
-	conn        io.ReadWriteCloser
+	// name of the mixer this pipeline belongs to, empty for the only one of configs without devices
+	device string
 
+	lock                       sync.Mutex
 	lastKnownNumSliders        int
 	currentSliderPercentValues []float32
-
-	sliderMoveConsumers []chan SliderMoveEvent
-
-	muteConsumer interface {
-		Mute([]bool)
-	}
+	sliderNoise                []*sliderNoise
 }
 
 // SliderMoveEvent represents a single slider move captured by deej
 type SliderMoveEvent struct {
+	Device       string
 	SliderID     int
 	PercentValue float32
 }
 
-var expectedLinePattern = regexp.MustCompile(`^\d{1,4}(\|\d{1,4})*\r\n$`)
+var _ device.VolumeConsumer = (*SerialIO)(nil)
+var _ device.DeviceInfoConsumer = (*SerialIO)(nil)
+var _ device.EncoderConsumer = (*SerialIO)(nil)
+var _ device.StateConsumer = (*SerialIO)(nil)
 
-// NewSerialIO creates a SerialIO instance that uses the provided deej
-// instance's connection info to establish communications with the arduino chip
-func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
+// NewSerialIO creates a SerialIO instance that normalizes values
+// read from the connection of the named mixer
+func NewSerialIO(deej *Deej, logger *zap.SugaredLogger, device string) (*SerialIO, error) {
 	logger = logger.Named("serial")
+	if device != "" {
+		logger = logger.With("device", device)
+	}
 
 	sio := &SerialIO{
-		deej:                deej,
-		logger:              logger,
-		stopChannel:         make(chan bool),
-		connected:           false,
-		conn:                nil,
-		sliderMoveConsumers: []chan SliderMoveEvent{},
+		deej:   deej,
+		logger: logger,
+		device: device,
 	}
 
 	logger.Debug("Created serial i/o instance")
@@ -67,94 +64,16 @@ func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
 	return sio, nil
 }
 
-// Start attempts to connect to our arduino chip
-func (sio *SerialIO) Start() error {
-
-	// don't allow multiple concurrent connections
-	if sio.connected {
-		sio.logger.Warn("Already connected, can't start another without closing first")
-		return errors.New("serial: connection already active")
-	}
-
-	// set minimum read size according to platform (0 for windows, 1 for linux)
-	// this prevents a rare bug on windows where serial reads get congested,
-	// resulting in significant lag
-	minimumReadSize := 0
-	if util.Linux() {
-		minimumReadSize = 1
-	}
-
-	sio.connOptions = serial.OpenOptions{
-		PortName:        sio.deej.config.ConnectionInfo.COMPort,
-		BaudRate:        uint(sio.deej.config.ConnectionInfo.BaudRate),
-		DataBits:        8,
-		StopBits:        1,
-		MinimumReadSize: uint(minimumReadSize),
-	}
-
-	sio.logger.Debugw("Attempting serial connection",
-		"comPort", sio.connOptions.PortName,
-		"baudRate", sio.connOptions.BaudRate,
-		"minReadSize", minimumReadSize)
-
-	var err error
-	sio.conn, err = serial.Open(sio.connOptions)
-	if err != nil {
-
-		// might need a user notification here, TBD
-		sio.logger.Warnw("Failed to open serial connection", "error", err)
-		return fmt.Errorf("open serial connection: %w", err)
-	}
-
-	namedLogger := sio.logger.Named(strings.ToLower(sio.connOptions.PortName))
-
-	namedLogger.Infow("Connected", "conn", sio.conn)
-	sio.connected = true
-
-	// read lines or await a stop
-	go func() {
-		connReader := bufio.NewReader(sio.conn)
-		lineChannel := sio.readLine(namedLogger, connReader)
-
-		for {
-			select {
-			case <-sio.stopChannel:
-				sio.close(namedLogger)
-			case line := <-lineChannel:
-				sio.handleLine(namedLogger, line)
-			}
-		}
-	}()
-
-	return nil
-}
-
-// Stop signals us to shut down our serial connection, if one is active
-func (sio *SerialIO) Stop() {
-	if sio.connected {
-		sio.logger.Debug("Shutting down serial connection")
-		sio.stopChannel <- true
-	} else {
-		sio.logger.Debug("Not currently connected, nothing to stop")
-	}
-}
-
-// SubscribeToSliderMoveEvents returns an unbuffered channel that receives
-// a sliderMoveEvent struct every time a slider moves
-func (sio *SerialIO) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
-	ch := make(chan SliderMoveEvent)
-	sio.sliderMoveConsumers = append(sio.sliderMoveConsumers, ch)
-
-	return ch
-}
-
 func (sio *SerialIO) setupOnConfigReload() {
-	configReloadedChannel := sio.deej.config.SubscribeToChanges()
+	configReloads := sio.deej.bus.configReloads.subscribe(sio.deej.ctx, subscribeOptions[configReloadEvent]{
+		size:     1,
+		overflow: dropOldest,
+	})
 
 	const stopDelay = 50 * time.Millisecond
 
 	go func() {
-		for range configReloadedChannel {
+		for range configReloads.events {
 
 			// make any config reload unset our slider number to ensure process volumes are being re-set
 			// (the next read line will emit SliderMoveEvent instances for all sliders)\
@@ -163,142 +82,73 @@ func (sio *SerialIO) setupOnConfigReload() {
 			// is still cleared. this is kind of ugly, but shouldn't cause any issues
 			go func() {
 				<-time.After(stopDelay)
-				sio.lastKnownNumSliders = 0
+				sio.resetSliders(0)
 			}()
-
-			// if connection params have changed, attempt to stop and start the connection
-			if sio.deej.config.ConnectionInfo.COMPort != sio.connOptions.PortName ||
-				uint(sio.deej.config.ConnectionInfo.BaudRate) != sio.connOptions.BaudRate {
-
-				sio.logger.Info("Detected change in connection parameters, attempting to renew connection")
-				sio.Stop()
-
-				// let the connection close
-				<-time.After(stopDelay)
-
-				if err := sio.Start(); err != nil {
-					sio.logger.Warnw("Failed to renew connection after parameter change", "error", err)
-				} else {
-					sio.logger.Debug("Renewed connection successfully")
-				}
-			}
 		}
 	}()
 }
 
-func (sio *SerialIO) close(logger *zap.SugaredLogger) {
-	if err := sio.conn.Close(); err != nil {
-		logger.Warnw("Failed to close serial connection", "error", err)
-	} else {
-		logger.Debug("Serial connection closed")
-	}
+// OnDeviceInfo prepares slider state for the device that just connected
+func (sio *SerialIO) OnDeviceInfo(info device.DeviceInfo) {
+	sio.logger.Infow("Device connected", "device", info)
+	sio.resetSliders(info.Sliders)
 
-	sio.conn = nil
-	sio.connected = false
+	sio.deej.bus.deviceInfo.publish(deviceInfoEvent{Device: sio.device, Info: info})
 }
 
-func (sio *SerialIO) readLine(logger *zap.SugaredLogger, reader *bufio.Reader) chan string {
-	ch := make(chan string)
-
-	go func() {
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-
-				if sio.deej.Verbose() {
-					logger.Warnw("Failed to read line from serial", "error", err, "line", line)
-				}
-
-				// just ignore the line, the read loop will stop after this
-				return
-			}
-
-			if sio.deej.Verbose() {
-				logger.Debugw("Read new line", "line", line)
-			}
-
-			// deliver the line to the channel
-			ch <- line
-		}
-	}()
+// OnState follows the connection of the mixer. once it comes back, every slider is sent anew,
+// sessions might have changed volume while it was gone
+func (sio *SerialIO) OnState(change device.StateChange) {
+	if change.State == device.StateDisconnected {
+		sio.resetSliders(0)
+	}
 
-	return ch
+	sio.deej.onMixerState(sio.device, change)
 }
 
-// Handle the mute line - split and convert to bool slice, propagate to consumer.
-func (sio *SerialIO) handleMute(line string) {
-	splitLine := strings.Split(line, "|")
-	output := make([]bool, len(splitLine))
-
-	for i, v := range splitLine {
-		if v == "0" {
-			output[i] = false
-			continue
-		}
-		if v == "1" {
-			output[i] = true
-			continue
-		}
-
-		log.Printf("value %q at position %d is not a bool", v, i)
-	}
-
-	if sio.muteConsumer != nil {
-		sio.muteConsumer.Mute(output)
-	}
+// OnMute publishes button states
+func (sio *SerialIO) OnMute(mutes []bool) {
+	sio.deej.bus.buttons.publish(buttonsEvent{Device: sio.device, Buttons: mutes})
 }
 
-func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string) {
+// OnEncoder publishes detents turned by encoders, being relative they need no normalizing
+func (sio *SerialIO) OnEncoder(deltas []int) {
+	sio.deej.bus.encoders.publish(encoderEvent{Device: sio.device, Deltas: deltas})
+}
 
-	// this function receives an unsanitized line which is guaranteed to end with LF,
-	// but most lines will end with CRLF. it may also have garbage instead of
-	// deej-formatted values, so we must check for that! just ignore bad ones
-	if !expectedLinePattern.MatchString(line) {
-		if !strings.HasPrefix(line, "but|") && !strings.HasPrefix(line, "mute|") {
-			return
-		}
-		if !strings.HasSuffix(line, "\r\n") {
-			return
-		}
-		sio.handleMute(line[4 : len(line)-2])
+// OnVolume normalizes raw slider values and emits move events for the ones that changed enough
+func (sio *SerialIO) OnVolume(values []int) {
+	numSliders := len(values)
+	if numSliders == 0 {
 		return
 	}
 
-	// trim the suffix
-	line = strings.TrimSuffix(line, "\r\n")
+	// turns out the first line could come out dirty sometimes (i.e. "4558|925|41|643|220")
+	// so let's check the first number for correctness just in case
+	if values[0] > device.MaxValue {
+		sio.logger.Debugw("Got malformed values from device, ignoring", "values", values)
+		return
+	}
 
-	// split on pipe (|), this gives a slice of numerical strings between "0" and "1023"
-	splitLine := strings.Split(line, "|")
-	numSliders := len(splitLine)
+	sio.lock.Lock()
 
 	// update our slider count, if needed - this will send slider move events for all
 	if numSliders != sio.lastKnownNumSliders {
-		logger.Infow("Detected sliders", "amount", numSliders)
-		sio.lastKnownNumSliders = numSliders
-		sio.currentSliderPercentValues = make([]float32, numSliders)
-
-		// reset everything to be an impossible value to force the slider move event later
-		for idx := range sio.currentSliderPercentValues {
-			sio.currentSliderPercentValues[idx] = -1.0
-		}
+		sio.logger.Infow("Detected sliders", "amount", numSliders)
+		sio.resetSlidersLocked(numSliders)
 	}
 
 	// for each slider:
+	calibration := sio.deej.config.sliderCalibration(sio.device)
 	moveEvents := []SliderMoveEvent{}
-	for sliderIdx, stringValue := range splitLine {
+	for sliderIdx, number := range values {
 
-		// convert string values to integers ("1023" -> 1023)
-		number, _ := strconv.Atoi(stringValue)
-
-		// turns out the first line could come out dirty sometimes (i.e. "4558|925|41|643|220")
-		// so let's check the first number for correctness just in case
-		if sliderIdx == 0 && number > 1023 {
-			sio.logger.Debugw("Got malformed line from serial, ignoring", "line", line)
-			return
-		}
+		// map the value from raw to a "dirty" float between 0 and 1 (e.g. 0.15451...),
+		// following the slider's calibration
+		dirtyFloat := calibration.apply(sliderIdx, number)
 
-		// map the value from raw to a "dirty" float between 0 and 1 (e.g. 0.15451...)
-		dirtyFloat := float32(number) / 1023.0
+		// run it through the slider's filter, if it has one, to even out jitter of the pot
+		dirtyFloat = sio.sliderNoise[sliderIdx].smooth(dirtyFloat)
 
 		// normalize it to an actual volume scalar between 0.0 and 1.0 with 2 points of precision
 		normalizedScalar := util.NormalizeScalar(dirtyFloat)
@@ -309,28 +159,48 @@ func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string) {
 		}
 
 		// check if it changes the desired state (could just be a jumpy raw slider value)
-		if util.SignificantlyDifferent(sio.currentSliderPercentValues[sliderIdx], normalizedScalar, sio.deej.config.NoiseReductionLevel) {
+		if sio.sliderNoise[sliderIdx].significantlyDifferent(sio.currentSliderPercentValues[sliderIdx], normalizedScalar) {
 
 			// if it does, update the saved value and create a move event
 			sio.currentSliderPercentValues[sliderIdx] = normalizedScalar
 
 			moveEvents = append(moveEvents, SliderMoveEvent{
+				Device:       sio.device,
 				SliderID:     sliderIdx,
 				PercentValue: normalizedScalar,
 			})
 
 			if sio.deej.Verbose() {
-				logger.Debugw("Slider moved", "event", moveEvents[len(moveEvents)-1])
+				sio.logger.Debugw("Slider moved", "event", moveEvents[len(moveEvents)-1])
 			}
 		}
 	}
 
+	sio.lock.Unlock()
+
 	// deliver move events if there are any, towards all potential consumers
-	if len(moveEvents) > 0 {
-		for _, consumer := range sio.sliderMoveConsumers {
-			for _, moveEvent := range moveEvents {
-				consumer <- moveEvent
-			}
-		}
+	for _, moveEvent := range moveEvents {
+		sio.deej.bus.sliderMoves.publish(moveEvent)
+	}
+}
+
+func (sio *SerialIO) resetSliders(numSliders int) {
+	sio.lock.Lock()
+	defer sio.lock.Unlock()
+
+	sio.resetSlidersLocked(numSliders)
+}
+
+func (sio *SerialIO) resetSlidersLocked(numSliders int) {
+	sio.lastKnownNumSliders = numSliders
+	sio.currentSliderPercentValues = make([]float32, numSliders)
+	sio.sliderNoise = make([]*sliderNoise, numSliders)
+
+	// reset everything to be an impossible value to force the slider move event later,
+	// and start filters from scratch with the current config
+	noise := sio.deej.config.sliderNoise(sio.device)
+	for idx := range sio.currentSliderPercentValues {
+		sio.currentSliderPercentValues[idx] = -1.0
+		sio.sliderNoise[idx] = newSliderNoise(noise.forSlider(idx))
 	}
 }
@@ -1,14 +1,19 @@
 package deej
 
 import (
-	"sync"
+	"context"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/device"
 )
 
-func TestSerialIO_handleLine(t *testing.T) {
+func TestSerialIO_pipeline(t *testing.T) {
 	type testCase struct {
 		expectedValues []float32
 		expectMutes    []bool
@@ -33,7 +38,7 @@ func TestSerialIO_handleLine(t *testing.T) {
 			isInvering:     false,
 		},
 		"invalid-other-value": {
-			expectedValues: []float32{0.12, 0.44, 9.77},
+			expectedValues: []float32{0.12, 0.44, 1.0},
 			givenLine:      "123|456|9999\r\n",
 			isInvering:     false,
 		},
@@ -70,43 +75,105 @@ func TestSerialIO_handleLine(t *testing.T) {
 	for testName, testCase := range testCases {
 		t.Run(testName, func(t *testing.T) {
 
-			fak := &fakeMuteConsumer{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			bus := newEventBus(ctx, zap.NewNop().Sugar())
+			sliderMoves := bus.sliderMoves.subscribe(ctx, subscribeOptions[SliderMoveEvent]{})
+			buttons := bus.buttons.subscribe(ctx, subscribeOptions[buttonsEvent]{})
+
 			sio := SerialIO{
 				logger: zap.S(),
 				deej: &Deej{
 					config: &CanonicalConfig{
 						InvertSliders: testCase.isInvering,
 					},
+					bus: bus,
 				},
-				sliderMoveConsumers: []chan SliderMoveEvent{
-					make(chan SliderMoveEvent, len(testCase.expectedValues)),
-				},
-				muteConsumer: fak,
 			}
-			sio.handleLine(zap.S(), testCase.givenLine)
+
+			// drive the whole pipeline, starting at the transport
+			var connection device.Connection
+			transport := device.NewPipeTransport(strings.NewReader(testCase.givenLine), io.Discard)
+			err := connection.DispatchTransport(context.Background(), "test", transport, &sio)
+			assert.ErrorIs(t, err, io.EOF)
 
 			for i, expectedValue := range testCase.expectedValues {
-				sliderEvent := <-sio.sliderMoveConsumers[0]
+				sliderEvent := <-sliderMoves.events
 
 				assert.Equal(t, i, sliderEvent.SliderID)
 				assert.Equal(t, expectedValue, sliderEvent.PercentValue)
 			}
 
+			// everything was published by the time dispatching ended, it only has to be delivered
+			var mutes []bool
+			select {
+			case event := <-buttons.events:
+				mutes = event.Buttons
+			case <-time.After(50 * time.Millisecond):
+			}
+
 			if testCase.expectMutes == nil {
-				assert.Nil(t, fak.data, "we expect ")
+				assert.Nil(t, mutes, "no button line, no mutes")
 			}
 		})
 	}
 }
 
-type fakeMuteConsumer struct {
-	sync.Mutex
-	data []bool
-}
+func TestSerialIO_OnVolumeSmoothing(t *testing.T) {
+	type testCase struct {
+		givenFilter    string
+		givenValues    []int
+		expectedValues []float32
+	}
 
-func (fak *fakeMuteConsumer) Mute(data []bool) {
-	fak.Lock()
-	defer fak.Unlock()
+	testCases := map[string]testCase{
+		"no filter lets spikes through": {
+			givenFilter:    "none",
+			givenValues:    []int{512, 512, 1023, 512, 512, 0, 512},
+			expectedValues: []float32{0.5, 1.0, 0.5, 0.0, 0.5},
+		},
+		"median drops spikes": {
+			givenFilter:    "median:3",
+			givenValues:    []int{512, 512, 1023, 512, 512, 0, 512},
+			expectedValues: []float32{0.5},
+		},
+		"ema eases into jumps": {
+			givenFilter:    "ema:0.5",
+			givenValues:    []int{0, 1023, 1023, 1023},
+			expectedValues: []float32{0, 0.5, 0.75, 0.87},
+		},
+	}
 
-	fak.data = data
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			noiseReduction, err := noiseReductionFromConfigs("", testCase.givenFilter, nil)
+			assert.NoError(t, err)
+
+			bus := newEventBus(ctx, zap.NewNop().Sugar())
+			sliderMoves := bus.sliderMoves.subscribe(ctx, subscribeOptions[SliderMoveEvent]{})
+
+			sio := SerialIO{
+				logger: zap.S(),
+				deej: &Deej{
+					config: &CanonicalConfig{NoiseReduction: noiseReduction},
+					bus:    bus,
+				},
+			}
+
+			for _, value := range testCase.givenValues {
+				sio.OnVolume([]int{value})
+			}
+
+			values := []float32{}
+			for _, event := range receive(sliderMoves) {
+				values = append(values, event.PercentValue)
+			}
+
+			assert.Equal(t, testCase.expectedValues, values)
+		})
+	}
 }
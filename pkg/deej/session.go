@@ -11,14 +11,36 @@ type Session interface {
 	GetVolume() float32
 	SetVolume(v float32) error
 
-	// TODO: future mute support
-	// GetMute() bool
+	GetMute() bool
 	SetMute(m bool) error
 
 	Key() string
 	Release()
 }
 
+// properties targets can match sessions by rather than by process name, e.g. "name:firefox" or "role:music".
+// only Linux sessions have them
+const (
+	sessionPropertyName    = "name"    // what the app calls itself, i.e. "Firefox"
+	sessionPropertyID      = "id"      // the app's reverse domain ID, i.e. "org.mozilla.firefox"
+	sessionPropertyRole    = "role"    // what the stream is for, i.e. "music", "video" or "phone"
+	sessionPropertyExe     = "exe"     // the process executable, by its full path or file name
+	sessionPropertyFlatpak = "flatpak" // the Flatpak app ID, i.e. "com.discordapp.Discord"
+)
+
+var sessionProperties = []string{
+	sessionPropertyName,
+	sessionPropertyID,
+	sessionPropertyRole,
+	sessionPropertyExe,
+	sessionPropertyFlatpak,
+}
+
+// propertySession is implemented by sessions that can be matched by more than their key
+type propertySession interface {
+	matchesProperty(property string, value string) bool
+}
+
 const (
 
 	// ideally these would share a common ground in baseSession
@@ -41,6 +63,11 @@ type baseSession struct {
 	humanReadableDesc string
 }
 
+// device tells whether the session belongs to a whole device rather than an app
+func (s *baseSession) device() bool {
+	return s.system || s.master
+}
+
 func (s *baseSession) Key() string {
 	if s.system {
 		return systemSessionName
@@ -1,8 +1,33 @@
 package deej
 
+import "context"
+
 // SessionFinder represents an entity that can find all current audio sessions
 type SessionFinder interface {
 	GetAllSessions() ([]Session, error)
 
 	Release() error
 }
+
+// outputDeviceCycler is implemented by session finders that can switch the default output device
+type outputDeviceCycler interface {
+
+	// CycleOutputDevice makes the next output device the default one and returns its name.
+	// only the given devices are cycled through, or all of them when there are none
+	CycleOutputDevice(devices []string) (string, error)
+}
+
+// sessionWatcher is implemented by session finders that tell when sessions come and go,
+// so the session map can follow them instead of looking for them all over again
+type sessionWatcher interface {
+
+	// WatchSessions reports sessions added or removed from now on, until the context ends.
+	// sessions are reported by the same instances GetAllSessions returns
+	WatchSessions(ctx context.Context) (<-chan sessionChange, error)
+}
+
+// sessionChange is a single session that was added or removed
+type sessionChange struct {
+	Session Session
+	Removed bool
+}
@@ -1,8 +1,11 @@
 package deej
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 
 	"github.com/jfreymuth/pulse/proto"
 	"go.uber.org/zap"
@@ -14,8 +17,68 @@ type paSessionFinder struct {
 
 	client *proto.Client
 	conn   net.Conn
+
+	// sessions handed out so far, for events to add and remove the very same instances
+	lock         sync.Mutex
+	streams      map[paStream]*paSession
+	masterSink   *masterSession
+	masterSource *masterSession
+	devices      map[paDeviceKey]*masterSession
+
+	// events sent by the server, queued by the client's reading goroutine which can't wait for them to be handled
+	eventLock sync.Mutex
+	events    []*proto.SubscribeEvent
+	eventWake chan struct{}
+}
+
+// paStream is an app's sink input, or its source output when it's recording
+type paStream struct {
+	index     uint32
+	recording bool
+}
+
+// paDevice is a sink, or a source when it isn't an output
+type paDevice struct {
+	index    uint32
+	isOutput bool
+}
+
+// paDeviceKey is one of the keys a sink or source is bound by, each one gets a session of its own
+type paDeviceKey struct {
+	paDevice
+	key string
 }
 
+// paDeviceInfo is what a device session is made of
+type paDeviceInfo struct {
+	paDeviceKey
+	channels byte
+}
+
+// sources bound by a key a sink already took get it with this suffix, i.e. "built-in audio analog stereo input"
+const paSourceKeySuffix = " input"
+
+// PulseAudio subscription masks and event bits, as defined by pulse/def.h
+const (
+	paSubscriptionMaskSink         = 0x0001
+	paSubscriptionMaskSource       = 0x0002
+	paSubscriptionMaskSinkInput    = 0x0004
+	paSubscriptionMaskSourceOutput = 0x0008
+	paSubscriptionMaskServer       = 0x0080
+
+	paEventFacilityMask = 0x000f
+	paEventSink         = 0x0000
+	paEventSource       = 0x0001
+	paEventSinkInput    = 0x0002
+	paEventSourceOutput = 0x0003
+	paEventServer       = 0x0007
+
+	paEventTypeMask = 0x0030
+	paEventNew      = 0x0000
+	paEventChange   = 0x0010
+	paEventRemove   = 0x0020
+)
+
 func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
 	client, conn, err := proto.Connect("")
 	if err != nil {
@@ -23,6 +86,19 @@ func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
 		return nil, fmt.Errorf("establish PulseAudio connection: %w", err)
 	}
 
+	sf := &paSessionFinder{
+		logger:        logger.Named("session_finder"),
+		sessionLogger: logger.Named("sessions"),
+		client:        client,
+		conn:          conn,
+		streams:       make(map[paStream]*paSession),
+		devices:       make(map[paDeviceKey]*masterSession),
+		eventWake:     make(chan struct{}, 1),
+	}
+
+	// set before any request, the server only starts sending events once subscribed to
+	client.Callback = sf.onMessage
+
 	request := proto.SetClientName{
 		Props: proto.PropList{
 			"application.name": proto.PropListString("deej"),
@@ -34,13 +110,6 @@ func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
 		return nil, err
 	}
 
-	sf := &paSessionFinder{
-		logger:        logger.Named("session_finder"),
-		sessionLogger: logger.Named("sessions"),
-		client:        client,
-		conn:          conn,
-	}
-
 	sf.logger.Debug("Created PA session finder instance")
 
 	return sf, nil
@@ -65,7 +134,13 @@ func (sf *paSessionFinder) GetAllSessions() ([]Session, error) {
 		sf.logger.Warnw("Failed to get master audio source session", "error", err)
 	}
 
-	// enumerate sink inputs and add sessions along the way
+	// every sink and source can be bound by its name too, not just the default ones
+	if err := sf.enumerateAndAddDeviceSessions(&sessions); err != nil {
+		sf.logger.Warnw("Failed to enumerate device sessions", "error", err)
+		return nil, fmt.Errorf("enumerate device sessions: %w", err)
+	}
+
+	// enumerate sink inputs and source outputs, and add sessions along the way
 	if err := sf.enumerateAndAddSessions(&sessions); err != nil {
 		sf.logger.Warnw("Failed to enumerate audio sessions", "error", err)
 		return nil, fmt.Errorf("enumerate audio sessions: %w", err)
@@ -96,10 +171,15 @@ func (sf *paSessionFinder) getMasterSinkSession() (Session, error) {
 		return nil, fmt.Errorf("get master sink info: %w", err)
 	}
 
-	// create the master sink session
-	sink := newMasterSession(sf.sessionLogger, sf.client, reply.SinkIndex, reply.Channels, true)
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
 
-	return sink, nil
+	// create the master sink session, unless it's still the same sink
+	if sf.masterSink == nil || sf.masterSink.streamIndex != reply.SinkIndex {
+		sf.masterSink = newMasterSession(sf.sessionLogger, sf.client, reply.SinkIndex, reply.Channels, true, masterSessionName)
+	}
+
+	return sf.masterSink, nil
 }
 
 func (sf *paSessionFinder) getMasterSourceSession() (Session, error) {
@@ -113,38 +193,470 @@ func (sf *paSessionFinder) getMasterSourceSession() (Session, error) {
 		return nil, fmt.Errorf("get master source info: %w", err)
 	}
 
-	// create the master source session
-	source := newMasterSession(sf.sessionLogger, sf.client, reply.SourceIndex, reply.Channels, false)
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	// create the master source session, unless it's still the same source
+	if sf.masterSource == nil || sf.masterSource.streamIndex != reply.SourceIndex {
+		sf.masterSource = newMasterSession(sf.sessionLogger, sf.client, reply.SourceIndex, reply.Channels, false, inputSessionName)
+	}
 
-	return source, nil
+	return sf.masterSource, nil
 }
 
-func (sf *paSessionFinder) enumerateAndAddSessions(sessions *[]Session) error {
-	request := proto.GetSinkInputInfoList{}
-	reply := proto.GetSinkInputInfoListReply{}
+func (sf *paSessionFinder) enumerateAndAddDeviceSessions(sessions *[]Session) error {
+	devices, err := sf.listDevices()
+	if err != nil {
+		return err
+	}
 
-	if err := sf.client.Request(&request, &reply); err != nil {
+	current, _ := sf.setDevices(devices)
+	*sessions = append(*sessions, current...)
+
+	return nil
+}
+
+// listDevices asks for every sink and source, and the keys to bind them by
+func (sf *paSessionFinder) listDevices() ([]paDeviceInfo, error) {
+	sinks := proto.GetSinkInfoListReply{}
+	if err := sf.client.Request(&proto.GetSinkInfoList{}, &sinks); err != nil {
+		sf.logger.Warnw("Failed to get sink list", "error", err)
+		return nil, fmt.Errorf("get sink list: %w", err)
+	}
+
+	sources := proto.GetSourceInfoListReply{}
+	if err := sf.client.Request(&proto.GetSourceInfoList{}, &sources); err != nil {
+		sf.logger.Warnw("Failed to get source list", "error", err)
+		return nil, fmt.Errorf("get source list: %w", err)
+	}
+
+	return deviceInfos(sinks, sources), nil
+}
+
+// deviceInfos lists the keys of every sink and source: its unique name and its description. monitor sources
+// are left out, and keys a sink took already are suffixed for sources, as a card's sink and source often
+// share a description
+func deviceInfos(sinks proto.GetSinkInfoListReply, sources proto.GetSourceInfoListReply) []paDeviceInfo {
+	devices := []paDeviceInfo{}
+	sinkKeys := map[string]bool{}
+
+	for _, sink := range sinks {
+		device := paDevice{index: sink.SinkIndex, isOutput: true}
+
+		for _, key := range deviceKeys(sink.SinkName, sinkDescription(sink)) {
+			sinkKeys[strings.ToLower(key)] = true
+			devices = append(devices, paDeviceInfo{paDeviceKey{device, key}, sink.Channels})
+		}
+	}
+
+	for _, source := range sources {
+		if monitorSource(source) {
+			continue
+		}
+
+		device := paDevice{index: source.SourceIndex}
+
+		for _, key := range deviceKeys(source.SourceName, sourceDescription(source)) {
+			if sinkKeys[strings.ToLower(key)] {
+				key += paSourceKeySuffix
+			}
+
+			devices = append(devices, paDeviceInfo{paDeviceKey{device, key}, source.Channels})
+		}
+	}
+
+	return devices
+}
+
+// deviceKeys returns the keys of a device, once when its description is its name
+func deviceKeys(name string, description string) []string {
+	if strings.EqualFold(name, description) {
+		return []string{name}
+	}
+
+	return []string{name, description}
+}
+
+// setDevices makes the given devices the current ones. it returns their sessions, the ones handed out before
+// when there are any, along with the changes since the previous ones
+func (sf *paSessionFinder) setDevices(devices []paDeviceInfo) ([]Session, []sessionChange) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	sessions := make([]Session, 0, len(devices))
+	changes := []sessionChange{}
+	current := make(map[paDeviceKey]*masterSession, len(devices))
+
+	for _, device := range devices {
+		session, ok := sf.devices[device.paDeviceKey]
+		if !ok {
+			session = newMasterSession(sf.sessionLogger, sf.client, device.index, device.channels, device.isOutput, device.key)
+			changes = append(changes, sessionChange{Session: session})
+		}
+
+		current[device.paDeviceKey] = session
+		sessions = append(sessions, session)
+	}
+
+	// devices gone by now are forgotten
+	for device, session := range sf.devices {
+		if _, ok := current[device]; !ok {
+			changes = append(changes, sessionChange{Session: session, Removed: true})
+		}
+	}
+
+	sf.devices = current
+
+	return sessions, changes
+}
+
+func (sf *paSessionFinder) enumerateAndAddSessions(sessions *[]Session) error {
+	sinkInputs := proto.GetSinkInputInfoListReply{}
+	if err := sf.client.Request(&proto.GetSinkInputInfoList{}, &sinkInputs); err != nil {
 		sf.logger.Warnw("Failed to get sink input list", "error", err)
 		return fmt.Errorf("get sink input list: %w", err)
 	}
 
-	for _, info := range reply {
-		name, ok := info.Properties["application.process.binary"]
+	sourceOutputs := proto.GetSourceOutputInfoListReply{}
+	if err := sf.client.Request(&proto.GetSourceOutputInfoList{}, &sourceOutputs); err != nil {
+		sf.logger.Warnw("Failed to get source output list", "error", err)
+		return fmt.Errorf("get source output list: %w", err)
+	}
 
-		if !ok {
-			sf.logger.Warnw("Failed to get sink input's process name",
-				"sinkInputIndex", info.SinkInputIndex)
+	streams := make(map[paStream]*paSession, len(sinkInputs)+len(sourceOutputs))
 
-			continue
+	for _, info := range sinkInputs {
+		stream := paStream{index: info.SinkInputIndex}
+		if newSession, ok := sf.streamSession(stream, info.Channels, info.Properties); ok {
+			streams[stream] = newSession
 		}
+	}
 
-		// create the deej session object
-		newSession := newPASession(sf.sessionLogger, sf.client, info.SinkInputIndex, info.Channels, name.String())
+	for _, info := range sourceOutputs {
+		stream := paStream{index: info.SourceOutpuIndex, recording: true}
+		if newSession, ok := sf.streamSession(stream, info.Channels, info.Properties); ok {
+			streams[stream] = newSession
+		}
+	}
 
-		// add it to our slice
+	// add them to our slice
+	for _, newSession := range streams {
 		*sessions = append(*sessions, newSession)
+	}
+
+	// streams gone by now are forgotten
+	sf.lock.Lock()
+	sf.streams = streams
+	sf.lock.Unlock()
+
+	return nil
+}
+
+// streamSession returns the session of a sink input or source output, the one handed out before if there's any
+func (sf *paSessionFinder) streamSession(stream paStream, channels byte, properties proto.PropList) (*paSession, bool) {
+	sf.lock.Lock()
+	existing, ok := sf.streams[stream]
+	sf.lock.Unlock()
+
+	if ok {
+		return existing, true
+	}
+
+	streamProperties := streamProperties(properties)
+	name, ok := streamProperties.processName()
+
+	if !ok {
+		sf.logger.Warnw("Failed to get stream's process name",
+			"streamIndex", stream.index,
+			"recording", stream.recording)
+
+		return nil, false
+	}
 
+	// create the deej session object
+	return newPASession(sf.sessionLogger, sf.client, stream.index, channels, name, streamProperties, stream.recording), true
+}
+
+// WatchSessions subscribes to sink input, source output, sink, source and server events. new streams and devices
+// are reported as added sessions, and a change of the default sink or source as its master session being replaced
+func (sf *paSessionFinder) WatchSessions(ctx context.Context) (<-chan sessionChange, error) {
+	request := proto.Subscribe{
+		Mask: paSubscriptionMaskSink | paSubscriptionMaskSource | paSubscriptionMaskSinkInput |
+			paSubscriptionMaskSourceOutput | paSubscriptionMaskServer,
+	}
+
+	if err := sf.client.Request(&request, nil); err != nil {
+		sf.logger.Warnw("Failed to subscribe to PulseAudio events", "error", err)
+		return nil, fmt.Errorf("subscribe to PulseAudio events: %w", err)
+	}
+
+	changes := make(chan sessionChange)
+
+	go func() {
+		defer close(changes)
+
+		for {
+			events := sf.takeEvents()
+			if len(events) == 0 {
+				select {
+				case <-sf.eventWake:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			for _, event := range events {
+				for _, change := range sf.handleEvent(event) {
+					select {
+					case changes <- change:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// onMessage runs on the client's reading goroutine, so it only queues events for WatchSessions,
+// requests made from here would never get their replies
+func (sf *paSessionFinder) onMessage(message interface{}) {
+	event, ok := message.(*proto.SubscribeEvent)
+	if !ok {
+		return
+	}
+
+	sf.eventLock.Lock()
+	sf.events = append(sf.events, event)
+	sf.eventLock.Unlock()
+
+	select {
+	case sf.eventWake <- struct{}{}:
+	default:
+	}
+}
+
+func (sf *paSessionFinder) takeEvents() []*proto.SubscribeEvent {
+	sf.eventLock.Lock()
+	defer sf.eventLock.Unlock()
+
+	events := sf.events
+	sf.events = nil
+
+	return events
+}
+
+func (sf *paSessionFinder) handleEvent(event *proto.SubscribeEvent) []sessionChange {
+	facility := event.Event & paEventFacilityMask
+	eventType := event.Event & paEventTypeMask
+
+	switch facility {
+	case paEventSinkInput, paEventSourceOutput:
+		stream := paStream{index: event.Index, recording: facility == paEventSourceOutput}
+
+		switch eventType {
+		case paEventNew:
+			return sf.addStream(stream)
+		case paEventRemove:
+			return sf.removeStream(stream)
+		}
+
+	// a sink or source changes with every volume change, only its coming and going matters
+	case paEventSink, paEventSource:
+		if eventType == paEventNew || eventType == paEventRemove {
+			return append(sf.refreshDevices(), sf.updateMasterSessions()...)
+		}
+
+	case paEventServer:
+		return sf.updateMasterSessions()
 	}
 
 	return nil
 }
+
+func (sf *paSessionFinder) addStream(stream paStream) []sessionChange {
+	sf.lock.Lock()
+	_, known := sf.streams[stream]
+	sf.lock.Unlock()
+
+	// enumerated already by a refresh racing with this event
+	if known {
+		return nil
+	}
+
+	var channels byte
+	var properties proto.PropList
+
+	if stream.recording {
+		reply := proto.GetSourceOutputInfoReply{}
+		if err := sf.client.Request(&proto.GetSourceOutputInfo{SourceOutpuIndex: stream.index}, &reply); err != nil {
+			sf.logger.Debugw("Failed to get new source output info", "sourceOutputIndex", stream.index, "error", err)
+			return nil
+		}
+
+		channels, properties = reply.Channels, reply.Properties
+	} else {
+		reply := proto.GetSinkInputInfoReply{}
+		if err := sf.client.Request(&proto.GetSinkInputInfo{SinkInputIndex: stream.index}, &reply); err != nil {
+			sf.logger.Debugw("Failed to get new sink input info", "sinkInputIndex", stream.index, "error", err)
+			return nil
+		}
+
+		channels, properties = reply.Channels, reply.Properties
+	}
+
+	session, ok := sf.streamSession(stream, channels, properties)
+	if !ok {
+		return nil
+	}
+
+	sf.lock.Lock()
+	sf.streams[stream] = session
+	sf.lock.Unlock()
+
+	return []sessionChange{{Session: session}}
+}
+
+func (sf *paSessionFinder) removeStream(stream paStream) []sessionChange {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	session, ok := sf.streams[stream]
+	if !ok {
+		return nil
+	}
+
+	delete(sf.streams, stream)
+
+	return []sessionChange{{Session: session, Removed: true}}
+}
+
+// refreshDevices lists sinks and sources again, as a device coming or going can change the keys of others
+func (sf *paSessionFinder) refreshDevices() []sessionChange {
+	devices, err := sf.listDevices()
+	if err != nil {
+		return nil
+	}
+
+	_, changes := sf.setDevices(devices)
+
+	return changes
+}
+
+// updateMasterSessions replaces master sessions whose default sink or source is a different one now
+func (sf *paSessionFinder) updateMasterSessions() []sessionChange {
+	sf.lock.Lock()
+	previousSink, previousSource := sf.masterSink, sf.masterSource
+	sf.lock.Unlock()
+
+	changes := []sessionChange{}
+
+	replace := func(previous *masterSession, getSession func() (Session, error)) {
+		session, err := getSession()
+		if err != nil || (previous != nil && session == Session(previous)) {
+			return
+		}
+
+		if previous != nil {
+			changes = append(changes, sessionChange{Session: previous, Removed: true})
+		}
+		changes = append(changes, sessionChange{Session: session})
+	}
+
+	replace(previousSink, sf.getMasterSinkSession)
+	replace(previousSource, sf.getMasterSourceSession)
+
+	return changes
+}
+
+// CycleOutputDevice makes the sink after the current default one the new default, and moves playing
+// streams along, as older PulseAudio versions leave them behind. devices are matched by sink name or description
+func (sf *paSessionFinder) CycleOutputDevice(devices []string) (string, error) {
+	serverInfo := proto.GetServerInfoReply{}
+	if err := sf.client.Request(&proto.GetServerInfo{}, &serverInfo); err != nil {
+		return "", fmt.Errorf("get server info: %w", err)
+	}
+
+	sinks := proto.GetSinkInfoListReply{}
+	if err := sf.client.Request(&proto.GetSinkInfoList{}, &sinks); err != nil {
+		return "", fmt.Errorf("get sink list: %w", err)
+	}
+
+	candidates := sinks
+	if len(devices) > 0 {
+		candidates = nil
+
+		// follow the order the user gave, rather than the server's
+		for _, device := range devices {
+			for _, sink := range sinks {
+				if strings.EqualFold(device, sink.SinkName) || strings.EqualFold(device, sinkDescription(sink)) {
+					candidates = append(candidates, sink)
+				}
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no output devices match %v", devices)
+	}
+
+	// when the current default isn't one of the candidates, this starts from the first one
+	next := candidates[0]
+	for idx, sink := range candidates {
+		if sink.SinkName == serverInfo.DefaultSinkName {
+			next = candidates[(idx+1)%len(candidates)]
+			break
+		}
+	}
+
+	if err := sf.client.Request(&proto.SetDefaultSink{SinkName: next.SinkName}, nil); err != nil {
+		return "", fmt.Errorf("set default sink: %w", err)
+	}
+
+	sinkInputs := proto.GetSinkInputInfoListReply{}
+	if err := sf.client.Request(&proto.GetSinkInputInfoList{}, &sinkInputs); err != nil {
+		sf.logger.Warnw("Failed to get sink input list, streams stay on the previous device", "error", err)
+		return sinkDescription(next), nil
+	}
+
+	for _, sinkInput := range sinkInputs {
+		request := proto.MoveSinkInput{
+			SinkInputIndex: sinkInput.SinkInputIndex,
+			DeviceIndex:    next.SinkIndex,
+		}
+
+		if err := sf.client.Request(&request, nil); err != nil {
+			sf.logger.Warnw("Failed to move stream to the new output device",
+				"sinkInputIndex", sinkInput.SinkInputIndex,
+				"error", err)
+		}
+	}
+
+	return sinkDescription(next), nil
+}
+
+// sinkDescription returns the human readable name of a sink, falling back to its name
+func sinkDescription(sink *proto.GetSinkInfoReply) string {
+	if description, ok := sink.Properties["device.description"]; ok {
+		return description.String()
+	}
+
+	return sink.SinkName
+}
+
+// sourceDescription returns the human readable name of a source, falling back to its name
+func sourceDescription(source *proto.GetSourceInfoReply) string {
+	if description, ok := source.Properties["device.description"]; ok {
+		return description.String()
+	}
+
+	return source.SourceName
+}
+
+// monitorSource tells if the source only records what a sink plays, those aren't worth binding
+func monitorSource(source *proto.GetSourceInfoReply) bool {
+	return source.MonitorSourceIndex != proto.Undefined
+}
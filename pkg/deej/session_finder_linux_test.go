@@ -0,0 +1,147 @@
+package deej
+
+import (
+	"testing"
+
+	"github.com/jfreymuth/pulse/proto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestPASessionFinder_devices(t *testing.T) {
+	description := func(value string) proto.PropList {
+		return proto.PropList{"device.description": proto.PropListString(value)}
+	}
+
+	analogSink := &proto.GetSinkInfoReply{
+		SinkIndex:  1,
+		SinkName:   "alsa_output.pci-0000_00_1f.3.analog-stereo",
+		Properties: description("Built-in Audio Analog Stereo"),
+	}
+	hdmiSink := &proto.GetSinkInfoReply{
+		SinkIndex: 2,
+		SinkName:  "alsa_output.pci-0000_01_00.1.hdmi-stereo",
+	}
+	analogMonitor := &proto.GetSourceInfoReply{
+		SourceIndex:        3,
+		SourceName:         "alsa_output.pci-0000_00_1f.3.analog-stereo.monitor",
+		MonitorSourceIndex: 1,
+		Properties:         description("Monitor of Built-in Audio Analog Stereo"),
+	}
+	analogSource := &proto.GetSourceInfoReply{
+		SourceIndex:        4,
+		SourceName:         "alsa_input.pci-0000_00_1f.3.analog-stereo",
+		MonitorSourceIndex: proto.Undefined,
+		Properties:         description("Built-in Audio Analog Stereo"),
+	}
+	yeti := &proto.GetSourceInfoReply{
+		SourceIndex:        5,
+		SourceName:         "alsa_input.usb-Blue_Yeti",
+		MonitorSourceIndex: proto.Undefined,
+		Properties:         description("Yeti Stereo Microphone"),
+	}
+
+	sinks := proto.GetSinkInfoListReply{analogSink, hdmiSink}
+	sources := proto.GetSourceInfoListReply{analogMonitor, analogSource, yeti}
+
+	sf := &paSessionFinder{
+		sessionLogger: zap.NewNop().Sugar(),
+		devices:       make(map[paDeviceKey]*masterSession),
+	}
+
+	// every device by its name and description, monitors left out and the source kept apart from its sink
+	sessions, changes := sf.setDevices(deviceInfos(sinks, sources))
+
+	keys := func(sessions []Session) map[string]bool {
+		result := map[string]bool{}
+		for _, session := range sessions {
+			result[session.Key()] = session.(*masterSession).isOutput
+		}
+		return result
+	}
+
+	assert.Equal(t, map[string]bool{
+		"alsa_output.pci-0000_00_1f.3.analog-stereo": true,
+		"built-in audio analog stereo":               true,
+		"alsa_output.pci-0000_01_00.1.hdmi-stereo":   true,
+		"alsa_input.pci-0000_00_1f.3.analog-stereo":  false,
+		"built-in audio analog stereo input":         false,
+		"alsa_input.usb-blue_yeti":                   false,
+		"yeti stereo microphone":                     false,
+	}, keys(sessions))
+	assert.Len(t, changes, len(sessions), "all of them are new")
+
+	// the same devices again are the same sessions, and a device gone takes all of its keys along
+	again, changes := sf.setDevices(deviceInfos(proto.GetSinkInfoListReply{analogSink}, sources))
+
+	for _, session := range again {
+		assert.Contains(t, sessions, session)
+	}
+
+	if assert.Len(t, changes, 1) {
+		assert.True(t, changes[0].Removed)
+		assert.Equal(t, "alsa_output.pci-0000_01_00.1.hdmi-stereo", changes[0].Session.Key())
+	}
+
+	// with the sink gone, the source has its description to itself
+	_, changes = sf.setDevices(deviceInfos(nil, sources))
+
+	changed := []string{}
+	for _, change := range changes {
+		if change.Removed {
+			changed = append(changed, "-"+change.Session.Key())
+		} else {
+			changed = append(changed, "+"+change.Session.Key())
+		}
+	}
+
+	assert.ElementsMatch(t, []string{
+		"-alsa_output.pci-0000_00_1f.3.analog-stereo",
+		"-built-in audio analog stereo",
+		"-built-in audio analog stereo input",
+		"+built-in audio analog stereo",
+	}, changed)
+}
+
+func TestPASessionFinder_streamSession(t *testing.T) {
+	type testCase struct {
+		givenStream paStream
+		givenBinary string
+		expectedKey string
+	}
+
+	testCases := map[string]testCase{
+		"playback": {
+			givenStream: paStream{index: 7},
+			givenBinary: "Discord",
+			expectedKey: "discord",
+		},
+		"recording": {
+			givenStream: paStream{index: 7, recording: true},
+			givenBinary: "Discord",
+			expectedKey: "rec:discord",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			sf := &paSessionFinder{
+				logger:        zap.NewNop().Sugar(),
+				sessionLogger: zap.NewNop().Sugar(),
+				streams:       make(map[paStream]*paSession),
+			}
+
+			properties := proto.PropList{"application.process.binary": proto.PropListString(testCase.givenBinary)}
+
+			session, ok := sf.streamSession(testCase.givenStream, 2, properties)
+			assert.True(t, ok)
+			assert.Equal(t, testCase.expectedKey, session.Key())
+			assert.Equal(t, testCase.givenStream.recording, recordingSession(session.Key()))
+		})
+	}
+
+	// streams without a process name to go by aren't sessions at all
+	sf := &paSessionFinder{logger: zap.NewNop().Sugar(), streams: make(map[paStream]*paSession)}
+	_, ok := sf.streamSession(paStream{index: 8, recording: true}, 2, proto.PropList{})
+	assert.False(t, ok)
+}
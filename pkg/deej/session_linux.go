@@ -1,8 +1,12 @@
 package deej
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"go.uber.org/zap"
 
@@ -14,15 +18,129 @@ const maxVolume = 0x10000
 
 var errNoSuchProcess = errors.New("No such process")
 
+// paSession is an app's playback stream (sink input), or its recording stream (source output)
 type paSession struct {
 	baseSession
 
 	processName string
+	properties  paStreamProperties
 
 	client *proto.Client
 
-	sinkInputIndex    uint32
-	sinkInputChannels byte
+	streamIndex    uint32
+	streamChannels byte
+	recording      bool
+}
+
+// paStreamProperties is what's known about the app behind a stream, targets can match any of it
+type paStreamProperties struct {
+	binary  string // application.process.binary
+	name    string // application.name
+	id      string // application.id
+	role    string // media.role
+	exe     string // where application.process.id's executable is
+	flatpak string // the Flatpak app ID, from the portal or the process' sandbox
+}
+
+// where process information is read from, swapped by tests
+var procRoot = "/proc"
+
+// streamProperties picks what's known about the app behind a stream out of its properties. Flatpak apps,
+// sandboxed browsers and PipeWire-native clients often leave the process binary out, the rest makes up for it
+func streamProperties(properties proto.PropList) paStreamProperties {
+	get := func(key string) string {
+		if value, ok := properties[key]; ok {
+			return strings.TrimSpace(value.String())
+		}
+
+		return ""
+	}
+
+	p := paStreamProperties{
+		binary:  get("application.process.binary"),
+		name:    get("application.name"),
+		id:      get("application.id"),
+		role:    get("media.role"),
+		flatpak: get("pipewire.access.portal.app_id"),
+	}
+
+	if pid := get("application.process.id"); pid != "" {
+		if exe, err := os.Readlink(filepath.Join(procRoot, pid, "exe")); err == nil {
+			p.exe = exe
+		}
+
+		if p.flatpak == "" {
+			p.flatpak = flatpakAppID(pid)
+		}
+	}
+
+	return p
+}
+
+// flatpakAppID reads the app ID from the sandbox info of a Flatpak process, it's empty for any other process
+func flatpakAppID(pid string) string {
+	file, err := os.Open(filepath.Join(procRoot, pid, "root", ".flatpak-info"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+
+		if key, value, found := strings.Cut(line, "="); found && section == "[Application]" && strings.TrimSpace(key) == "name" {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
+
+// processName names the stream's session, by the first thing known out of its process binary, executable,
+// Flatpak app ID, app ID or app name. streams with none of these can't be told apart
+func (p paStreamProperties) processName() (string, bool) {
+	for _, name := range []string{p.binary, filepath.Base(p.exe), p.flatpak, p.id, p.name} {
+		if name != "" && name != "." && name != "/" {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// matchesProperty tells if the named property has the given value, ignoring case.
+// executables match by their full path or just their file name
+func (p paStreamProperties) matchesProperty(property string, value string) bool {
+	var candidates []string
+
+	switch property {
+	case sessionPropertyName:
+		candidates = []string{p.name}
+	case sessionPropertyID:
+		candidates = []string{p.id}
+	case sessionPropertyRole:
+		candidates = []string{p.role}
+	case sessionPropertyExe:
+		candidates = []string{p.exe, filepath.Base(p.exe), p.binary}
+	case sessionPropertyFlatpak:
+		candidates = []string{p.flatpak}
+	}
+
+	for _, candidate := range candidates {
+		if candidate != "" && strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+
+	return false
 }
 
 type masterSession struct {
@@ -38,21 +156,31 @@ type masterSession struct {
 func newPASession(
 	logger *zap.SugaredLogger,
 	client *proto.Client,
-	sinkInputIndex uint32,
-	sinkInputChannels byte,
+	streamIndex uint32,
+	streamChannels byte,
 	processName string,
+	properties paStreamProperties,
+	recording bool,
 ) *paSession {
 
 	s := &paSession{
-		client:            client,
-		sinkInputIndex:    sinkInputIndex,
-		sinkInputChannels: sinkInputChannels,
+		client:         client,
+		properties:     properties,
+		streamIndex:    streamIndex,
+		streamChannels: streamChannels,
+		recording:      recording,
 	}
 
 	s.processName = processName
 	s.name = processName
 	s.humanReadableDesc = processName
 
+	// recording streams live apart from playback ones, e.g. rec:discord
+	if recording {
+		s.name = recordingSessionPrefix + processName
+		s.humanReadableDesc = s.name
+	}
+
 	// use a self-identifying session name e.g. deej.sessions.chrome
 	s.logger = logger.Named(s.Key())
 	s.logger.Debugw(sessionCreationLogMessage, "session", s)
@@ -66,6 +194,7 @@ func newMasterSession(
 	streamIndex uint32,
 	streamChannels byte,
 	isOutput bool,
+	key string,
 ) *masterSession {
 
 	s := &masterSession{
@@ -75,14 +204,6 @@ func newMasterSession(
 		isOutput:       isOutput,
 	}
 
-	var key string
-
-	if isOutput {
-		key = masterSessionName
-	} else {
-		key = inputSessionName
-	}
-
 	s.logger = logger.Named(key)
 	s.master = true
 	s.name = key
@@ -93,29 +214,58 @@ func newMasterSession(
 	return s
 }
 
-func (s *paSession) GetVolume() float32 {
+// info returns the stream's current volumes and mute state
+func (s *paSession) info() (proto.ChannelVolumes, bool, error) {
+	if s.recording {
+		request := proto.GetSourceOutputInfo{
+			SourceOutpuIndex: s.streamIndex,
+		}
+		reply := proto.GetSourceOutputInfoReply{}
+
+		err := s.client.Request(&request, &reply)
+
+		return reply.ChannelVolumes, reply.Muted, err
+	}
+
 	request := proto.GetSinkInputInfo{
-		SinkInputIndex: s.sinkInputIndex,
+		SinkInputIndex: s.streamIndex,
 	}
 	reply := proto.GetSinkInputInfoReply{}
 
-	if err := s.client.Request(&request, &reply); err != nil {
+	err := s.client.Request(&request, &reply)
+
+	return reply.ChannelVolumes, reply.Muted, err
+}
+
+func (s *paSession) GetVolume() float32 {
+	volumes, _, err := s.info()
+	if err != nil {
 		s.logger.Warnw("Failed to get session volume", "error", err)
 	}
 
-	level := parseChannelVolumes(reply.ChannelVolumes)
+	level := parseChannelVolumes(volumes)
 
 	return level
 }
 
 func (s *paSession) SetVolume(v float32) error {
-	volumes := createChannelVolumes(s.sinkInputChannels, v)
-	request := proto.SetSinkInputVolume{
-		SinkInputIndex: s.sinkInputIndex,
-		ChannelVolumes: volumes,
+	var request proto.RequestArgs
+
+	volumes := createChannelVolumes(s.streamChannels, v)
+
+	if s.recording {
+		request = &proto.SetSourceOutputVolume{
+			SourceOutputIndex: s.streamIndex,
+			ChannelVolumes:    volumes,
+		}
+	} else {
+		request = &proto.SetSinkInputVolume{
+			SinkInputIndex: s.streamIndex,
+			ChannelVolumes: volumes,
+		}
 	}
 
-	if err := s.client.Request(&request, nil); err != nil {
+	if err := s.client.Request(request, nil); err != nil {
 		s.logger.Warnw("Failed to set session volume", "error", err)
 		return fmt.Errorf("adjust session volume: %w", err)
 	}
@@ -125,20 +275,43 @@ func (s *paSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *paSession) GetMute() bool {
+	_, muted, err := s.info()
+	if err != nil {
+		s.logger.Warnw("Failed to get session mute", "error", err)
+	}
+
+	return muted
+}
+
 func (s *paSession) SetMute(newState bool) error {
-	request := proto.SetSinkInputMute{
-		SinkInputIndex: s.sinkInputIndex,
-		Mute:           newState,
+	var request proto.RequestArgs
+
+	if s.recording {
+		request = &proto.SetSourceOutputMute{
+			SourceOutputIndex: s.streamIndex,
+			Mute:              newState,
+		}
+	} else {
+		request = &proto.SetSinkInputMute{
+			SinkInputIndex: s.streamIndex,
+			Mute:           newState,
+		}
 	}
 
-	if err := s.client.Request(&request, nil); err != nil {
-		return fmt.Errorf("mutting session to %b: %w", newState, err)
+	if err := s.client.Request(request, nil); err != nil {
+		s.logger.Warnw("Failed to set session mute", "error", err)
+		return fmt.Errorf("adjust session mute to %t: %w", newState, err)
 	}
 
-	s.logger.Debugf("Adjusting session mute to %b", newState)
+	s.logger.Debugw("Adjusting session mute", "to", newState)
 	return nil
 }
 
+func (s *paSession) matchesProperty(property string, value string) bool {
+	return s.properties.matchesProperty(property, value)
+}
+
 func (s *paSession) Release() {
 	s.logger.Debug("Releasing audio session")
 }
@@ -209,6 +382,62 @@ func (s *masterSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *masterSession) GetMute() bool {
+	if s.isOutput {
+		request := proto.GetSinkInfo{
+			SinkIndex: s.streamIndex,
+		}
+		reply := proto.GetSinkInfoReply{}
+
+		if err := s.client.Request(&request, &reply); err != nil {
+			s.logger.Warnw("Failed to get session mute", "error", err)
+			return false
+		}
+
+		return reply.Mute
+	}
+
+	request := proto.GetSourceInfo{
+		SourceIndex: s.streamIndex,
+	}
+	reply := proto.GetSourceInfoReply{}
+
+	if err := s.client.Request(&request, &reply); err != nil {
+		s.logger.Warnw("Failed to get session mute", "error", err)
+		return false
+	}
+
+	return reply.Mute
+}
+
+func (s *masterSession) SetMute(m bool) error {
+	var request proto.RequestArgs
+
+	if s.isOutput {
+		request = &proto.SetSinkMute{
+			SinkIndex: s.streamIndex,
+			Mute:      m,
+		}
+	} else {
+		request = &proto.SetSourceMute{
+			SourceIndex: s.streamIndex,
+			Mute:        m,
+		}
+	}
+
+	if err := s.client.Request(request, nil); err != nil {
+		s.logger.Warnw("Failed to set session mute",
+			"error", err,
+			"mute", m)
+
+		return fmt.Errorf("adjust session mute: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session mute", "to", m)
+
+	return nil
+}
+
 func (s *masterSession) Release() {
 	s.logger.Debug("Releasing audio session")
 }
@@ -0,0 +1,213 @@
+package deej
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jfreymuth/pulse/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStreamProperties(t *testing.T) {
+	type testCase struct {
+		givenProperties    map[string]string
+		expectedName       string
+		expectedUnnamed    bool
+		expectedMatches    map[string]string
+		expectedMismatches map[string]string
+	}
+
+	// a fake /proc with a regular process and a Flatpak one
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "100"), 0o755))
+	require.NoError(t, os.Symlink("/usr/lib/firefox/firefox", filepath.Join(root, "100", "exe")))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "200", "root"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "200", "root", ".flatpak-info"),
+		[]byte("[Application]\nname=com.discordapp.Discord\nruntime=runtime/org.freedesktop.Platform\n"), 0o644))
+
+	previousRoot := procRoot
+	procRoot = root
+	t.Cleanup(func() { procRoot = previousRoot })
+
+	testCases := map[string]testCase{
+		"process binary": {
+			givenProperties: map[string]string{
+				"application.process.binary": "spotify",
+				"application.name":           "Spotify",
+				"media.role":                 "music",
+			},
+			expectedName:       "spotify",
+			expectedMatches:    map[string]string{"name": "spotify", "role": "MUSIC", "exe": "spotify"},
+			expectedMismatches: map[string]string{"role": "video", "id": "spotify"},
+		},
+		"executable of the process": {
+			givenProperties: map[string]string{
+				"application.process.id": "100",
+				"application.name":       "Firefox",
+			},
+			expectedName: "firefox",
+			expectedMatches: map[string]string{
+				"exe":  "/usr/lib/firefox/firefox",
+				"name": "firefox",
+			},
+		},
+		"flatpak sandbox": {
+			givenProperties: map[string]string{
+				"application.process.id": "200",
+				"application.name":       "Discord",
+			},
+			expectedName:    "com.discordapp.Discord",
+			expectedMatches: map[string]string{"flatpak": "com.discordapp.discord"},
+		},
+		"flatpak portal": {
+			givenProperties: map[string]string{
+				"pipewire.access.portal.app_id": "org.mozilla.firefox",
+				"application.id":                "org.mozilla.firefox",
+			},
+			expectedName:    "org.mozilla.firefox",
+			expectedMatches: map[string]string{"flatpak": "org.mozilla.firefox", "id": "org.mozilla.firefox"},
+		},
+		"pipewire-native client": {
+			givenProperties: map[string]string{"application.name": "WirePlumber"},
+			expectedName:    "WirePlumber",
+		},
+		"gone process": {
+			givenProperties: map[string]string{"application.process.id": "300"},
+			expectedUnnamed: true,
+			expectedMismatches: map[string]string{
+				"exe":     "",
+				"flatpak": "",
+			},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			properties := proto.PropList{}
+			for key, value := range testCase.givenProperties {
+				properties[key] = proto.PropListString(value)
+			}
+
+			p := streamProperties(properties)
+
+			name, ok := p.processName()
+			assert.Equal(t, !testCase.expectedUnnamed, ok)
+			assert.Equal(t, testCase.expectedName, name)
+
+			for property, value := range testCase.expectedMatches {
+				assert.True(t, p.matchesProperty(property, value), "%s:%s", property, value)
+			}
+			for property, value := range testCase.expectedMismatches {
+				assert.False(t, p.matchesProperty(property, value), "%s:%s", property, value)
+			}
+		})
+	}
+}
+
+// paRequest is the operation of a request a client sent, and the index of the object it's about
+type paRequest struct {
+	op    uint32
+	index uint32
+}
+
+// fakePAServer connects a client to a server acknowledging every request, and returns what it was asked
+func fakePAServer(t *testing.T) (*proto.Client, func() []paRequest) {
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	var lock sync.Mutex
+	requests := []paRequest{}
+
+	go func() {
+		header := make([]byte, 20)
+		for {
+			if _, err := io.ReadFull(serverConn, header); err != nil {
+				return
+			}
+
+			// 'L' op 'L' tag, then the arguments, starting with 'L' index for the requests used here
+			packet := make([]byte, binary.BigEndian.Uint32(header))
+			if _, err := io.ReadFull(serverConn, packet); err != nil {
+				return
+			}
+
+			op, tag := binary.BigEndian.Uint32(packet[1:]), binary.BigEndian.Uint32(packet[6:])
+			request := paRequest{op: op}
+			if len(packet) >= 15 {
+				request.index = binary.BigEndian.Uint32(packet[11:])
+			}
+
+			lock.Lock()
+			requests = append(requests, request)
+			lock.Unlock()
+
+			reply := make([]byte, 30)
+			binary.BigEndian.PutUint32(reply, 10)
+			binary.BigEndian.PutUint32(reply[4:], 0xFFFFFFFF)
+			reply[20] = 'L'
+			binary.BigEndian.PutUint32(reply[21:], proto.OpReply)
+			reply[25] = 'L'
+			binary.BigEndian.PutUint32(reply[26:], tag)
+
+			if _, err := serverConn.Write(reply); err != nil {
+				return
+			}
+		}
+	}()
+
+	client := &proto.Client{}
+	client.Open(clientConn)
+
+	return client, func() []paRequest {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return append([]paRequest(nil), requests...)
+	}
+}
+
+func TestPASession_requests(t *testing.T) {
+	type testCase struct {
+		givenRecording   bool
+		expectedRequests []paRequest
+	}
+
+	testCases := map[string]testCase{
+		"playback": {
+			expectedRequests: []paRequest{
+				{op: proto.OpSetSinkInputVolume, index: 7},
+				{op: proto.OpSetSinkInputMute, index: 7},
+			},
+		},
+		"recording": {
+			givenRecording: true,
+			expectedRequests: []paRequest{
+				{op: proto.OpSetSourceOutputVolume, index: 7},
+				{op: proto.OpSetSourceOutputMute, index: 7},
+			},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			client, requests := fakePAServer(t)
+
+			session := newPASession(zap.NewNop().Sugar(), client, 7, 2, "discord", paStreamProperties{}, testCase.givenRecording)
+
+			require.NoError(t, session.SetVolume(0.5))
+			require.NoError(t, session.SetMute(true))
+
+			assert.Equal(t, testCase.expectedRequests, requests())
+		})
+	}
+}
@@ -1,13 +1,16 @@
 package deej
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/omriharel/deej/pkg/deej/util"
+	"github.com/omriharel/deej/pkg/device"
 	"github.com/thoas/go-funk"
 	"go.uber.org/zap"
 )
@@ -21,8 +24,44 @@ type SessionMap struct {
 
 	sessionFinder SessionFinder
 
+	// set when the session finder tells about sessions coming and going, sparing refreshes to find them
+	watched bool
+
+	// eases session volumes towards slider values, for sliders configured with a ramp
+	ramper *volumeRamper
+
 	lastSessionRefresh time.Time
-	unmappedSessions   []Session
+
+	// sessions no slider or encoder targets, guarded by lock as sessions come and go while sliders move
+	unmappedSessions []Session
+
+	// what every connected mixer told about itself, missing until its handshake settles
+	deviceInfo map[string]device.DeviceInfo
+
+	// keys of sessions muted by each soloing button, to unmute them when it stops
+	soloMuted map[soloOwner][]string
+
+	// where each slider was last moved to, for sessions showing up after that
+	sliderValues map[sliderKey]float32
+
+	// wake up gesture recognition of every mixer when a gesture completes without any button
+	// changing, e.g. a long press or a press no longer followed by a second one
+	gestureTimers map[string]*time.Timer
+
+	// button events of every mixer, carried out one after another by a worker of its own
+	buttonQueues map[string]chan buttonEvent
+}
+
+// soloOwner is the button of a mixer soloing its targets
+type soloOwner struct {
+	device string
+	button int
+}
+
+// sliderKey is a slider of a mixer
+type sliderKey struct {
+	device string
+	slider int
 }
 
 const (
@@ -40,6 +79,9 @@ const (
 	// targets all currently unmapped sessions (experimental)
 	specialTargetAllUnmapped = "unmapped"
 
+	// keys of app recording streams start with this, e.g. "rec:discord" (Linux-only)
+	recordingSessionPrefix = "rec:"
+
 	// this threshold constant assumes that re-acquiring all sessions is a kind of expensive operation,
 	// and needs to be limited in some manner. this value was previously user-configurable through a config
 	// key "process_refresh_frequency", but exposing this type of implementation detail seems wrong now
@@ -49,12 +91,12 @@ const (
 	// this is a bit greedy but allows us to ensure sessions are always re-acquired, which is
 	// especially important for process groups (because you can have one ongoing session
 	// always preventing lookup of other processes bound to its slider, which forces the user
-	// to manually refresh sessions). a cleaner way to do this down the line is by registering to notifications
-	// whenever a new session is added, but that's too hard to justify for how easy this solution is
+	// to manually refresh sessions). session finders telling about new sessions don't need this
 	maxTimeBetweenSessionRefreshes = time.Second * 45
 )
 
-// this matches friendly device names (on Windows), e.g. "Headphones (Realtek Audio)"
+// this matches friendly device names (on Windows), e.g. "Headphones (Realtek Audio)".
+// sessions of Linux devices tell they're devices themselves, their names have no such pattern
 var deviceSessionKeyPattern = regexp.MustCompile(`^.+ \(.+\)$`)
 
 func newSessionMap(deej *Deej, logger *zap.SugaredLogger, sessionFinder SessionFinder) (*SessionMap, error) {
@@ -66,6 +108,12 @@ func newSessionMap(deej *Deej, logger *zap.SugaredLogger, sessionFinder SessionF
 		m:             make(map[string][]Session),
 		lock:          &sync.Mutex{},
 		sessionFinder: sessionFinder,
+		ramper:        newVolumeRamper(logger.Named("ramp")),
+		deviceInfo:    make(map[string]device.DeviceInfo),
+		soloMuted:     make(map[soloOwner][]string),
+		sliderValues:  make(map[sliderKey]float32),
+		gestureTimers: make(map[string]*time.Timer),
+		buttonQueues:  make(map[string]chan buttonEvent),
 	}
 
 	logger.Debug("Created session map instance")
@@ -79,9 +127,10 @@ func (m *SessionMap) initialize() error {
 		return fmt.Errorf("get all sessions during init: %w", err)
 	}
 
+	m.setupOnSessionChanges()
 	m.setupOnConfigReload()
 	m.setupOnSliderMove()
-	m.setupOnMute()
+	m.setupOnMixerInput()
 
 	return nil
 }
@@ -105,7 +154,10 @@ func (m *SessionMap) getAndAddSessions() error {
 
 	// mark that we're refreshing before anything else
 	m.lastSessionRefresh = time.Now()
+
+	m.lock.Lock()
 	m.unmappedSessions = nil
+	m.lock.Unlock()
 
 	sessions, err := m.sessionFinder.GetAllSessions()
 	if err != nil {
@@ -118,7 +170,7 @@ func (m *SessionMap) getAndAddSessions() error {
 
 		if !m.sessionMapped(session) {
 			m.logger.Debugw("Tracking unmapped session", "session", session)
-			m.unmappedSessions = append(m.unmappedSessions, session)
+			m.trackUnmapped(session)
 		}
 	}
 
@@ -127,11 +179,42 @@ func (m *SessionMap) getAndAddSessions() error {
 	return nil
 }
 
+// setupOnSessionChanges keeps the map up to date with sessions coming and going, where the session finder tells about them
+func (m *SessionMap) setupOnSessionChanges() {
+	watcher, ok := m.sessionFinder.(sessionWatcher)
+	if !ok {
+		return
+	}
+
+	changes, err := watcher.WatchSessions(m.deej.ctx)
+	if err != nil {
+		m.logger.Warnw("Failed to watch audio sessions, refreshing them periodically instead", "error", err)
+		return
+	}
+
+	m.watched = true
+
+	go func() {
+		for change := range changes {
+			if change.Removed {
+				m.removeSession(change.Session)
+			} else {
+				m.addSession(change.Session)
+			}
+		}
+	}()
+}
+
 func (m *SessionMap) setupOnConfigReload() {
-	configReloadedChannel := m.deej.config.SubscribeToChanges()
+
+	// reloads coming in while sessions are being re-acquired only need a single refresh after that
+	configReloads := m.deej.bus.configReloads.subscribe(m.deej.ctx, subscribeOptions[configReloadEvent]{
+		size:     1,
+		overflow: dropOldest,
+	})
 
 	go func() {
-		for range configReloadedChannel {
+		for range configReloads.events {
 			m.logger.Info("Detected config reload, attempting to re-acquire all audio sessions")
 			m.refreshSessions(false)
 		}
@@ -139,17 +222,44 @@ func (m *SessionMap) setupOnConfigReload() {
 }
 
 func (m *SessionMap) setupOnSliderMove() {
-	sliderEventsChannel := m.deej.serial.SubscribeToSliderMoveEvents()
+
+	// a slider that moved again before its previous move was handled only needs its latest position
+	sliderMoves := m.deej.bus.sliderMoves.subscribe(m.deej.ctx, subscribeOptions[SliderMoveEvent]{
+		coalesce: func(event SliderMoveEvent) any {
+			return sliderKey{device: event.Device, slider: event.SliderID}
+		},
+	})
 
 	go func() {
-		for event := range sliderEventsChannel {
+		for event := range sliderMoves.events {
 			m.handleSliderMoveEvent(event)
 		}
 	}()
 }
 
-func (m *SessionMap) setupOnMute() {
-	m.deej.serial.muteConsumer = m
+// setupOnMixerInput takes buttons, encoders and handshakes of every mixer
+func (m *SessionMap) setupOnMixerInput() {
+	buttons := m.deej.bus.buttons.subscribe(m.deej.ctx, subscribeOptions[buttonsEvent]{})
+	encoders := m.deej.bus.encoders.subscribe(m.deej.ctx, subscribeOptions[encoderEvent]{})
+	deviceInfo := m.deej.bus.deviceInfo.subscribe(m.deej.ctx, subscribeOptions[deviceInfoEvent]{})
+
+	go func() {
+		for event := range buttons.events {
+			m.Mute(event.Device, event.Buttons)
+		}
+	}()
+
+	go func() {
+		for event := range encoders.events {
+			m.Turn(event.Device, event.Deltas)
+		}
+	}()
+
+	go func() {
+		for event := range deviceInfo.events {
+			m.OnDeviceInfo(event.Device, event.Info)
+		}
+	}()
 }
 
 // performance: explain why force == true at every such use to avoid unintended forced refresh spams
@@ -160,6 +270,9 @@ func (m *SessionMap) refreshSessions(force bool) {
 		return
 	}
 
+	// remember what was there, sessions of apps started since then get their sliders' values
+	known := m.keys()
+
 	// clear and release sessions first
 	m.clear()
 
@@ -168,6 +281,8 @@ func (m *SessionMap) refreshSessions(force bool) {
 	} else {
 		m.logger.Debug("Re-acquired sessions successfully")
 	}
+
+	m.applySliderValues(m.sessionsExcept(known))
 }
 
 // returns true if a session is not currently mapped to any slider, false otherwise
@@ -175,20 +290,16 @@ func (m *SessionMap) refreshSessions(force bool) {
 // even when absent from the config. this makes sense for every current feature that uses "unmapped sessions"
 func (m *SessionMap) sessionMapped(session Session) bool {
 
-	// count master/system/mic as mapped
-	if funk.ContainsString([]string{masterSessionName, systemSessionName, inputSessionName}, session.Key()) {
-		return true
-	}
-
-	// count device sessions as mapped
-	if deviceSessionKeyPattern.MatchString(session.Key()) {
+	// count master/system/mic and device sessions as mapped, and recording streams too
+	// as they're never meant to follow a slider controlling every other app
+	if m.deviceSession(session) || recordingSession(session.Key()) {
 		return true
 	}
 
 	matchFound := false
 
-	// look through the actual mappings
-	m.deej.config.SliderMapping.iterate(func(sliderIdx int, targets []string) {
+	// look through the actual mappings, of sliders and encoders of every mixer alike
+	findTarget := func(_ int, targets []string) {
 		for _, target := range targets {
 
 			// ignore special transforms
@@ -199,35 +310,147 @@ func (m *SessionMap) sessionMapped(session Session) bool {
 			// safe to assume this has a single element because we made sure there's no special transform
 			target = m.resolveTarget(target)[0]
 
-			if target == session.Key() {
+			if sessionMatches(session, target) {
 				matchFound = true
 				return
 			}
 		}
-	})
+	}
+
+	for _, profile := range m.deej.config.devices() {
+		profile.SliderMapping.iterate(findTarget)
+		profile.EncoderMapping.iterate(findTarget)
+	}
 
 	return matchFound
 }
 
-func (m *SessionMap) Mute(mutes []bool) {
-	// for each mute input
-	for i, mute := range mutes {
+// Mute takes button states read from the named mixer
+func (m *SessionMap) Mute(deviceName string, buttons []bool) {
+	profile := m.deej.config.device(deviceName)
+	if profile == nil {
+		return
+	}
+
+	// turn raw button states into events, following each button's action and mode
+	events := profile.MuteMapping.update(buttons)
+	events = append(events, profile.GestureMapping.update(buttons, time.Now())...)
+	m.scheduleGestures(deviceName)
 
-		// find what targets are configured
-		targets, found := m.deej.config.MuteMapping.Get(i)
-		if !found {
-			continue
+	// light up mixer LEDs of every button asking for mute
+	m.deej.sendFeedback(deviceName, device.LEDCommand(profile.MuteMapping.states(len(buttons))))
+
+	m.queueButtonEvents(deviceName, events)
+}
+
+// scheduleGestures arms the mixer's gesture timer for the next gesture that completes with time alone
+func (m *SessionMap) scheduleGestures(deviceName string) {
+	var deadline time.Time
+	ok := false
+	if profile := m.deej.config.device(deviceName); profile != nil {
+		deadline, ok = profile.GestureMapping.deadline()
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if timer, running := m.gestureTimers[deviceName]; running {
+		timer.Stop()
+		delete(m.gestureTimers, deviceName)
+	}
+
+	if ok {
+		m.gestureTimers[deviceName] = time.AfterFunc(time.Until(deadline), func() {
+			m.expireGestures(deviceName)
+		})
+	}
+}
+
+func (m *SessionMap) expireGestures(deviceName string) {
+	profile := m.deej.config.device(deviceName)
+	if profile == nil {
+		return
+	}
+
+	events := profile.GestureMapping.expire(time.Now())
+	m.scheduleGestures(deviceName)
+
+	m.queueButtonEvents(deviceName, events)
+}
+
+// buttonQueueSize is how many button events of a mixer may wait for the ones before them
+const buttonQueueSize = 32
+
+// queueButtonEvents hands events to the mixer's button worker, starting it with the mixer's first events.
+// the worker carries them out in the order they came in: a press and its release otherwise run in
+// either order, e.g. leaving the mic open after a push-to-talk tap
+func (m *SessionMap) queueButtonEvents(deviceName string, events []buttonEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	m.lock.Lock()
+	queue, running := m.buttonQueues[deviceName]
+	if !running {
+		queue = make(chan buttonEvent, buttonQueueSize)
+		m.buttonQueues[deviceName] = queue
+		go m.handleButtonEvents(deviceName, queue)
+	}
+	m.lock.Unlock()
+
+	for _, event := range events {
+		select {
+		case <-m.deej.ctx.Done():
+			return
+		case queue <- event:
 		}
+	}
+}
 
-		// for each target set it mute status
-		for _, target := range targets {
-			go m.handleMuteEvent(mute, target)
+func (m *SessionMap) handleButtonEvents(deviceName string, queue <-chan buttonEvent) {
+	for {
+		select {
+		case <-m.deej.ctx.Done():
+			return
+		case event := <-queue:
+			m.handleButtonEvent(deviceName, event)
 		}
 	}
 }
 
-func (m *SessionMap) OnMute(mutes []bool) {
-	m.Mute(mutes)
+func (m *SessionMap) handleButtonEvent(deviceName string, event buttonEvent) {
+	if m.deej.Verbose() {
+		m.logger.Debugw("Button action",
+			"device", deviceName,
+			"button", event.button,
+			"gesture", event.gesture,
+			"action", event.action,
+			"active", event.active)
+	}
+
+	data := actionData{
+		Device:  deviceName,
+		Button:  event.button,
+		Gesture: event.gesture,
+		Active:  event.active,
+	}
+
+	if err := event.action.run.Execute(m, data); err != nil {
+		m.logger.Warnw("Failed to carry out button action",
+			"button", event.button,
+			"action", event.action,
+			"error", err)
+	}
+}
+
+// OnDeviceInfo takes what the named mixer told about itself once connected
+func (m *SessionMap) OnDeviceInfo(deviceName string, info device.DeviceInfo) {
+	m.logger.Infow("Mixer connected", "name", deviceName, "device", info)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.deviceInfo[deviceName] = info
 }
 
 func (m *SessionMap) handleMuteEvent(mute bool, target string) {
@@ -239,7 +462,7 @@ func (m *SessionMap) handleMuteEvent(mute bool, target string) {
 	for _, resolvedTarget := range resolvedTargets {
 
 		// check the map for matching sessions
-		sessions, ok := m.get(resolvedTarget)
+		sessions, ok := m.find(resolvedTarget)
 
 		// no sessions matching this target - move on
 		if !ok {
@@ -248,48 +471,403 @@ func (m *SessionMap) handleMuteEvent(mute bool, target string) {
 
 		//targetFound = true
 
-		// iterate all matching sessions and adjust the volume of each one
+		// iterate all matching sessions and reconcile their mute state with the button's
 		for _, session := range sessions {
-			//if session.GetVolume() != event.PercentValue {
+			if session.GetMute() == mute {
+				continue
+			}
+
 			if err := session.SetMute(mute); err != nil {
 				m.logger.Warnw("Failed to set target session mute", "error", err)
-				//		adjustmentFailed = true
 			}
-			//}
 		}
 	}
 }
 
-func (m *SessionMap) OnVolume(volumes []int) {
-	for i, volume := range volumes {
-		percent := float32(volume) / 1024
-		m.handleSliderMoveEvent(SliderMoveEvent{
-			SliderID:     i,
-			PercentValue: percent,
+var _ actionExecutor = (*SessionMap)(nil)
+
+func (m *SessionMap) muteTargets(targets []string, mute bool) {
+	for _, target := range targets {
+		m.handleMuteEvent(mute, target)
+	}
+}
+
+func (m *SessionMap) solo(deviceName string, button int, solo bool, targets []string) {
+	m.handleSoloEvent(deviceName, button, solo, targets)
+}
+
+func (m *SessionMap) sendMediaKey(key util.MediaKey) error {
+	return util.SendMediaKey(key)
+}
+
+func (m *SessionMap) runCommand(command string, args []string) error {
+	return util.StartCommand(m.logger, command, args...)
+}
+
+// handleSoloEvent mutes every app session except the button's targets, or unmutes the ones
+// it muted before. device sessions (master, mic...) are left alone, muting them would silence the targets too,
+// and so are recording streams, soloing is about what's heard
+func (m *SessionMap) handleSoloEvent(deviceName string, button int, solo bool, targets []string) {
+	owner := soloOwner{device: deviceName, button: button}
+
+	if !solo {
+		m.lock.Lock()
+		keys := m.soloMuted[owner]
+		delete(m.soloMuted, owner)
+		m.lock.Unlock()
+
+		for _, key := range keys {
+			sessions, _ := m.get(key)
+			for _, session := range sessions {
+				if err := session.SetMute(false); err != nil {
+					m.logger.Warnw("Failed to unmute session after solo", "session", session, "error", err)
+				}
+			}
+		}
+
+		return
+	}
+
+	soloed := []string{}
+	for _, target := range targets {
+		soloed = append(soloed, m.resolveTarget(target)...)
+	}
+
+	m.lock.Lock()
+	candidates := make(map[string][]Session, len(m.m))
+	for key, sessions := range m.m {
+		for _, session := range sessions {
+			if m.deviceSession(session) || recordingSession(key) {
+				continue
+			}
+
+			if slices.ContainsFunc(soloed, func(target string) bool { return sessionMatches(session, target) }) {
+				continue
+			}
+
+			candidates[key] = append(candidates[key], session)
+		}
+	}
+	m.lock.Unlock()
+
+	// only remember sessions muted here, the ones muted already should stay so after solo
+	muted := []string{}
+	for key, sessions := range candidates {
+		mutedKey := false
+
+		for _, session := range sessions {
+			if session.GetMute() {
+				continue
+			}
+
+			if err := session.SetMute(true); err != nil {
+				m.logger.Warnw("Failed to mute session for solo", "session", session, "error", err)
+				continue
+			}
+			mutedKey = true
+		}
+
+		if mutedKey {
+			muted = append(muted, key)
+		}
+	}
+
+	m.lock.Lock()
+	m.soloMuted[owner] = append(m.soloMuted[owner], muted...)
+	m.lock.Unlock()
+}
+
+// cycleOutputDevice switches the default output device to the next one, if the platform allows it
+func (m *SessionMap) cycleOutputDevice(devices []string) error {
+	cycler, ok := m.sessionFinder.(outputDeviceCycler)
+	if !ok {
+		return errors.New("switching output devices isn't supported on this platform")
+	}
+
+	device, err := cycler.CycleOutputDevice(devices)
+	if err != nil {
+		return fmt.Errorf("switch output device: %w", err)
+	}
+
+	m.logger.Infow("Switched output device", "device", device)
+
+	// performance: master session still points to the previous device, so it has to be re-acquired
+	m.refreshSessions(true)
+
+	return nil
+}
+
+// deviceSession tells whether a session belongs to a device rather than an app, by what the session tells
+// about itself or else by its key. device names on Linux can look like anything, e.g. "Built-in Audio Analog Stereo"
+func (m *SessionMap) deviceSession(session Session) bool {
+	if device, ok := session.(interface{ device() bool }); ok && device.device() {
+		return true
+	}
+
+	key := session.Key()
+
+	return funk.ContainsString([]string{masterSessionName, systemSessionName, inputSessionName}, key) ||
+		deviceSessionKeyPattern.MatchString(key)
+}
+
+// recordingSession tells whether a session key belongs to an app's recording stream
+func recordingSession(key string) bool {
+	return strings.HasPrefix(key, recordingSessionPrefix)
+}
+
+// sliderVolumes returns the real volume of the first session bound to each slider,
+// scaled to the device range. Sliders without any live session are reported as -1
+func (m *SessionMap) sliderVolumes(deviceName string) []int {
+	profile := m.deej.config.device(deviceName)
+	if profile == nil {
+		return nil
+	}
+
+	m.lock.Lock()
+	sliderCount := m.deviceInfo[deviceName].Sliders
+	m.lock.Unlock()
+
+	// fall back to mapped sliders when the device didn't tell how many it has
+	if sliderCount == 0 {
+		profile.SliderMapping.iterate(func(sliderIdx int, _ []string) {
+			sliderCount = max(sliderCount, sliderIdx+1)
 		})
 	}
+
+	volumes := make([]int, sliderCount)
+	for sliderIdx := range volumes {
+		volumes[sliderIdx] = -1
+
+		targets, _ := profile.SliderMapping.get(sliderIdx)
+		for _, target := range targets {
+			if session, ok := m.firstSession(target); ok {
+				volumes[sliderIdx] = int(session.GetVolume()*device.MaxValue + 0.5)
+				break
+			}
+		}
+	}
+
+	return volumes
+}
+
+// muteStates returns the real mute state of the first session bound to each mute button,
+// mute buttons without any live session are reported as unmuted
+func (m *SessionMap) muteStates(deviceName string) []bool {
+	profile := m.deej.config.device(deviceName)
+	if profile == nil {
+		return nil
+	}
+
+	m.lock.Lock()
+	buttonCount := m.deviceInfo[deviceName].Buttons
+	m.lock.Unlock()
+
+	// fall back to mapped buttons when the device didn't tell how many it has
+	if buttonCount == 0 {
+		profile.MuteMapping.iterate(func(buttonIdx int, _ []string) {
+			buttonCount = max(buttonCount, buttonIdx+1)
+		})
+	}
+
+	// buttons with other actions light up while they're active, e.g. soloing
+	mutes := profile.MuteMapping.states(buttonCount)
+	for buttonIdx := range mutes {
+		action, ok := profile.MuteMapping.action(buttonIdx)
+		if !ok || action.kind != buttonActionMute {
+			continue
+		}
+
+		mutes[buttonIdx] = false
+		for _, target := range action.targets {
+			if session, ok := m.firstSession(target); ok {
+				mutes[buttonIdx] = session.GetMute()
+				break
+			}
+		}
+	}
+
+	return mutes
+}
+
+func (m *SessionMap) firstSession(target string) (Session, bool) {
+	for _, resolvedTarget := range m.resolveTarget(target) {
+		if sessions, ok := m.find(resolvedTarget); ok && len(sessions) > 0 {
+			return sessions[0], true
+		}
+	}
+
+	return nil, false
 }
 
 func (m *SessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 
+	// sessions showing up later get the slider's value too, no matter if any is there now
+	m.lock.Lock()
+	m.sliderValues[sliderKey{device: event.Device, slider: event.SliderID}] = event.PercentValue
+	m.lock.Unlock()
+
 	// first of all, ensure our session map isn't moldy
-	if m.lastSessionRefresh.Add(maxTimeBetweenSessionRefreshes).Before(time.Now()) {
+	if !m.watched && m.lastSessionRefresh.Add(maxTimeBetweenSessionRefreshes).Before(time.Now()) {
 		m.logger.Debug("Stale session map detected on slider move, refreshing")
 		m.refreshSessions(true)
 	}
 
-	// get the targets mapped to this slider from the config
-	targets, ok := m.deej.config.SliderMapping.get(event.SliderID)
+	// get the targets mapped to this slider from the config of its mixer
+	profile := m.deej.config.device(event.Device)
+	if profile == nil {
+		return
+	}
+	targets, ok := profile.SliderMapping.get(event.SliderID)
 
 	// if slider not found in config, silently ignore
 	if !ok {
 		return
 	}
 
-	targetFound := false
 	adjustmentFailed := false
 
-	// for each possible target for this slider...
+	// sliders with a ramp configured ease their sessions into the new volume, instead of jumping to it
+	ramp := profile.VolumeRamp.forSlider(event.SliderID)
+
+	sessions, targetFound := m.targetSessions(targets)
+
+	// iterate all matching sessions and adjust the volume of each one
+	for _, session := range sessions {
+		if session.GetVolume() != event.PercentValue {
+			if err := m.ramper.setVolume(session, event.PercentValue, ramp); err != nil {
+				m.logger.Warnw("Failed to set target session volume", "error", err)
+				adjustmentFailed = true
+			}
+		}
+	}
+
+	m.refreshAfterAdjustment(targetFound, adjustmentFailed)
+}
+
+// applySliderValues sets new sessions to where the sliders targeting them were last moved,
+// so apps started after a slider moved don't keep whatever volume the system gave them
+func (m *SessionMap) applySliderValues(sessions []Session) {
+	if len(sessions) == 0 {
+		return
+	}
+
+	m.lock.Lock()
+	sliders := make([]sliderKey, 0, len(m.sliderValues))
+	values := make(map[sliderKey]float32, len(m.sliderValues))
+	for slider, value := range m.sliderValues {
+		sliders = append(sliders, slider)
+		values[slider] = value
+	}
+	m.lock.Unlock()
+
+	// sessions targeted by several sliders end up with the same one's value every time
+	slices.SortFunc(sliders, func(a, b sliderKey) int {
+		if a.device != b.device {
+			return strings.Compare(a.device, b.device)
+		}
+
+		return a.slider - b.slider
+	})
+
+	for _, slider := range sliders {
+		profile := m.deej.config.device(slider.device)
+		if profile == nil {
+			continue
+		}
+
+		targets, ok := profile.SliderMapping.get(slider.slider)
+		if !ok {
+			continue
+		}
+
+		for _, session := range sessions {
+			if !m.sessionTargeted(session, targets) || session.GetVolume() == values[slider] {
+				continue
+			}
+
+			m.logger.Debugw("Applying slider value to new session",
+				"session", session.Key(),
+				"device", slider.device,
+				"slider", slider.slider,
+				"volume", values[slider])
+
+			if err := m.ramper.setVolume(session, values[slider], 0); err != nil {
+				m.logger.Warnw("Failed to set new session volume", "error", err)
+			}
+		}
+	}
+}
+
+// sessionTargeted tells if any of the targets resolves to the session
+func (m *SessionMap) sessionTargeted(session Session, targets []string) bool {
+	for _, target := range targets {
+		if slices.ContainsFunc(m.resolveTarget(target), func(resolvedTarget string) bool {
+			return sessionMatches(session, resolvedTarget)
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Turn applies detents turned by rotary encoders of the named mixer as increments to the current volume
+// of their targets. encoders have no position of their own, so unlike sliders they can never disagree with the system
+func (m *SessionMap) Turn(deviceName string, deltas []int) {
+	profile := m.deej.config.device(deviceName)
+	if profile == nil {
+		return
+	}
+
+	for encoderIdx, delta := range deltas {
+		if delta != 0 {
+			m.handleEncoderTurn(profile, encoderIdx, delta)
+		}
+	}
+}
+
+func (m *SessionMap) handleEncoderTurn(profile *deviceProfile, encoderIdx int, delta int) {
+	if !m.watched && m.lastSessionRefresh.Add(maxTimeBetweenSessionRefreshes).Before(time.Now()) {
+		m.logger.Debug("Stale session map detected on encoder turn, refreshing")
+		m.refreshSessions(true)
+	}
+
+	targets, ok := profile.EncoderMapping.get(encoderIdx)
+	if !ok {
+		return
+	}
+
+	change := m.deej.config.Encoders.change(delta)
+	sessions, targetFound := m.targetSessions(targets)
+	adjustmentFailed := false
+
+	// a session matched by several targets should still move a single step
+	turned := map[Session]bool{}
+
+	for _, session := range sessions {
+		if turned[session] {
+			continue
+		}
+		turned[session] = true
+
+		volume := float32(clamp01(float64(session.GetVolume() + change)))
+
+		// setting the volume right away also stops a slider ramp still easing this session
+		if err := m.ramper.setVolume(session, volume, 0); err != nil {
+			m.logger.Warnw("Failed to turn target session volume", "error", err)
+			adjustmentFailed = true
+		}
+	}
+
+	m.refreshAfterAdjustment(targetFound, adjustmentFailed)
+}
+
+// targetSessions returns sessions matching any of the given targets, and whether any target matched
+func (m *SessionMap) targetSessions(targets []string) ([]Session, bool) {
+	targetFound := false
+	matched := []Session{}
+
+	// for each possible target...
 	for _, target := range targets {
 
 		// resolve the target name by cleaning it up and applying any special transformations.
@@ -300,7 +878,7 @@ func (m *SessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 		for _, resolvedTarget := range resolvedTargets {
 
 			// check the map for matching sessions
-			sessions, ok := m.get(resolvedTarget)
+			sessions, ok := m.find(resolvedTarget)
 
 			// no sessions matching this target - move on
 			if !ok {
@@ -308,23 +886,20 @@ func (m *SessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 			}
 
 			targetFound = true
-
-			// iterate all matching sessions and adjust the volume of each one
-			for _, session := range sessions {
-				if session.GetVolume() != event.PercentValue {
-					if err := session.SetVolume(event.PercentValue); err != nil {
-						m.logger.Warnw("Failed to set target session volume", "error", err)
-						adjustmentFailed = true
-					}
-				}
-			}
+			matched = append(matched, sessions...)
 		}
 	}
 
+	return matched, targetFound
+}
+
+// refreshAfterAdjustment looks for sessions again when the last volume adjustment didn't go well
+func (m *SessionMap) refreshAfterAdjustment(targetFound bool, adjustmentFailed bool) {
+
 	// if we still haven't found a target or the volume adjustment failed, maybe look for the target again.
-	// processes could've opened since the last time this slider moved.
-	// if they haven't, the cooldown will take care to not spam it up
-	if !targetFound {
+	// processes could've opened since the last time this slider or encoder moved, unless the session
+	// finder would've told about them. if they haven't, the cooldown will take care to not spam it up
+	if !targetFound && !m.watched {
 		m.refreshSessions(false)
 	} else if adjustmentFailed {
 
@@ -376,6 +951,9 @@ func (m *SessionMap) applyTargetTransform(specialTargetName string) []string {
 
 	// get currently unmapped sessions
 	case specialTargetAllUnmapped:
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
 		targetKeys := make([]string, len(m.unmappedSessions))
 		for sessionIdx, session := range m.unmappedSessions {
 			targetKeys[sessionIdx] = session.Key()
@@ -390,7 +968,8 @@ func (m *SessionMap) applyTargetTransform(specialTargetName string) []string {
 	return nil
 }
 
-func (m *SessionMap) add(value Session) {
+// add puts the session in the map, unless it's there already. it returns whether it was added
+func (m *SessionMap) add(value Session) bool {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -399,9 +978,84 @@ func (m *SessionMap) add(value Session) {
 	existing, ok := m.m[key]
 	if !ok {
 		m.m[key] = []Session{value}
+	} else if slices.Contains(existing, value) {
+		return false
 	} else {
 		m.m[key] = append(existing, value)
 	}
+
+	m.deej.bus.sessions.publish(sessionEvent{Key: key})
+
+	return true
+}
+
+// remove takes the session out of the map and releases it, it returns whether it was there
+func (m *SessionMap) remove(value Session) bool {
+
+	// a ramp still in progress would keep touching the session released below
+	m.ramper.stop(value)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := value.Key()
+
+	existing := m.m[key]
+	idx := slices.Index(existing, value)
+	if idx < 0 {
+		return false
+	}
+
+	// sessions returned by get could still be in use, so they're left as they are
+	if len(existing) == 1 {
+		delete(m.m, key)
+	} else {
+		m.m[key] = slices.Delete(slices.Clone(existing), idx, idx+1)
+	}
+
+	value.Release()
+	m.deej.bus.sessions.publish(sessionEvent{Key: key, Removed: true})
+
+	return true
+}
+
+// addSession adds a session the session finder told about, noting whether it's unmapped
+func (m *SessionMap) addSession(session Session) {
+	if !m.add(session) {
+		return
+	}
+
+	m.logger.Debugw("Audio session added", "session", session)
+
+	if !m.sessionMapped(session) {
+		m.trackUnmapped(session)
+	}
+
+	m.applySliderValues([]Session{session})
+}
+
+// removeSession removes a session the session finder told is gone
+func (m *SessionMap) removeSession(session Session) {
+	if !m.remove(session) {
+		return
+	}
+
+	m.logger.Debugw("Audio session removed", "key", session.Key())
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.unmappedSessions = slices.DeleteFunc(slices.Clone(m.unmappedSessions), func(unmapped Session) bool {
+		return unmapped == session
+	})
+}
+
+// trackUnmapped notes a session targeted by deej.unmapped
+func (m *SessionMap) trackUnmapped(session Session) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.unmappedSessions = append(m.unmappedSessions, session)
 }
 
 func (m *SessionMap) get(key string) ([]Session, bool) {
@@ -412,7 +1066,93 @@ func (m *SessionMap) get(key string) ([]Session, bool) {
 	return value, ok
 }
 
+// find returns sessions matching a resolved target, looking them up by key unless the target
+// matches by a property. those are ordered by key, so the first one is always the same
+func (m *SessionMap) find(target string) ([]Session, bool) {
+	if _, _, _, ok := parsePropertyTarget(target); !ok {
+		return m.get(target)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	matched := []Session{}
+	for _, sessions := range m.m {
+		for _, session := range sessions {
+			if sessionMatches(session, target) {
+				matched = append(matched, session)
+			}
+		}
+	}
+
+	slices.SortStableFunc(matched, func(a, b Session) int {
+		return strings.Compare(a.Key(), b.Key())
+	})
+
+	return matched, len(matched) > 0
+}
+
+// parsePropertyTarget splits a target matching sessions by one of their properties, e.g. "role:music",
+// or "rec:name:discord" for recording streams
+func parsePropertyTarget(target string) (property string, value string, recording bool, ok bool) {
+	if strings.HasPrefix(target, recordingSessionPrefix) {
+		recording = true
+		target = strings.TrimPrefix(target, recordingSessionPrefix)
+	}
+
+	property, value, found := strings.Cut(target, ":")
+	if !found || value == "" || !slices.Contains(sessionProperties, property) {
+		return "", "", false, false
+	}
+
+	return property, value, recording, true
+}
+
+// sessionMatches tells if a resolved target picks the session, by its key or one of its properties
+func sessionMatches(session Session, target string) bool {
+	property, value, recording, ok := parsePropertyTarget(target)
+	if !ok {
+		return session.Key() == target
+	}
+
+	matcher, ok := session.(propertySession)
+
+	return ok && recordingSession(session.Key()) == recording && matcher.matchesProperty(property, value)
+}
+
+// keys returns the key of every session in the map
+func (m *SessionMap) keys() map[string]bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	keys := make(map[string]bool, len(m.m))
+	for key := range m.m {
+		keys[key] = true
+	}
+
+	return keys
+}
+
+// sessionsExcept returns sessions in the map whose keys aren't among the given ones
+func (m *SessionMap) sessionsExcept(keys map[string]bool) []Session {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sessions := []Session{}
+	for key, keySessions := range m.m {
+		if !keys[key] {
+			sessions = append(sessions, keySessions...)
+		}
+	}
+
+	return sessions
+}
+
 func (m *SessionMap) clear() {
+
+	// ramps still in progress would keep touching sessions released below
+	m.ramper.stopAll()
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -424,6 +1164,7 @@ func (m *SessionMap) clear() {
 		}
 
 		delete(m.m, key)
+		m.deej.bus.sessions.publish(sessionEvent{Key: key, Removed: true})
 	}
 
 	m.logger.Debug("Session map cleared")
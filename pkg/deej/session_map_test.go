@@ -0,0 +1,576 @@
+package deej
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// fakeSession records every volume it was set to, and how many times its mute changed
+type fakeSession struct {
+	key      string
+	isDevice bool
+
+	lock    sync.Mutex
+	volume  float32
+	mute    bool
+	history []float32
+	toggles int
+	failing error
+}
+
+func (s *fakeSession) GetVolume() float32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.volume
+}
+
+func (s *fakeSession) SetVolume(v float32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.failing != nil {
+		return s.failing
+	}
+
+	s.volume = v
+	s.history = append(s.history, v)
+
+	return nil
+}
+
+func (s *fakeSession) GetMute() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.mute
+}
+
+func (s *fakeSession) SetMute(m bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.mute = m
+	s.toggles++
+
+	return nil
+}
+
+func (s *fakeSession) Key() string  { return s.key }
+func (s *fakeSession) Release()     {}
+func (s *fakeSession) device() bool { return s.isDevice }
+
+func (s *fakeSession) volumes() []float32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]float32(nil), s.history...)
+}
+
+func (s *fakeSession) muteToggles() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.toggles
+}
+
+// fakeSessionFinder hands over its sessions, and the changes sent by the test when watched
+type fakeSessionFinder struct {
+	lock     sync.Mutex
+	sessions []Session
+	lookups  int
+
+	changes chan sessionChange
+}
+
+func (f *fakeSessionFinder) GetAllSessions() ([]Session, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.lookups++
+
+	return append([]Session(nil), f.sessions...), nil
+}
+
+func (f *fakeSessionFinder) Release() error { return nil }
+
+func (f *fakeSessionFinder) WatchSessions(ctx context.Context) (<-chan sessionChange, error) {
+	return f.changes, nil
+}
+
+func (f *fakeSessionFinder) lookupCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.lookups
+}
+
+func newTestDeej(t *testing.T, config *CanonicalConfig) *Deej {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return &Deej{config: config, ctx: ctx, bus: newEventBus(ctx, zap.NewNop().Sugar())}
+}
+
+func newTestSessionMap(t *testing.T, config *CanonicalConfig, sessions ...Session) *SessionMap {
+	m, err := newSessionMap(newTestDeej(t, config), zap.NewNop().Sugar(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, session := range sessions {
+		m.add(session)
+	}
+
+	return m
+}
+
+func TestSessionMap_handleMuteEvent(t *testing.T) {
+	master := &fakeSession{key: masterSessionName}
+	mic := &fakeSession{key: inputSessionName, mute: true}
+
+	m := newTestSessionMap(t, &CanonicalConfig{}, master, mic)
+
+	m.handleMuteEvent(true, "master")
+	m.handleMuteEvent(true, "MIC")
+
+	assert.True(t, master.GetMute())
+	assert.Equal(t, 1, master.toggles)
+	assert.True(t, mic.GetMute())
+	assert.Equal(t, 0, mic.toggles, "sessions already in the right state are left alone")
+}
+
+func TestSessionMap_muteStates(t *testing.T) {
+	master := &fakeSession{key: masterSessionName, mute: true}
+	mic := &fakeSession{key: inputSessionName}
+
+	m := newTestSessionMap(t, &CanonicalConfig{
+		MuteMapping: muteMapFromConfigs(map[string]interface{}{
+			"0": []string{"mic"},
+			"1": []string{"spotify.exe", "master"},
+			"3": []string{"discord.exe"},
+		}),
+	}, master, mic)
+
+	assert.Equal(t, []bool{false, true, false, false}, m.muteStates(""))
+
+	// external changes show up on the next read
+	mic.SetMute(true)
+	assert.Equal(t, []bool{true, true, false, false}, m.muteStates(""))
+}
+
+func TestSessionMap_MuteInOrder(t *testing.T) {
+	mic := &fakeSession{key: inputSessionName}
+
+	m := newTestSessionMap(t, &CanonicalConfig{
+		MuteMapping: muteMapFromConfigs(map[string]interface{}{
+			"0": map[string]interface{}{"targets": []string{"mic"}, "mode": muteModePushToTalk},
+		}),
+	}, mic)
+
+	// quick push-to-talk taps, every release has to land after its press
+	const taps = 50
+	for i := 0; i < taps; i++ {
+		m.Mute("", []bool{true})
+		m.Mute("", []bool{false})
+	}
+
+	// the first press finds the mic open already, every other event flips it
+	assert.Eventually(t, func() bool {
+		return mic.muteToggles() == 2*taps-1
+	}, time.Second, time.Millisecond)
+	assert.True(t, mic.GetMute(), "the mic is closed again after the last tap")
+}
+
+func TestSessionMap_handleSoloEvent(t *testing.T) {
+	master := &fakeSession{key: masterSessionName}
+	spotify := &fakeSession{key: "spotify.exe"}
+	chrome := &fakeSession{key: "chrome.exe"}
+	discord := &fakeSession{key: "discord.exe", mute: true}
+	headset := &fakeSession{key: "usb headset analog stereo", isDevice: true}
+	discordMic := &fakeSession{key: "rec:discord"}
+
+	m := newTestSessionMap(t, &CanonicalConfig{}, master, spotify, chrome, discord, headset, discordMic)
+
+	m.handleSoloEvent("", 1, true, []string{"Spotify.exe"})
+
+	assert.False(t, master.GetMute(), "device sessions are left alone")
+	assert.False(t, headset.GetMute(), "even when their names don't look like devices")
+	assert.False(t, discordMic.GetMute(), "recording streams are left alone")
+	assert.False(t, spotify.GetMute())
+	assert.True(t, chrome.GetMute())
+	assert.True(t, discord.GetMute())
+
+	m.handleSoloEvent("", 1, false, nil)
+
+	assert.False(t, chrome.GetMute())
+	assert.True(t, discord.GetMute(), "sessions muted before solo stay muted")
+	assert.Equal(t, 0, discord.toggles)
+}
+
+func TestSessionMap_Turn(t *testing.T) {
+	master := &fakeSession{key: masterSessionName, volume: 0.5}
+	spotify := &fakeSession{key: "spotify.exe", volume: 0.99}
+	discord := &fakeSession{key: "discord.exe", volume: 0.3}
+
+	m := newTestSessionMap(t, &CanonicalConfig{
+		EncoderMapping: sliderMapFromConfigs(map[string][]string{
+			"0": {"master"},
+			"1": {"spotify.exe", "Spotify.exe"},
+		}, nil),
+		Encoders: &encoderSettings{step: 0.02},
+	}, master, spotify, discord)
+	m.lastSessionRefresh = time.Now()
+
+	m.Turn("", []int{+3, +1, 0})
+	m.Turn("", []int{-1, 0, 5})
+
+	assert.InDeltaSlice(t, []float32{0.56, 0.54}, master.volumes(), 1e-6)
+	assert.Equal(t, []float32{1}, spotify.volumes(), "volume stops at the top, turned once despite two matching targets")
+	assert.Empty(t, discord.volumes(), "unmapped encoders do nothing")
+}
+
+func TestSessionMap_TurnOfSeveralDevices(t *testing.T) {
+	spotify := &fakeSession{key: "spotify.exe", volume: 0.5}
+	discord := &fakeSession{key: "discord.exe", volume: 0.5}
+
+	m := newTestSessionMap(t, &CanonicalConfig{
+		Devices: []*deviceProfile{
+			{Name: "desk", EncoderMapping: sliderMapFromConfigs(map[string][]string{"0": {"spotify.exe"}}, nil)},
+			{Name: "pedals", EncoderMapping: sliderMapFromConfigs(map[string][]string{"0": {"discord.exe"}}, nil)},
+		},
+		Encoders: &encoderSettings{step: 0.1},
+	}, spotify, discord)
+	m.lastSessionRefresh = time.Now()
+
+	m.Turn("desk", []int{+1})
+	m.Turn("pedals", []int{-2})
+	m.Turn("keyboard", []int{+1})
+
+	assert.InDeltaSlice(t, []float32{0.6}, spotify.volumes(), 1e-6)
+	assert.InDeltaSlice(t, []float32{0.3}, discord.volumes(), 1e-6, "same index of another device has its own mapping")
+}
+
+func TestSessionMap_watchedSessions(t *testing.T) {
+	master := &fakeSession{key: masterSessionName, volume: 0.5}
+	spotify := &fakeSession{key: "spotify.exe", volume: 0.5}
+	finder := &fakeSessionFinder{sessions: []Session{master}, changes: make(chan sessionChange)}
+
+	d := newTestDeej(t, &CanonicalConfig{
+		SliderMapping:  sliderMapFromConfigs(map[string][]string{"0": {"spotify.exe"}}, nil),
+		EncoderMapping: sliderMapFromConfigs(nil, nil),
+	})
+
+	m, err := newSessionMap(d, zap.NewNop().Sugar(), finder)
+	assert.NoError(t, err)
+
+	sessionEvents := d.bus.sessions.subscribe(d.ctx, subscribeOptions[sessionEvent]{})
+	assert.NoError(t, m.initialize())
+	assert.Equal(t, sessionEvent{Key: masterSessionName}, <-sessionEvents.events)
+
+	// a slider with nothing to control doesn't go looking for it, the finder would've told about it
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 0, PercentValue: 0.2})
+	assert.Equal(t, 1, finder.lookupCount())
+
+	// the second one is only taken once the first is handled, and does nothing
+	finder.changes <- sessionChange{Session: spotify}
+	finder.changes <- sessionChange{Session: spotify}
+	assert.Equal(t, sessionEvent{Key: "spotify.exe"}, <-sessionEvents.events)
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 0, PercentValue: 0.3})
+	assert.Equal(t, []float32{0.2, 0.3}, spotify.volumes(), "the new session got the slider's value first")
+
+	finder.changes <- sessionChange{Session: spotify, Removed: true}
+	assert.Equal(t, sessionEvent{Key: "spotify.exe", Removed: true}, <-sessionEvents.events)
+
+	_, ok := m.get("spotify.exe")
+	assert.False(t, ok)
+
+	sessions, _ := m.get(masterSessionName)
+	assert.Equal(t, []Session{master}, sessions, "other sessions stay where they were")
+	assert.Equal(t, 1, finder.lookupCount(), "sessions were never looked for again")
+}
+
+func TestSessionMap_applySliderValues(t *testing.T) {
+	type testCase struct {
+		givenMoves     []SliderMoveEvent
+		givenSession   *fakeSession
+		expectedVolume []float32
+	}
+
+	testCases := map[string]testCase{
+		"slider moved before app started": {
+			givenMoves:     []SliderMoveEvent{{SliderID: 0, PercentValue: 0.2}, {SliderID: 0, PercentValue: 0.3}},
+			givenSession:   &fakeSession{key: "spotify.exe", volume: 1},
+			expectedVolume: []float32{0.3},
+		},
+		"slider never moved": {
+			givenSession:   &fakeSession{key: "spotify.exe", volume: 1},
+			expectedVolume: []float32{},
+		},
+		"already there": {
+			givenMoves:     []SliderMoveEvent{{SliderID: 0, PercentValue: 0.3}},
+			givenSession:   &fakeSession{key: "spotify.exe", volume: 0.3},
+			expectedVolume: []float32{},
+		},
+		"another slider moved": {
+			givenMoves:     []SliderMoveEvent{{SliderID: 1, PercentValue: 0.3}},
+			givenSession:   &fakeSession{key: "spotify.exe", volume: 1},
+			expectedVolume: []float32{},
+		},
+		"slider of another mixer moved": {
+			givenMoves:     []SliderMoveEvent{{Device: "pedals", SliderID: 0, PercentValue: 0.3}},
+			givenSession:   &fakeSession{key: "spotify.exe", volume: 1},
+			expectedVolume: []float32{},
+		},
+		"unmapped app": {
+			givenMoves:     []SliderMoveEvent{{SliderID: 0, PercentValue: 0.3}},
+			givenSession:   &fakeSession{key: "discord.exe", volume: 1},
+			expectedVolume: []float32{},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			master := &fakeSession{key: masterSessionName, volume: 0.5}
+			finder := &fakeSessionFinder{sessions: []Session{master}}
+
+			d := newTestDeej(t, &CanonicalConfig{
+				SliderMapping:  sliderMapFromConfigs(map[string][]string{"0": {"Spotify.exe"}, "1": {"master"}}, nil),
+				EncoderMapping: sliderMapFromConfigs(nil, nil),
+			})
+
+			m, err := newSessionMap(d, zap.NewNop().Sugar(), finder)
+			assert.NoError(t, err)
+			assert.NoError(t, m.getAndAddSessions())
+
+			for _, move := range testCase.givenMoves {
+				m.handleSliderMoveEvent(move)
+			}
+			masterVolumes := master.volumes()
+
+			// the app starts, and shows up with the next refresh
+			finder.lock.Lock()
+			finder.sessions = append(finder.sessions, testCase.givenSession)
+			finder.lock.Unlock()
+
+			m.refreshSessions(true)
+
+			assert.Equal(t, testCase.expectedVolume, append([]float32{}, testCase.givenSession.volumes()...))
+			assert.Equal(t, masterVolumes, master.volumes(), "sessions found before are left alone")
+		})
+	}
+}
+
+func TestSessionMap_applySliderValuesToWatchedSessions(t *testing.T) {
+	spotify := &fakeSession{key: "spotify.exe", volume: 1}
+	finder := &fakeSessionFinder{changes: make(chan sessionChange)}
+
+	d := newTestDeej(t, &CanonicalConfig{
+		SliderMapping:  sliderMapFromConfigs(map[string][]string{"0": {"deej.unmapped"}}, nil),
+		EncoderMapping: sliderMapFromConfigs(nil, nil),
+	})
+
+	m, err := newSessionMap(d, zap.NewNop().Sugar(), finder)
+	assert.NoError(t, err)
+	assert.NoError(t, m.initialize())
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 0, PercentValue: 0.4})
+
+	// the app starts playing, and gets the slider's value right away
+	finder.changes <- sessionChange{Session: spotify}
+
+	assert.Eventually(t, func() bool {
+		return len(spotify.volumes()) > 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []float32{0.4}, spotify.volumes())
+}
+
+func TestSessionMap_watchedSessionsWhileSlidersMove(t *testing.T) {
+	finder := &fakeSessionFinder{changes: make(chan sessionChange)}
+
+	d := newTestDeej(t, &CanonicalConfig{
+		SliderMapping:  sliderMapFromConfigs(map[string][]string{"0": {"deej.unmapped"}}, nil),
+		EncoderMapping: sliderMapFromConfigs(nil, nil),
+	})
+
+	m, err := newSessionMap(d, zap.NewNop().Sugar(), finder)
+	assert.NoError(t, err)
+	assert.NoError(t, m.initialize())
+
+	// apps come and go while the slider controlling them moves, run with -race to be sure
+	moved := make(chan struct{})
+	go func() {
+		defer close(moved)
+
+		for i := 0; i < 200; i++ {
+			m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 0, PercentValue: float32(i%100) / 100})
+		}
+	}()
+
+	apps := []*fakeSession{{key: "spotify.exe"}, {key: "discord.exe"}, {key: "chrome.exe"}}
+	for i := 0; i < 50; i++ {
+		for _, app := range apps {
+			finder.changes <- sessionChange{Session: app, Removed: i%2 == 1}
+		}
+	}
+
+	<-moved
+
+	// the last round removed them all
+	assert.Eventually(t, func() bool {
+		return len(m.applyTargetTransform(specialTargetAllUnmapped)) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// fakePropertySession can be matched by the properties it was given
+type fakePropertySession struct {
+	*fakeSession
+	properties map[string]string
+}
+
+func (s *fakePropertySession) matchesProperty(property string, value string) bool {
+	return strings.EqualFold(s.properties[property], value)
+}
+
+func TestSessionMap_propertyTargets(t *testing.T) {
+	type testCase struct {
+		givenTargets []string
+		expectedKeys []string
+	}
+
+	firefox := &fakePropertySession{
+		fakeSession: &fakeSession{key: "firefox-bin"},
+		properties:  map[string]string{"name": "Firefox", "role": "video"},
+	}
+	spotify := &fakePropertySession{
+		fakeSession: &fakeSession{key: "spotify"},
+		properties:  map[string]string{"name": "Spotify", "role": "music"},
+	}
+	discord := &fakePropertySession{
+		fakeSession: &fakeSession{key: "com.discordapp.discord"},
+		properties:  map[string]string{"name": "Discord", "flatpak": "com.discordapp.Discord"},
+	}
+	discordMic := &fakePropertySession{
+		fakeSession: &fakeSession{key: "rec:com.discordapp.discord"},
+		properties:  map[string]string{"name": "Discord"},
+	}
+
+	testCases := map[string]testCase{
+		"app name": {
+			givenTargets: []string{"name:FIREFOX"},
+			expectedKeys: []string{"firefox-bin"},
+		},
+		"role": {
+			givenTargets: []string{"role:music"},
+			expectedKeys: []string{"spotify"},
+		},
+		"playback only": {
+			givenTargets: []string{"name:discord"},
+			expectedKeys: []string{"com.discordapp.discord"},
+		},
+		"recording only": {
+			givenTargets: []string{"rec:name:discord"},
+			expectedKeys: []string{"rec:com.discordapp.discord"},
+		},
+		"flatpak app id": {
+			givenTargets: []string{"flatpak:com.discordapp.Discord"},
+			expectedKeys: []string{"com.discordapp.discord"},
+		},
+		"mixed with process names": {
+			givenTargets: []string{"spotify", "role:video"},
+			expectedKeys: []string{"spotify", "firefox-bin"},
+		},
+		"unknown property is a process name": {
+			givenTargets: []string{"pid:1234"},
+			expectedKeys: []string{},
+		},
+		"no value": {
+			givenTargets: []string{"role:"},
+			expectedKeys: []string{},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			m := newTestSessionMap(t, &CanonicalConfig{}, firefox, spotify, discord, discordMic)
+
+			sessions, _ := m.targetSessions(testCase.givenTargets)
+
+			keys := []string{}
+			for _, session := range sessions {
+				keys = append(keys, session.Key())
+			}
+
+			assert.Equal(t, testCase.expectedKeys, keys)
+		})
+	}
+}
+
+func TestSessionMap_recordingTargets(t *testing.T) {
+	type testCase struct {
+		givenTarget      string
+		expectedPlayback bool
+		expectedRecorded bool
+	}
+
+	testCases := map[string]testCase{
+		"process name": {
+			givenTarget:      "discord",
+			expectedPlayback: true,
+		},
+		"recording stream": {
+			givenTarget:      "rec:Discord",
+			expectedRecorded: true,
+		},
+		"recording stream by property": {
+			givenTarget:      "rec:name:discord",
+			expectedRecorded: true,
+		},
+		"playback stream by property": {
+			givenTarget:      "name:discord",
+			expectedPlayback: true,
+		},
+		"unmapped apps leave recording streams alone": {
+			givenTarget:      "deej.unmapped",
+			expectedPlayback: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			playback := &fakePropertySession{
+				fakeSession: &fakeSession{key: "discord", volume: 1},
+				properties:  map[string]string{"name": "Discord"},
+			}
+			recorded := &fakePropertySession{
+				fakeSession: &fakeSession{key: "rec:discord", volume: 1},
+				properties:  map[string]string{"name": "Discord"},
+			}
+			finder := &fakeSessionFinder{sessions: []Session{playback, recorded}}
+
+			d := newTestDeej(t, &CanonicalConfig{
+				SliderMapping:  sliderMapFromConfigs(map[string][]string{"0": {testCase.givenTarget}}, nil),
+				EncoderMapping: sliderMapFromConfigs(nil, nil),
+			})
+
+			m, err := newSessionMap(d, zap.NewNop().Sugar(), finder)
+			assert.NoError(t, err)
+			assert.NoError(t, m.getAndAddSessions())
+
+			m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 0, PercentValue: 0.3})
+			m.handleMuteEvent(true, testCase.givenTarget)
+
+			assert.Equal(t, testCase.expectedPlayback, len(playback.volumes()) > 0, "playback stream volume")
+			assert.Equal(t, testCase.expectedPlayback, playback.GetMute(), "playback stream mute")
+			assert.Equal(t, testCase.expectedRecorded, len(recorded.volumes()) > 0, "recording stream volume")
+			assert.Equal(t, testCase.expectedRecorded, recorded.GetMute(), "recording stream mute")
+		})
+	}
+}
@@ -152,6 +152,18 @@ func (s *wcaSession) SetVolume(v float32) error {
 	return nil
 }
 
+// GetMute reads the real mute state, it could have been changed from outside of deej
+func (s *wcaSession) GetMute() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.volume.GetMute(&s.isMuted); err != nil {
+		s.logger.Warnw("Failed to get session mute", "error", err)
+	}
+
+	return s.isMuted
+}
+
 func (s *wcaSession) SetMute(m bool) error {
 	s.Lock()
 	defer s.Unlock()
@@ -223,6 +235,18 @@ func (s *masterSession) SetVolume(v float32) error {
 	return nil
 }
 
+// GetMute reads the real mute state, it could have been changed from outside of deej
+func (s *masterSession) GetMute() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.volume.GetMute(&s.isMuted); err != nil {
+		s.logger.Warnw("Failed to get session mute", "error", err)
+	}
+
+	return s.isMuted
+}
+
 func (s *masterSession) SetMute(m bool) error {
 	s.Lock()
 	defer s.Unlock()
@@ -32,6 +32,18 @@ func (d *Deej) initializeTray(onDone func()) {
 		systray.AddSeparator()
 		quit := systray.AddMenuItem("Quit", "Stop deej and quit")
 
+		// keep the tooltip telling how the mixers are doing, apart from the menu loop
+		// below as opening the config window blocks it
+		// only the latest state of each mixer matters, the tooltip tells about all of them at once
+		mixerStates := d.bus.mixerStates.subscribe(d.ctx, subscribeOptions[MixerState]{
+			coalesce: func(state MixerState) any { return state.Device },
+		})
+		go func() {
+			for range mixerStates.events {
+				systray.SetTooltip("deej: " + d.MixerStatus())
+			}
+		}()
+
 		// wait on things to happen
 		go func() {
 			for {
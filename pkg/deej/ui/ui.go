@@ -17,6 +17,18 @@ type DevicePortSetter interface {
 	DevicePortSet(deviceName string)
 }
 
+// DeviceInfoProvider can be implemented by DevicePortSetter
+// to show what the connected mixer is
+type DeviceInfoProvider interface {
+	DeviceInfo() (device.DeviceInfo, bool)
+}
+
+// PortDetector can be implemented by DevicePortSetter to detect
+// mixers with its own serial options instead of the defaults
+type PortDetector interface {
+	DetectPorts() ([]string, error)
+}
+
 type ProgramLister interface {
 	ProgramList() ([]string, error)
 }
@@ -63,6 +75,11 @@ func ShowUI(
 	configWindow.SetInnerY(46)
 	configWindow.SetInnerSize(315, 340)
 	configWindow.SetTitle("MD Configurator")
+	if infoProvider, ok := devicePortSetter.(DeviceInfoProvider); ok {
+		if info, connected := infoProvider.DeviceInfo(); connected && !info.Legacy {
+			configWindow.SetTitle(fmt.Sprintf("MD Configurator (fw %s)", info.Firmware))
+		}
+	}
 	configWindow.SetIcon(mainIcon)
 	configWindow.SetHasMaxButton(false)
 	configWindow.SetResizable(false)
@@ -179,7 +196,7 @@ func ShowUI(
 
 	addLabel(configWindow, 15, 10, 83, 27, "Chose port:")
 	isConnectedLabel := addLabel(configWindow, 207, 10, 90, 10, "Click detect port")
-	detectPorts(comPortBox, isConnectedLabel, configWindow, &detectedDeviceName)
+	detectPorts(devicePortSetter, comPortBox, isConnectedLabel, configWindow, &detectedDeviceName)
 
 	applyBtn := wui.NewButton()
 	applyBtn.SetBounds(114, 310, 85, 25)
@@ -223,7 +240,7 @@ func ShowUI(
 	})
 
 	detectButton.SetOnClick(func() {
-		detectPorts(comPortBox, isConnectedLabel, configWindow, &detectedDeviceName)
+		detectPorts(devicePortSetter, comPortBox, isConnectedLabel, configWindow, &detectedDeviceName)
 	})
 
 	configWindow.SetPosition(
@@ -235,10 +252,21 @@ func ShowUI(
 	configWindow.Repaint()
 }
 
-func detectPorts(comPortBox *wui.ComboBox, isConnectedLabel *wui.Label, configWindow *wui.Window, detectedDeviceName *string) {
+func detectPorts(
+	devicePortSetter DevicePortSetter,
+	comPortBox *wui.ComboBox,
+	isConnectedLabel *wui.Label,
+	configWindow *wui.Window,
+	detectedDeviceName *string,
+) {
 	isConnectedLabel.SetText("Detecting...")
 
-	detectedDevices, err := device.ListNames()
+	listNames := device.ListNames
+	if portDetector, ok := devicePortSetter.(PortDetector); ok {
+		listNames = portDetector.DetectPorts
+	}
+
+	detectedDevices, err := listNames()
 	if err != nil {
 		log.Println("Can't get devices list", err)
 		return
@@ -7,6 +7,8 @@ import (
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"go.uber.org/zap"
@@ -52,6 +54,46 @@ func GetCurrentWindowProcessNames() ([]string, error) {
 	return getCurrentWindowProcessNames()
 }
 
+// MediaKey is a key of the media controls, as found on multimedia keyboards
+type MediaKey int
+
+// media keys that SendMediaKey can press
+const (
+	MediaPlayPause MediaKey = iota
+	MediaNext
+	MediaPrevious
+)
+
+// SendMediaKey presses a media key, controlling whichever media player is currently active.
+// On Linux, this goes through MPRIS, so the player has to support it
+func SendMediaKey(key MediaKey) error {
+	return sendMediaKey(key)
+}
+
+// StartCommand runs a command with the given arguments without waiting for it to finish.
+// No shell is involved, so arguments reach the command as they are
+func StartCommand(logger *zap.SugaredLogger, cmd string, args ...string) error {
+	command := exec.Command(cmd, args...)
+
+	if err := command.Start(); err != nil {
+		logger.Warnw("Failed to start command",
+			"command", cmd,
+			"arguments", args,
+			"error", err)
+
+		return fmt.Errorf("start command: %w", err)
+	}
+
+	// reap it once it's done, so it doesn't linger as a zombie
+	go func() {
+		if err := command.Wait(); err != nil {
+			logger.Debugw("Command failed", "command", cmd, "error", err)
+		}
+	}()
+
+	return nil
+}
+
 // OpenExternal spawns a detached window with the provided command and argument
 func OpenExternal(logger *zap.SugaredLogger, cmd string, arg string) error {
 
@@ -81,33 +123,45 @@ func NormalizeScalar(v float32) float32 {
 	return float32(math.Floor(float64(v)*100) / 100.0)
 }
 
-// SignificantlyDifferent returns true if there's a significant enough volume difference between two given values
-func SignificantlyDifferent(old float32, new float32, noiseReductionLevel string) bool {
+// noise reduction levels accepted in place of a numeric threshold
+const (
+	NoiseReductionLow     = "low"
+	NoiseReductionDefault = "default"
+	NoiseReductionHigh    = "high"
+)
 
-	const (
-		noiseReductionHigh = "high"
-		noiseReductionLow  = "low"
-	)
+// NoiseReductionThreshold turns a noise reduction level ("low", "default", "high") or a number
+// (e.g. "0.02") into the threshold used by SignificantlyDifferent. an empty level means "default"
+func NoiseReductionThreshold(noiseReductionLevel string) (float64, error) {
 
 	// this threshold is solely responsible for dealing with hardware interference when
 	// sliders are producing noisy values. this value should be a median value between two
 	// round percent values. for instance, 0.025 means volume can move at 3% increments
-	var significantDifferenceThreshold float64
-
-	// choose our noise reduction level based on the config-provided value
-	switch noiseReductionLevel {
-	case noiseReductionHigh:
-		significantDifferenceThreshold = 0.035
-		break
-	case noiseReductionLow:
-		significantDifferenceThreshold = 0.015
-		break
-	default:
-		significantDifferenceThreshold = 0.025
-		break
+	switch strings.ToLower(strings.TrimSpace(noiseReductionLevel)) {
+	case NoiseReductionHigh:
+		return 0.035, nil
+	case NoiseReductionLow:
+		return 0.015, nil
+	case NoiseReductionDefault, "":
+		return 0.025, nil
+	}
+
+	threshold, err := strconv.ParseFloat(noiseReductionLevel, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid noise reduction level %q", noiseReductionLevel)
+	}
+
+	// anything past half of the travel would make sliders useless
+	if threshold < 0 || threshold > 0.5 {
+		return 0, fmt.Errorf("noise reduction threshold %.3f out of range 0 to 0.5", threshold)
 	}
 
-	if math.Abs(float64(old-new)) >= significantDifferenceThreshold {
+	return threshold, nil
+}
+
+// SignificantlyDifferent returns true if there's a significant enough volume difference between two given values
+func SignificantlyDifferent(old float32, new float32, threshold float64) bool {
+	if math.Abs(float64(old-new)) >= threshold {
 		return true
 	}
 
@@ -2,8 +2,77 @@ package util
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	mprisPrefix     = "org.mpris.MediaPlayer2."
+	mprisObjectPath = "/org/mpris/MediaPlayer2"
+	mprisPlayer     = "org.mpris.MediaPlayer2.Player"
 )
 
+var mprisMethods = map[MediaKey]string{
+	MediaPlayPause: mprisPlayer + ".PlayPause",
+	MediaNext:      mprisPlayer + ".Next",
+	MediaPrevious:  mprisPlayer + ".Previous",
+}
+
 func getCurrentWindowProcessNames() ([]string, error) {
 	return nil, errors.New("Not implemented")
 }
+
+// media keys aren't a thing on Linux desktops, players are controlled over D-Bus instead
+func sendMediaKey(key MediaKey) error {
+	method, ok := mprisMethods[key]
+	if !ok {
+		return fmt.Errorf("unknown media key %d", key)
+	}
+
+	// the session bus connection is shared, so it shouldn't be closed
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return fmt.Errorf("connect to session bus: %w", err)
+	}
+
+	player, err := activeMediaPlayer(conn)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Object(player, mprisObjectPath).Call(method, 0).Err; err != nil {
+		return fmt.Errorf("call %s on %s: %w", method, player, err)
+	}
+
+	return nil
+}
+
+// activeMediaPlayer picks the player that's playing right now, or the first one found
+func activeMediaPlayer(conn *dbus.Conn) (string, error) {
+	var names []string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names); err != nil {
+		return "", fmt.Errorf("list bus names: %w", err)
+	}
+
+	players := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, mprisPrefix) {
+			players = append(players, name)
+		}
+	}
+
+	if len(players) == 0 {
+		return "", errors.New("no media player found")
+	}
+
+	for _, player := range players {
+		status, err := conn.Object(player, mprisObjectPath).GetProperty(mprisPlayer + ".PlaybackStatus")
+		if err == nil && status.Value() == "Playing" {
+			return player, nil
+		}
+	}
+
+	return players[0], nil
+}
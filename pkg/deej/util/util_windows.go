@@ -94,3 +94,29 @@ func getCurrentWindowProcessNames() ([]string, error) {
 	lastGetCurrentWindowResult = result
 	return result, nil
 }
+
+var mediaKeyCodes = map[MediaKey]uint16{
+	MediaPlayPause: win.VK_MEDIA_PLAY_PAUSE,
+	MediaNext:      win.VK_MEDIA_NEXT_TRACK,
+	MediaPrevious:  win.VK_MEDIA_PREV_TRACK,
+}
+
+func sendMediaKey(key MediaKey) error {
+	keyCode, ok := mediaKeyCodes[key]
+	if !ok {
+		return fmt.Errorf("unknown media key %d", key)
+	}
+
+	// a key press is the key going down, then up again
+	inputs := []win.KEYBD_INPUT{
+		{Type: win.INPUT_KEYBOARD, Ki: win.KEYBDINPUT{WVk: keyCode}},
+		{Type: win.INPUT_KEYBOARD, Ki: win.KEYBDINPUT{WVk: keyCode, DwFlags: win.KEYEVENTF_KEYUP}},
+	}
+
+	sent := win.SendInput(uint32(len(inputs)), unsafe.Pointer(&inputs[0]), int32(unsafe.Sizeof(inputs[0])))
+	if sent != uint32(len(inputs)) {
+		return fmt.Errorf("send media key %d: only %d of %d inputs sent", key, sent, len(inputs))
+	}
+
+	return nil
+}
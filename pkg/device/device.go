@@ -5,19 +5,79 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"go.bug.st/serial"
 )
 
-var ErrConnectionTimeout = errors.New("line read timeouted")
+var (
+	ErrConnectionTimeout = errors.New("line read timeouted")
+	ErrNotConnected      = errors.New("device not connected")
+	ErrDeviceNotFound    = errors.New("device not found")
+)
+
+// ports dispatched right now, they're busy so detection can't probe them
+var connectedPorts = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+func setPortConnected(portName string, connected bool) {
+	connectedPorts.Lock()
+	defer connectedPorts.Unlock()
 
-var lastPortName string
+	if connected {
+		connectedPorts.names[portName] = true
+	} else {
+		delete(connectedPorts.names, portName)
+	}
+}
+
+// ConnectedPorts returns names of ports with a device dispatched right now.
+func ConnectedPorts() []string {
+	connectedPorts.Lock()
+	defer connectedPorts.Unlock()
+
+	names := make([]string, 0, len(connectedPorts.names))
+	for name := range connectedPorts.names {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// TraceLines makes every line read from a device printed to stdout.
+// Interactive tools turn it off to keep their own output readable.
+var TraceLines = true
+
+func traceLine(line string) {
+	if TraceLines {
+		fmt.Printf("Read %q\n", line)
+	}
+}
 
 type Connection struct {
 	portNameChannel chan string
+	retryChannel    chan struct{}
+
+	// writer side of the currently dispatched port and what it told us
+	// about itself, both unset when disconnected
+	lock       sync.Mutex
+	writer     io.Writer
+	deviceInfo *DeviceInfo
+
+	// used for serial transports, zero value means DefaultSerialOptions
+	serialOptions *SerialOptions
+
+	// where the connection is at, and whether the last attempt got as far as reading a line
+	state     State
+	connected bool
 }
 
 type VolumeConsumer interface {
@@ -25,28 +85,55 @@ type VolumeConsumer interface {
 	OnMute([]bool)
 }
 
-// TODO connection busy
+// EncoderConsumer can be implemented by a VolumeConsumer
+// to receive detents turned by rotary encoders.
+type EncoderConsumer interface {
+	OnEncoder([]int)
+}
+
+// ConnectAndDispatch opens the port and dispatches lines read from it, see DispatchTransport.
+// Ports held by another program fail with ErrPortBusy, ports that aren't there with ErrPortMissing.
 func (ConnectAD *Connection) ConnectAndDispatch(
 	ctx context.Context,
 	portName string,
 	volumeConsumer VolumeConsumer,
 ) error {
 	log.Println("Connecting to:", portName)
-	defer func() {
-		lastPortName = ""
-	}()
+	ConnectAD.setState(StateChange{Port: portName, State: StateProbing}, volumeConsumer)
 
-	port, err := serial.Open(portName, &serial.Mode{
-		BaudRate: 9600,
-	})
+	port, err := OpenTransport(ctx, portName, ConnectAD.SerialOptions())
 	if err != nil {
+		ConnectAD.setState(StateChange{Port: portName, State: StateDisconnected, Err: err}, volumeConsumer)
 		return err
 	}
 	defer port.Close()
 
-	if ConnectAD.portNameChannel == nil {
-		ConnectAD.portNameChannel = make(chan string, 1)
+	return ConnectAD.DispatchTransport(ctx, portName, port, volumeConsumer)
+}
+
+// DispatchTransport reads lines from already opened transport until it fails or context ends.
+// Transport stays available for Send calls for the whole time, but isn't closed on return.
+// A device that stops talking fails with ErrConnectionTimeout, one that goes away with ErrPortUnplugged.
+func (ConnectAD *Connection) DispatchTransport(
+	ctx context.Context,
+	portName string,
+	port Transport,
+	volumeConsumer VolumeConsumer,
+) (err error) {
+	portNameChannel := ConnectAD.portNames()
+
+	ConnectAD.setWriter(port)
+	defer ConnectAD.setWriter(nil)
+	defer setPortConnected(portName, false)
+	defer func() {
+		ConnectAD.setState(StateChange{Port: portName, State: StateDisconnected, Err: err}, volumeConsumer)
+	}()
+
+	// firmware without handshake support simply ignores this
+	if err := ConnectAD.Send(HelloCommand()); err != nil {
+		log.Println("Cannot send hello:", err)
 	}
+	var deviceHandshake handshake
 
 	timerTimeout := time.Second * 5
 	timerHit := false
@@ -54,6 +141,13 @@ func (ConnectAD *Connection) ConnectAndDispatch(
 		timerHit = true
 		port.Close()
 	})
+	defer timer.Stop()
+
+	// a quiet device is only noted as stalled at first, it's dropped once the timer above hits
+	stallTimer := time.AfterFunc(stallTimeout, func() {
+		ConnectAD.stall(portName, volumeConsumer)
+	})
+	defer stallTimer.Stop()
 
 	reader := bufio.NewReader(port)
 	for {
@@ -61,7 +155,7 @@ func (ConnectAD *Connection) ConnectAndDispatch(
 		case <-ctx.Done():
 			return ctx.Err()
 
-		case newPortName := <-ConnectAD.portNameChannel:
+		case newPortName := <-portNameChannel:
 			log.Println("Changing port to:", newPortName)
 			port.Close()
 			return ConnectAD.ConnectAndDispatch(ctx, newPortName, volumeConsumer)
@@ -73,136 +167,187 @@ func (ConnectAD *Connection) ConnectAndDispatch(
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if timerHit {
-				err = ErrConnectionTimeout
+				return ErrConnectionTimeout
 			}
-			// maybe add ErrConnectionClose na wypadek "device unplugged"?
-			return err
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("%w: %w", ErrPortUnplugged, err)
 		}
 		timer.Reset(timerTimeout)
-		lastPortName = portName
+		stallTimer.Reset(stallTimeout)
+		setPortConnected(portName, true)
+		ConnectAD.setConnected(true)
+		ConnectAD.setState(StateChange{Port: portName, State: StateConnected}, volumeConsumer)
+
+		line = strings.TrimSuffix(line, lineTerminator)
+		traceLine(line)
+
+		if info, settled := deviceHandshake.observe(line); settled {
+			info.Port = portName
+			ConnectAD.setDeviceInfo(info, volumeConsumer)
+		}
+		if hasKeyword(line, keywordHello) {
+			continue
+		}
 
-		line = strings.TrimSuffix(line, "\r\n")
-		fmt.Printf("Read %q\n", line)
 		parseAndDispatch(line, volumeConsumer)
 	}
 }
 
-// zrób metodę na wskaźniku Connection o następującej geometri DevicePortSet(deviceName string)
-func (ConnectAD *Connection) DevicePortSet(deviceName string) {
+// Send writes a single command frame to the connected device.
+// It returns ErrNotConnected when no port is being dispatched.
+func (ConnectAD *Connection) Send(command Command) error {
 	if ConnectAD == nil {
-		return
+		return ErrNotConnected
 	}
 
-	ConnectAD.portNameChannel <- deviceName
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
+
+	if ConnectAD.writer == nil {
+		return ErrNotConnected
+	}
+
+	if _, err := ConnectAD.writer.Write(command.encode()); err != nil {
+		return fmt.Errorf("write %q command: %w", command.Keyword, err)
+	}
+
+	return nil
 }
 
-func OpenAndDispatch(ctx context.Context, portName string /*consumer VolumeConsumer*/) error {
-	port, err := serial.Open(portName, &serial.Mode{
-		BaudRate: 9600,
-	})
-	if err != nil {
-		return err
+// DeviceInfo returns what the connected device told about itself during handshake.
+// It returns false when not connected or the handshake hasn't settled yet.
+func (ConnectAD *Connection) DeviceInfo() (DeviceInfo, bool) {
+	if ConnectAD == nil {
+		return DeviceInfo{}, false
 	}
-	defer port.Close()
 
-	reader := bufio.NewReader(port)
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			// intentionaly empty
-		}
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
 
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return err
-		}
+	if ConnectAD.deviceInfo == nil {
+		return DeviceInfo{}, false
+	}
 
-		line = strings.TrimSuffix(line, "\r\n")
-		fmt.Printf("Read %q\n", line)
+	return *ConnectAD.deviceInfo, true
+}
+
+func (ConnectAD *Connection) setWriter(writer io.Writer) {
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
+
+	ConnectAD.writer = writer
+	if writer == nil {
+		ConnectAD.deviceInfo = nil
 	}
 }
 
-func ListAllNames() ([]string, error) {
-	return serial.GetPortsList()
+// setConnected notes whether the current attempt got any line from the device.
+func (ConnectAD *Connection) setConnected(connected bool) {
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
+
+	ConnectAD.connected = connected
 }
 
-func ListNames() ([]string, error) {
-	portList, err := ListAllNames()
+// everConnected tells if the last attempt got any line from the device.
+func (ConnectAD *Connection) everConnected() bool {
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
 
-	if err != nil {
-		return nil, fmt.Errorf("can't get port list: %w", err)
-	}
+	return ConnectAD.connected
+}
+
+func (ConnectAD *Connection) setDeviceInfo(info DeviceInfo, volumeConsumer VolumeConsumer) {
+	log.Println("Handshake settled:", info)
+
+	ConnectAD.lock.Lock()
+	ConnectAD.deviceInfo = &info
+	ConnectAD.lock.Unlock()
 
-	var deviceFound []string
-	if deviceName := lastPortName; deviceName != "" {
-		deviceFound = append(deviceFound, deviceName)
+	if infoConsumer, ok := volumeConsumer.(DeviceInfoConsumer); ok {
+		infoConsumer.OnDeviceInfo(info)
 	}
+}
 
-	for _, portName := range portList {
-		log.Println("Detecting device on:", portName)
+// SerialOptions returns options used to open serial transports.
+func (ConnectAD *Connection) SerialOptions() SerialOptions {
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
 
-		port, err := serial.Open(portName, &serial.Mode{
-			BaudRate: 9600,
-		})
-		if err != nil {
-			log.Printf("can't open port: %s", err)
-			continue
-		}
-		defer port.Close()
+	if ConnectAD.serialOptions == nil {
+		return DefaultSerialOptions()
+	}
 
-		go func() {
-			time.Sleep(5 * time.Second)
-			port.Close()
-		}()
+	return *ConnectAD.serialOptions
+}
 
-		reader := bufio.NewReader(port)
+// SetSerialOptions changes options used by the next serial connection.
+func (ConnectAD *Connection) SetSerialOptions(serialOptions SerialOptions) {
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
 
-		for i := 0; i < 3; i++ {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				log.Printf("can't read port: %s", err)
-				continue
-			}
-			fmt.Printf("Read %q\n", line)
+	ConnectAD.serialOptions = &serialOptions
+}
 
-			if !strings.HasPrefix(line, "but|") {
-				continue
-			}
-			if !strings.HasSuffix(line, "\r\n") {
-				continue
-			}
+// zrób metodę na wskaźniku Connection o następującej geometri DevicePortSet(deviceName string)
+func (ConnectAD *Connection) DevicePortSet(deviceName string) {
+	if ConnectAD == nil {
+		return
+	}
 
-			log.Println("device found at:", portName)
-			deviceFound = append(deviceFound, portName)
-		}
+	// never block the caller, a stale pending change is replaced with this one
+	portNameChannel := ConnectAD.portNames()
+	select {
+	case <-portNameChannel:
+	default:
 	}
 
-	return deviceFound, nil
+	select {
+	case portNameChannel <- deviceName:
+	default:
+	}
 }
 
-func TryReconnect(ctx context.Context, portName string) error {
-	ticker := time.NewTicker(time.Second * 5)
-	defer ticker.Stop()
+func (ConnectAD *Connection) portNames() chan string {
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
 
-	for {
-		err := OpenAndDispatch(ctx, portName)
-		if err == nil {
-			return nil
-		}
+	if ConnectAD.portNameChannel == nil {
+		ConnectAD.portNameChannel = make(chan string, 1)
+	}
 
-		log.Println("cannot connect: sleeping 5s:", err)
-		// TODO: handle errors that should be fatal
+	return ConnectAD.portNameChannel
+}
+
+func OpenAndDispatch(ctx context.Context, portName string, serialOptions SerialOptions /*consumer VolumeConsumer*/) error {
+	port, err := OpenTransport(ctx, portName, serialOptions)
+	if err != nil {
+		return err
+	}
+	defer port.Close()
 
-		ticker.Reset(time.Second * 5)
+	reader := bufio.NewReader(port)
+	for {
 		select {
 		case <-ctx.Done():
-			// context terminated - should exit
 			return nil
+		default:
+			// intentionaly empty
+		}
 
-		case <-ticker.C:
-			// ticker hit - try connect again
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
 		}
+
+		line = strings.TrimSuffix(line, "\r\n")
+		traceLine(line)
 	}
 }
+
+func ListAllNames() ([]string, error) {
+	return serial.GetPortsList()
+}
@@ -1,10 +1,14 @@
 package device
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,3 +31,182 @@ func TestConnection_DevicePortSet(t *testing.T) {
 		require.ErrorIs(t, err, ErrConnectionTimeout)
 	}
 }
+
+type fakePort struct {
+	io.Reader
+	written bytes.Buffer
+}
+
+func newFakePort(lines ...string) *fakePort {
+	return &fakePort{
+		Reader: strings.NewReader(strings.Join(lines, "")),
+	}
+}
+
+func (fak *fakePort) Write(data []byte) (int, error) {
+	return fak.written.Write(data)
+}
+
+func (fak *fakePort) Close() error {
+	return nil
+}
+
+type echoMikser struct {
+	connection *Connection
+	volumes    [][]int
+	mutes      [][]bool
+}
+
+func (fak *echoMikser) OnVolume(volumes []int) {
+	fak.volumes = append(fak.volumes, volumes)
+	fak.connection.Send(StateCommand(volumes))
+}
+
+func (fak *echoMikser) OnMute(mutes []bool) {
+	fak.mutes = append(fak.mutes, mutes)
+	fak.connection.Send(LEDCommand(mutes))
+}
+
+func TestConnection_dispatchWritesBack(t *testing.T) {
+	port := newFakePort(
+		"12|1023\r\n",
+		"pong\r\n",
+		"but|1|0\r\n",
+	)
+
+	var connection Connection
+	mikser := &echoMikser{connection: &connection}
+
+	err := connection.DispatchTransport(context.Background(), "fake", port, mikser)
+	require.ErrorIs(t, err, io.EOF)
+
+	assert.Equal(t, [][]int{{12, 1023}}, mikser.volumes)
+	assert.Equal(t, [][]bool{{true, false}}, mikser.mutes)
+	assert.Equal(t, "hello|proto=2\r\nstate|12|1023\r\nled|1|0\r\n", port.written.String())
+
+	err = connection.Send(PingCommand())
+	assert.ErrorIs(t, err, ErrNotConnected, "port should be released after dispatch")
+}
+
+func TestCommand_String(t *testing.T) {
+	testCases := map[string]struct {
+		given    Command
+		expected string
+	}{
+		"ping":         {given: PingCommand(), expected: "ping"},
+		"leds":         {given: LEDCommand([]bool{false, true}), expected: "led|0|1"},
+		"state":        {given: StateCommand([]int{0, 512, 1023}), expected: "state|0|512|1023"},
+		"state-clamps": {given: StateCommand([]int{-1, 2000}), expected: "state|-|1023"},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, testCase.given.String())
+		})
+	}
+}
+
+func TestConnection_dispatchHandshake(t *testing.T) {
+	testCases := map[string]struct {
+		givenLines   []string
+		expectedInfo DeviceInfo
+	}{
+		"hello": {
+			givenLines: []string{
+				"hello|fw=1.2|sliders=6|buttons=4|proto=2\r\n",
+				"1|2|3|4|5|6\r\n",
+			},
+			expectedInfo: DeviceInfo{Port: "fake", Firmware: "1.2", Sliders: 6, Buttons: 4, Protocol: 2},
+		},
+		"hello-encoders": {
+			givenLines: []string{
+				"hello|fw=1.3|sliders=4|buttons=2|encoders=2|proto=2\r\n",
+			},
+			expectedInfo: DeviceInfo{Port: "fake", Firmware: "1.3", Sliders: 4, Buttons: 2, Encoders: 2, Protocol: 2},
+		},
+		"hello-unknown-fields": {
+			givenLines: []string{
+				"hello|fw=2.0|leds=rgb|sliders=2|buttons=0|proto=3\r\n",
+			},
+			expectedInfo: DeviceInfo{Port: "fake", Firmware: "2.0", Sliders: 2, Protocol: 3},
+		},
+		"legacy": {
+			givenLines: []string{
+				"1|2|3\r\n",
+				"but|0|0\r\n",
+				"1|2|3\r\n",
+			},
+			expectedInfo: DeviceInfo{Port: "fake", Sliders: 3, Buttons: 2, Protocol: 1, Legacy: true},
+		},
+		"legacy-encoders": {
+			givenLines: []string{
+				"1|2|3\r\n",
+				"enc|0|0|0\r\n",
+				"1|2|3\r\n",
+			},
+			expectedInfo: DeviceInfo{Port: "fake", Sliders: 3, Encoders: 3, Protocol: 1, Legacy: true},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			port := newFakePort(testCase.givenLines...)
+
+			var connection Connection
+			mikser := &infoMikser{}
+
+			err := connection.DispatchTransport(context.Background(), "fake", port, mikser)
+			require.ErrorIs(t, err, io.EOF)
+
+			assert.Equal(t, []DeviceInfo{testCase.expectedInfo}, mikser.infos)
+			assert.True(t, strings.HasPrefix(port.written.String(), "hello|proto=2\r\n"))
+
+			_, connected := connection.DeviceInfo()
+			assert.False(t, connected, "device info should be dropped after dispatch")
+		})
+	}
+}
+
+type infoMikser struct {
+	infos []DeviceInfo
+}
+
+func (*infoMikser) OnVolume([]int) {}
+func (*infoMikser) OnMute([]bool)  {}
+
+func (fak *infoMikser) OnDeviceInfo(info DeviceInfo) {
+	fak.infos = append(fak.infos, info)
+}
+
+type encoderMikser struct {
+	echoMikser
+	deltas [][]int
+}
+
+func (fak *encoderMikser) OnEncoder(deltas []int) {
+	fak.deltas = append(fak.deltas, deltas)
+}
+
+func TestConnection_dispatchEncoders(t *testing.T) {
+	lines := []string{
+		"12|1023\r\n",
+		"enc|+3|-1|0\r\n",
+		"enc|2|nope\r\n",
+		"enc|0|0|-12\r\n",
+	}
+
+	var connection Connection
+	mikser := &encoderMikser{echoMikser: echoMikser{connection: &connection}}
+
+	err := connection.DispatchTransport(context.Background(), "fake", newFakePort(lines...), mikser)
+	require.ErrorIs(t, err, io.EOF)
+
+	assert.Equal(t, [][]int{{3, -1, 0}, {0, 0, -12}}, mikser.deltas, "malformed lines are dropped")
+	assert.Equal(t, [][]int{{12, 1023}}, mikser.volumes, "encoder lines aren't slider values")
+
+	// consumers without encoder support don't mind devices that have them
+	plainMikser := &echoMikser{connection: &connection}
+	err = connection.DispatchTransport(context.Background(), "fake", newFakePort(lines...), plainMikser)
+	require.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, [][]int{{12, 1023}}, plainMikser.volumes)
+}
@@ -0,0 +1,370 @@
+package device
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// how long a single port and baud rate combination can be listened to, boards resetting
+// on open take a second or two before their sketch starts talking
+const probeTimeout = 3 * time.Second
+
+// vendors of boards and USB serial adapters mixers are usually built with
+var mixerVendorIDs = []string{
+	"2341", // Arduino
+	"2a03", // Arduino (arduino.org)
+	"1a86", // WCH CH340 clones
+	"0403", // FTDI
+	"10c4", // Silicon Labs CP210x
+	"16c0", // Teensy
+	"1b4f", // SparkFun
+	"239a", // Adafruit
+	"2e8a", // Raspberry Pi Pico
+	"303a", // Espressif
+}
+
+// Ranks of ports found, the better ones are listed first.
+const (
+	// rankKnown ports belong to a USB device the caller knows to be a mixer, they aren't even probed
+	rankKnown = iota
+
+	// rankLikely ports belong to a board or adapter mixers are usually built with
+	rankLikely
+
+	// rankOther ports belong to any other USB device, only probed when none of the likely ones is a mixer
+	rankOther
+)
+
+// DetectedDevice is a mixer found by Discover.
+type DetectedDevice struct {
+	// Port is the name of the port, Address is what to connect to: the port name,
+	// or a serial address carrying the baud rate it answered at
+	Port     string
+	Address  string
+	BaudRate int
+
+	// of the USB device behind the port, empty when unknown
+	VID          string
+	PID          string
+	SerialNumber string
+	Product      string
+
+	// Connected is set for ports already dispatched, which couldn't be probed
+	Connected bool
+
+	// Probed is set for ports a mixer answered on, rather than told apart by its USB descriptor
+	Probed bool
+}
+
+func (d DetectedDevice) String() string {
+	description := d.Address
+	if d.Product != "" {
+		description += " " + d.Product
+	}
+	if d.VID != "" {
+		description += fmt.Sprintf(" [%s:%s]", d.VID, d.PID)
+	}
+	if d.SerialNumber != "" {
+		description += " serial " + d.SerialNumber
+	}
+	if d.Connected {
+		description += " (connected)"
+	}
+
+	return description
+}
+
+// DiscoveryOptions tell Discover how to look for mixers.
+type DiscoveryOptions struct {
+	SerialOptions SerialOptions
+
+	// tried in order after the configured one, until a mixer answers
+	BaudRates []int
+
+	// USB devices known to be mixers, e.g. from the config
+	Known []USBFilter
+}
+
+// Timeout is how long Discover takes at most to try every baud rate, on likely boards first
+// and then on other USB devices. Ports are probed in parallel, so it doesn't grow with their number.
+func (options DiscoveryOptions) Timeout() time.Duration {
+	probedRanks := rankOther - rankLikely + 1
+
+	return time.Duration(len(options.baudRates())*probedRanks) * probeTimeout
+}
+
+// baudRates lists baud rates to probe with, the configured one first as there's no point in trying it twice
+func (options DiscoveryOptions) baudRates() []int {
+	var baudRates []int
+	for _, baudRate := range append([]int{options.SerialOptions.BaudRate}, options.BaudRates...) {
+		if baudRate > 0 && !slices.Contains(baudRates, baudRate) {
+			baudRates = append(baudRates, baudRate)
+		}
+	}
+
+	return baudRates
+}
+
+// candidate is a port worth looking at, with what its USB descriptor tells about it
+type candidate struct {
+	details *enumerator.PortDetails
+	rank    int
+}
+
+// swapped by tests, listing ports never opens them
+var (
+	listPortDetails = enumerator.GetDetailedPortsList
+	probe           = probePort
+)
+
+// Discover looks for mixers without disturbing other serial devices. Ports are ranked by their
+// USB descriptors first: known mixers are reported right away, likely boards are probed in parallel,
+// and other USB devices only when none of those turned out to be a mixer. Ports that aren't USB
+// are never opened. Probes still running when the context ends are given up on.
+func Discover(ctx context.Context, options DiscoveryOptions) ([]DetectedDevice, error) {
+	ports, err := listPortDetails()
+	if err != nil {
+		return nil, fmt.Errorf("can't get port details: %w", err)
+	}
+
+	// connected devices are busy, probing them would fail
+	connected := ConnectedPorts()
+
+	var detected []DetectedDevice
+	var probed []candidate
+	for _, candidate := range rankPorts(ports, options.Known) {
+		name := candidate.details.Name
+
+		switch {
+		case slices.Contains(connected, name):
+			device := detectedDevice(candidate.details, name, 0)
+			device.Connected = true
+			detected = append(detected, device)
+
+		case candidate.rank == rankKnown:
+			detected = append(detected, detectedDevice(candidate.details, name, 0))
+
+		default:
+			probed = append(probed, candidate)
+		}
+	}
+
+	// connected transports that aren't listed as ports, e.g. serial addresses or network ones
+	for _, name := range connected {
+		if !slices.ContainsFunc(detected, func(device DetectedDevice) bool { return device.Port == name }) {
+			detected = append(detected, DetectedDevice{Port: name, Address: name, Connected: true})
+		}
+	}
+
+	// other USB devices are left alone as long as any of the likely ones answers
+	for _, rank := range []int{rankLikely, rankOther} {
+		var group []candidate
+		for _, candidate := range probed {
+			if candidate.rank == rank {
+				group = append(group, candidate)
+			}
+		}
+
+		found := probeCandidates(ctx, group, options)
+		detected = append(detected, found...)
+		if len(found) > 0 || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return detected, nil
+}
+
+// rankPorts picks USB ports out of the list, the most promising ones first.
+// other ports, e.g. built-in serial ports, are left out: probing them could disturb whatever is attached
+func rankPorts(ports []*enumerator.PortDetails, known []USBFilter) []candidate {
+	var candidates []candidate
+	for _, port := range ports {
+		if !port.IsUSB {
+			log.Println("Skipping port that isn't USB:", port.Name)
+			continue
+		}
+
+		rank := rankOther
+		switch {
+		case slices.ContainsFunc(known, func(filter USBFilter) bool {
+			return filter.Matches(port.VID, port.PID, port.SerialNumber)
+		}):
+			rank = rankKnown
+		case slices.Contains(mixerVendorIDs, strings.ToLower(port.VID)):
+			rank = rankLikely
+		}
+
+		candidates = append(candidates, candidate{details: port, rank: rank})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].rank != candidates[j].rank {
+			return candidates[i].rank < candidates[j].rank
+		}
+
+		return candidates[i].details.Name < candidates[j].details.Name
+	})
+
+	return candidates
+}
+
+func detectedDevice(details *enumerator.PortDetails, address string, baudRate int) DetectedDevice {
+	return DetectedDevice{
+		Port:         details.Name,
+		Address:      address,
+		BaudRate:     baudRate,
+		VID:          details.VID,
+		PID:          details.PID,
+		SerialNumber: details.SerialNumber,
+		Product:      details.Product,
+	}
+}
+
+// probeCandidates probes every candidate at once, each one trying baud rates in order
+// until a mixer answers. Found devices are returned in order of the candidates.
+func probeCandidates(ctx context.Context, candidates []candidate, options DiscoveryOptions) []DetectedDevice {
+	baudRates := options.baudRates()
+
+	results := make([]*DetectedDevice, len(candidates))
+
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, baudRate := range baudRates {
+				probeOptions := options.SerialOptions
+				probeOptions.BaudRate = baudRate
+
+				if !probe(ctx, candidate.details.Name, probeOptions) {
+					continue
+				}
+
+				log.Println("device found at:", candidate.details.Name, "baud rate:", baudRate)
+				address := candidate.details.Name
+				if baudRate != options.SerialOptions.BaudRate {
+					address = SerialAddress(address, baudRate)
+				}
+
+				device := detectedDevice(candidate.details, address, baudRate)
+				device.Probed = true
+				results[i] = &device
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	var found []DetectedDevice
+	for _, result := range results {
+		if result != nil {
+			found = append(found, *result)
+		}
+	}
+
+	return found
+}
+
+// probePort tells if there's a mixer talking on given port with given options,
+// giving up after probeTimeout or once the context ends.
+func probePort(ctx context.Context, portName string, serialOptions SerialOptions) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	log.Println("Detecting device on:", portName, "baud rate:", serialOptions.BaudRate)
+
+	mode, err := serialOptions.Mode()
+	if err != nil {
+		log.Printf("invalid serial options: %s", err)
+		return false
+	}
+
+	port, err := serial.Open(portName, mode)
+	if err != nil {
+		log.Printf("can't open port: %s", err)
+		return false
+	}
+	defer port.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	// a pending read only ends with the port closed
+	stop := context.AfterFunc(ctx, func() {
+		port.Close()
+	})
+	defer stop()
+
+	// handshake aware firmware introduces itself right away,
+	// legacy one will be recognized by its button line
+	if _, err := port.Write(HelloCommand().encode()); err != nil {
+		log.Printf("can't write port: %s", err)
+	}
+
+	reader := bufio.NewReader(port)
+
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			log.Printf("can't read port: %s", err)
+			return false
+		}
+		traceLine(line)
+
+		if !strings.HasSuffix(line, lineTerminator) {
+			continue
+		}
+		line = strings.TrimSuffix(line, lineTerminator)
+		if hasKeyword(line, keywordButtons) || hasKeyword(line, keywordHello) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListNames looks for devices with default serial options and baud rates.
+// Like Discover, it only looks at USB ports, ports that aren't USB are skipped.
+func ListNames() ([]string, error) {
+	return ListNamesWithOptions(DefaultSerialOptions(), DefaultProbeBaudRates)
+}
+
+// ListNamesWithOptions looks for devices like Discover does, trying each of given baud rates in order.
+// Devices found at a baud rate other than configured are returned as serial address carrying
+// the right one, e.g. "serial://COM3?baud=115200". It gives up after the options' Timeout.
+func ListNamesWithOptions(serialOptions SerialOptions, baudRates []int) ([]string, error) {
+	options := DiscoveryOptions{SerialOptions: serialOptions, BaudRates: baudRates}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout())
+	defer cancel()
+
+	detected, err := Discover(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return Addresses(detected), nil
+}
+
+// Addresses returns what to connect to for every detected device.
+func Addresses(detected []DetectedDevice) []string {
+	addresses := make([]string, 0, len(detected))
+	for _, device := range detected {
+		addresses = append(addresses, device.Address)
+	}
+
+	return addresses
+}
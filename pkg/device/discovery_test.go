@@ -0,0 +1,149 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial/enumerator"
+)
+
+// fakeDiscovery swaps the port list and probes for the duration of a test
+func fakeDiscovery(t *testing.T, ports []*enumerator.PortDetails, probePort func(ctx context.Context, portName string, serialOptions SerialOptions) bool) {
+	previousList, previousProbe := listPortDetails, probe
+	t.Cleanup(func() {
+		listPortDetails, probe = previousList, previousProbe
+	})
+
+	listPortDetails = func() ([]*enumerator.PortDetails, error) { return ports, nil }
+	probe = probePort
+}
+
+func usbPort(name string, vid string, pid string, serialNumber string) *enumerator.PortDetails {
+	return &enumerator.PortDetails{Name: name, IsUSB: true, VID: vid, PID: pid, SerialNumber: serialNumber}
+}
+
+func TestDiscover(t *testing.T) {
+	ports := []*enumerator.PortDetails{
+		{Name: "/dev/ttyS0"},
+		usbPort("/dev/ttyUSB1", "0bda", "8153", ""),
+		usbPort("/dev/ttyUSB0", "1a86", "7523", ""),
+		usbPort("/dev/ttyACM1", "2341", "0043", ""),
+		usbPort("/dev/ttyACM0", "2341", "8036", "A10K3UBJ"),
+	}
+
+	var lock sync.Mutex
+	probes := map[string][]int{}
+
+	// likely boards are probed all at once, none of them answers before all of them were opened
+	allStarted := make(chan struct{})
+	fakeDiscovery(t, ports, func(ctx context.Context, portName string, serialOptions SerialOptions) bool {
+		lock.Lock()
+		probes[portName] = append(probes[portName], serialOptions.BaudRate)
+		if len(probes) == 2 && len(probes[portName]) == 1 {
+			close(allStarted)
+		}
+		lock.Unlock()
+
+		select {
+		case <-allStarted:
+		case <-time.After(500 * time.Millisecond):
+			t.Errorf("probe of %s wasn't run along with the others", portName)
+		}
+
+		return portName == "/dev/ttyUSB0" && serialOptions.BaudRate == 115200
+	})
+
+	options := DiscoveryOptions{
+		SerialOptions: DefaultSerialOptions(),
+		BaudRates:     []int{9600, 115200},
+		Known:         []USBFilter{{SerialNumber: "A10K3UBJ"}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	detected, err := Discover(ctx, options)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/dev/ttyACM0", "serial:///dev/ttyUSB0?baud=115200"}, Addresses(detected))
+	assert.False(t, detected[0].Probed, "known mixers aren't opened")
+	assert.True(t, detected[1].Probed)
+	assert.Equal(t, 115200, detected[1].BaudRate)
+
+	assert.Equal(t, map[string][]int{
+		"/dev/ttyACM1": {9600, 115200},
+		"/dev/ttyUSB0": {9600, 115200},
+	}, probes, "other USB devices and ports that aren't USB are left alone")
+}
+
+func TestDiscover_fallsBackToOtherDevices(t *testing.T) {
+	ports := []*enumerator.PortDetails{
+		usbPort("/dev/ttyACM3", "0483", "5740", ""),
+		usbPort("/dev/ttyUSB2", "0403", "6001", ""),
+	}
+
+	probed := []string{}
+	fakeDiscovery(t, ports, func(ctx context.Context, portName string, serialOptions SerialOptions) bool {
+		probed = append(probed, portName)
+		return portName == "/dev/ttyACM3"
+	})
+
+	detected, err := Discover(context.Background(), DiscoveryOptions{SerialOptions: DefaultSerialOptions()})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/dev/ttyACM3"}, Addresses(detected))
+	assert.Equal(t, []string{"/dev/ttyUSB2", "/dev/ttyACM3"}, probed, "likely boards go first")
+}
+
+func TestDiscover_connected(t *testing.T) {
+	fakeDiscovery(t, []*enumerator.PortDetails{usbPort("/dev/ttyACM0", "2341", "8036", "")},
+		func(ctx context.Context, portName string, serialOptions SerialOptions) bool {
+			t.Errorf("connected port %s probed", portName)
+			return false
+		})
+
+	setPortConnected("/dev/ttyACM0", true)
+	setPortConnected("tcp://mixer.local:7777", true)
+	defer setPortConnected("/dev/ttyACM0", false)
+	defer setPortConnected("tcp://mixer.local:7777", false)
+
+	detected, err := Discover(context.Background(), DiscoveryOptions{SerialOptions: DefaultSerialOptions()})
+	require.NoError(t, err)
+
+	require.Len(t, detected, 2)
+	assert.Equal(t, "/dev/ttyACM0 [2341:8036] (connected)", detected[0].String())
+	assert.Equal(t, "tcp://mixer.local:7777 (connected)", detected[1].String())
+}
+
+func TestDiscoveryOptions_Timeout(t *testing.T) {
+	type testCase struct {
+		givenOptions   DiscoveryOptions
+		expectedProbes int
+	}
+
+	testCases := map[string]testCase{
+		"default baud rates": {
+			givenOptions:   DiscoveryOptions{SerialOptions: DefaultSerialOptions(), BaudRates: DefaultProbeBaudRates},
+			expectedProbes: len(DefaultProbeBaudRates),
+		},
+		"configured baud rate among them": {
+			givenOptions:   DiscoveryOptions{SerialOptions: SerialOptions{BaudRate: 115200}, BaudRates: []int{9600, 115200}},
+			expectedProbes: 2,
+		},
+		"configured baud rate only": {
+			givenOptions:   DiscoveryOptions{SerialOptions: DefaultSerialOptions()},
+			expectedProbes: 1,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			// every baud rate gets its full window, on likely boards and then on other USB devices
+			assert.Equal(t, time.Duration(testCase.expectedProbes*2)*probeTimeout, testCase.givenOptions.Timeout())
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the protocol version spoken by this host.
+// Devices that never answer the handshake are assumed to speak legacyProtocolVersion.
+const (
+	ProtocolVersion       = 2
+	legacyProtocolVersion = 1
+)
+
+// how many data lines may arrive before a device is considered legacy
+const legacyHandshakeLines = 3
+
+// DeviceInfo describes the mixer connected on the other side of the link.
+type DeviceInfo struct {
+	Port     string
+	Firmware string
+	Sliders  int
+	Buttons  int
+	Encoders int
+	Protocol int
+
+	// Legacy is set for firmware without handshake support,
+	// slider and button counts are then inferred from the first lines
+	Legacy bool
+}
+
+// DeviceInfoConsumer can be implemented by a VolumeConsumer
+// to be told about the device once the handshake settles.
+type DeviceInfoConsumer interface {
+	OnDeviceInfo(DeviceInfo)
+}
+
+// HelloCommand builds a frame starting the handshake, the device answers with
+// "hello|fw=1.0|sliders=6|buttons=6|proto=2".
+func HelloCommand() Command {
+	return Command{
+		Keyword: keywordHello,
+		Fields:  []string{fmt.Sprintf("proto=%d", ProtocolVersion)},
+	}
+}
+
+func (info DeviceInfo) String() string {
+	if info.Legacy {
+		return fmt.Sprintf("legacy device at %s (%s)", info.Port, info.inputs())
+	}
+
+	return fmt.Sprintf("device at %s, fw %s, proto %d (%s)",
+		info.Port, info.Firmware, info.Protocol, info.inputs())
+}
+
+// inputs counts the device's inputs, leaving encoders out for mixers that don't have any
+func (info DeviceInfo) inputs() string {
+	inputs := fmt.Sprintf("%d sliders, %d buttons", info.Sliders, info.Buttons)
+	if info.Encoders > 0 {
+		inputs += fmt.Sprintf(", %d encoders", info.Encoders)
+	}
+
+	return inputs
+}
+
+// parseDeviceInfo reads key=value fields of a hello line, ignoring unknown keys.
+func parseDeviceInfo(line string) (DeviceInfo, error) {
+	info := DeviceInfo{Protocol: legacyProtocolVersion}
+
+	fields := strings.Split(strings.TrimPrefix(line, keywordHello), fieldSeparator)
+	for _, field := range fields {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+
+		var err error
+		switch key {
+		case "fw":
+			info.Firmware = value
+		case "sliders":
+			info.Sliders, err = strconv.Atoi(value)
+		case "buttons":
+			info.Buttons, err = strconv.Atoi(value)
+		case "encoders":
+			info.Encoders, err = strconv.Atoi(value)
+		case "proto":
+			info.Protocol, err = strconv.Atoi(value)
+		}
+
+		if err != nil {
+			return DeviceInfo{}, fmt.Errorf("hello field %q: %w", field, err)
+		}
+	}
+
+	return info, nil
+}
+
+// handshake follows the first lines read from a device, either until the device
+// introduces itself or until enough data lines arrived to give up on it.
+type handshake struct {
+	settled   bool
+	dataLines int
+	sliders   int
+	buttons   int
+	encoders  int
+}
+
+// observe returns device info each time the handshake settles on something new.
+func (h *handshake) observe(line string) (DeviceInfo, bool) {
+	if hasKeyword(line, keywordHello) {
+		info, err := parseDeviceInfo(line)
+		if err != nil {
+			return DeviceInfo{}, false
+		}
+
+		h.settled = true
+		return info, true
+	}
+
+	if h.settled {
+		return DeviceInfo{}, false
+	}
+
+	count := len(strings.Split(line, fieldSeparator)) - 1
+	if hasKeyword(line, keywordButtons) || hasKeyword(line, keywordMute) {
+		h.buttons = count
+	} else if hasKeyword(line, keywordEncoders) {
+		h.encoders = count
+	} else {
+		h.sliders = count + 1
+	}
+
+	h.dataLines++
+	if h.dataLines < legacyHandshakeLines {
+		return DeviceInfo{}, false
+	}
+
+	h.settled = true
+	return DeviceInfo{
+		Sliders:  h.sliders,
+		Buttons:  h.buttons,
+		Encoders: h.encoders,
+		Protocol: legacyProtocolVersion,
+		Legacy:   true,
+	}, true
+}
@@ -0,0 +1,46 @@
+package device
+
+import (
+	"context"
+	"errors"
+)
+
+// Actions of hotplug events.
+const (
+	HotplugAdd    = "add"
+	HotplugRemove = "remove"
+)
+
+var ErrHotplugUnsupported = errors.New("hotplug detection not supported on this platform")
+
+// HotplugEvent tells about a serial port that just appeared or went away.
+type HotplugEvent struct {
+	Action string
+	Port   string
+
+	// of the USB device behind the port, empty when it isn't one or they're unknown
+	VID          string
+	PID          string
+	SerialNumber string
+}
+
+// HotplugSource delivers hotplug events of serial ports until the context ends,
+// closing the channel after that.
+type HotplugSource interface {
+	Events(ctx context.Context) (<-chan HotplugEvent, error)
+}
+
+// Matches tells if the port belongs to a USB device passing the filter.
+func (e HotplugEvent) Matches(filter USBFilter) bool {
+	return filter.Matches(e.VID, e.PID, e.SerialNumber)
+}
+
+// IsPort tells if the event is about the port of given transport address, e.g. "serial:///dev/ttyACM0?baud=115200".
+func (e HotplugEvent) IsPort(address string) bool {
+	scheme, target, _, err := parseAddress(address)
+	if err != nil || scheme != SchemeSerial {
+		return false
+	}
+
+	return target == e.Port
+}
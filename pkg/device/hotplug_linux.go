@@ -0,0 +1,135 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const (
+	// netlink group udev announces devices on, once their /dev nodes are ready to be opened
+	udevMonitorGroup = 2
+
+	udevMessagePrefix = "libudev\x00"
+	udevMessageMagic  = 0xfeedcafe
+)
+
+// DefaultHotplugSource listens to udev over netlink. It's quiet on systems without udev,
+// where ports are only found by polling.
+func DefaultHotplugSource() HotplugSource {
+	return udevSource{}
+}
+
+type udevSource struct{}
+
+func (udevSource) Events(ctx context.Context) (<-chan HotplugEvent, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("open uevent socket: %w", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: udevMonitorGroup}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind uevent socket: %w", err)
+	}
+
+	// non-blocking sockets go through the runtime poller, so closing one interrupts a pending read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("set uevent socket non-blocking: %w", err)
+	}
+	socket := os.NewFile(uintptr(fd), "uevent")
+
+	events := make(chan HotplugEvent)
+	stop := context.AfterFunc(ctx, func() {
+		socket.Close()
+	})
+
+	go func() {
+		defer close(events)
+		defer stop()
+
+		buffer := make([]byte, 64*1024)
+		for {
+			n, err := socket.Read(buffer)
+			if err != nil {
+				return
+			}
+
+			event, ok := parseUevent(buffer[:n])
+			if !ok {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// parseUevent reads a tty add or remove event out of a udev message, or a raw kernel one.
+// Other devices, and messages that can't be made sense of, are skipped.
+func parseUevent(message []byte) (HotplugEvent, bool) {
+	var properties []byte
+
+	if bytes.HasPrefix(message, []byte(udevMessagePrefix)) {
+		// header: prefix, magic (big endian), header size, properties offset and length (host order)
+		if len(message) < 24 || binary.BigEndian.Uint32(message[8:12]) != udevMessageMagic {
+			return HotplugEvent{}, false
+		}
+
+		offset := binary.NativeEndian.Uint32(message[16:20])
+		length := binary.NativeEndian.Uint32(message[20:24])
+		if uint64(offset)+uint64(length) > uint64(len(message)) {
+			return HotplugEvent{}, false
+		}
+		properties = message[offset : offset+length]
+	} else {
+		// kernel messages start with "action@devpath"
+		_, properties, _ = bytes.Cut(message, []byte{0})
+	}
+
+	values := map[string]string{}
+	for _, property := range bytes.Split(properties, []byte{0}) {
+		if key, value, found := strings.Cut(string(property), "="); found {
+			values[key] = value
+		}
+	}
+
+	action := values["ACTION"]
+	if values["SUBSYSTEM"] != "tty" || values["DEVNAME"] == "" || (action != HotplugAdd && action != HotplugRemove) {
+		return HotplugEvent{}, false
+	}
+
+	port := values["DEVNAME"]
+	if !strings.HasPrefix(port, "/") {
+		port = "/dev/" + port
+	}
+
+	return HotplugEvent{
+		Action:       action,
+		Port:         port,
+		VID:          firstValue(values, "ID_USB_VENDOR_ID", "ID_VENDOR_ID"),
+		PID:          firstValue(values, "ID_USB_MODEL_ID", "ID_MODEL_ID"),
+		SerialNumber: firstValue(values, "ID_USB_SERIAL_SHORT", "ID_SERIAL_SHORT"),
+	}, true
+}
+
+func firstValue(values map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := values[key]; value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
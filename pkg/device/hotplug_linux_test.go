@@ -0,0 +1,59 @@
+package device
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// udevMessage puts properties together the way udev sends them over netlink
+func udevMessage(properties ...string) []byte {
+	body := []byte(strings.Join(properties, "\x00") + "\x00")
+
+	header := make([]byte, 40)
+	copy(header, udevMessagePrefix)
+	binary.BigEndian.PutUint32(header[8:], udevMessageMagic)
+	binary.NativeEndian.PutUint32(header[12:], uint32(len(header)))
+	binary.NativeEndian.PutUint32(header[16:], uint32(len(header)))
+	binary.NativeEndian.PutUint32(header[20:], uint32(len(body)))
+
+	return append(header, body...)
+}
+
+func TestParseUevent(t *testing.T) {
+	testCases := map[string]struct {
+		given         []byte
+		expected      HotplugEvent
+		expectedFound bool
+	}{
+		"udev add": {
+			given: udevMessage("ACTION=add", "SUBSYSTEM=tty", "DEVNAME=/dev/ttyACM0",
+				"ID_VENDOR_ID=2341", "ID_MODEL_ID=8036", "ID_SERIAL_SHORT=A10K3UBJ"),
+			expected:      HotplugEvent{Action: HotplugAdd, Port: "/dev/ttyACM0", VID: "2341", PID: "8036", SerialNumber: "A10K3UBJ"},
+			expectedFound: true,
+		},
+		"udev remove without ids": {
+			given:         udevMessage("ACTION=remove", "SUBSYSTEM=tty", "DEVNAME=/dev/ttyUSB1"),
+			expected:      HotplugEvent{Action: HotplugRemove, Port: "/dev/ttyUSB1"},
+			expectedFound: true,
+		},
+		"kernel add": {
+			given:         []byte("add@/devices/usb1/1-1/1-1:1.0/tty/ttyACM0\x00ACTION=add\x00SUBSYSTEM=tty\x00DEVNAME=ttyACM0\x00"),
+			expected:      HotplugEvent{Action: HotplugAdd, Port: "/dev/ttyACM0"},
+			expectedFound: true,
+		},
+		"other subsystem": {given: udevMessage("ACTION=add", "SUBSYSTEM=usb", "DEVNAME=/dev/bus/usb/001/004")},
+		"other action":    {given: udevMessage("ACTION=change", "SUBSYSTEM=tty", "DEVNAME=/dev/ttyACM0")},
+		"truncated":       {given: udevMessage("ACTION=add", "SUBSYSTEM=tty", "DEVNAME=/dev/ttyACM0")[:30]},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			event, found := parseUevent(testCase.given)
+			assert.Equal(t, testCase.expectedFound, found)
+			assert.Equal(t, testCase.expected, event)
+		})
+	}
+}
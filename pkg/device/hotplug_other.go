@@ -0,0 +1,16 @@
+//go:build !linux
+
+package device
+
+import "context"
+
+// DefaultHotplugSource fails right away on platforms other than Linux, where ports are only found by polling.
+func DefaultHotplugSource() HotplugSource {
+	return unsupportedSource{}
+}
+
+type unsupportedSource struct{}
+
+func (unsupportedSource) Events(ctx context.Context) (<-chan HotplugEvent, error) {
+	return nil, ErrHotplugUnsupported
+}
@@ -0,0 +1,46 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUSBID(t *testing.T) {
+	filter, err := ParseUSBID(" 2341:8036 ")
+	require.NoError(t, err)
+	assert.Equal(t, USBFilter{VID: "2341", PID: "8036"}, filter)
+
+	for _, invalid := range []string{"", "2341", "2341:", "2341:80366", "zz41:8036", "2341-8036"} {
+		_, err := ParseUSBID(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestHotplugEvent_Matches(t *testing.T) {
+	event := HotplugEvent{Action: HotplugAdd, Port: "/dev/ttyACM0", VID: "2341", PID: "8036", SerialNumber: "A10K3UBJ"}
+
+	testCases := map[string]struct {
+		given    USBFilter
+		expected bool
+	}{
+		"ids":                         {given: USBFilter{VID: "2341", PID: "8036"}, expected: true},
+		"serial number in other case": {given: USBFilter{VID: "2341", PID: "8036", SerialNumber: "a10k3ubj"}, expected: true},
+		"serial number":               {given: USBFilter{SerialNumber: "A10K3UBJ"}, expected: true},
+		"other product":               {given: USBFilter{VID: "2341", PID: "0043"}, expected: false},
+		"other board":                 {given: USBFilter{VID: "2341", PID: "8036", SerialNumber: "85734323"}, expected: false},
+		"empty filter":                {given: USBFilter{}, expected: false},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, event.Matches(testCase.given))
+		})
+	}
+
+	assert.True(t, event.IsPort("/dev/ttyACM0"))
+	assert.True(t, event.IsPort("serial:///dev/ttyACM0?baud=115200"))
+	assert.False(t, event.IsPort("/dev/ttyACM1"))
+	assert.False(t, event.IsPort("tcp://mixer.local:7777"))
+}
@@ -7,14 +7,24 @@ import (
 )
 
 func parseAndDispatch(line string, volumeConsumer VolumeConsumer) {
+	// device answered our ping, nothing to dispatch
+	if line == keywordPong {
+		return
+	}
+
+	if hasKeyword(line, keywordEncoders) {
+		parseAndDispatchEncoders(line, volumeConsumer)
+		return
+	}
+
 	isMute := false
-	if strings.HasPrefix(line, "but|") || strings.HasPrefix(line, "mute|") {
+	if hasKeyword(line, keywordButtons) || hasKeyword(line, keywordMute) {
 		isMute = true
 	}
-	line = strings.TrimPrefix(line, "but|")
-	line = strings.TrimPrefix(line, "mute|")
+	line = strings.TrimPrefix(line, keywordButtons+fieldSeparator)
+	line = strings.TrimPrefix(line, keywordMute+fieldSeparator)
 
-	values := strings.Split(line, "|")
+	values := strings.Split(line, fieldSeparator)
 
 	var volumes []int
 	var mutes []bool
@@ -56,3 +66,26 @@ func parseAndDispatch(line string, volumeConsumer VolumeConsumer) {
 	// zadanie na 6 - co zrobić z tym i, i wypisanie na ekran nie będzie szósteczką ;)
 
 }
+
+// parseAndDispatchEncoders reads relative encoder deltas, consumers that
+// don't know about encoders just never get them.
+func parseAndDispatchEncoders(line string, volumeConsumer VolumeConsumer) {
+	encoderConsumer, ok := volumeConsumer.(EncoderConsumer)
+	if !ok {
+		return
+	}
+
+	values := strings.Split(strings.TrimPrefix(line, keywordEncoders+fieldSeparator), fieldSeparator)
+	deltas := make([]int, len(values))
+
+	for i, value := range values {
+		delta, err := strconv.Atoi(value)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		deltas[i] = delta
+	}
+
+	encoderConsumer.OnEncoder(deltas)
+}
@@ -0,0 +1,100 @@
+package device
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Framing shared by both directions of the link. Every frame is a single line
+// terminated with CRLF, and its fields are separated by a pipe.
+//
+// Frames sent by the device are either a bare list of slider values
+// ("512|1023|0") or start with a keyword ("but|1|0", "pong"). Rotary encoders
+// report detents turned since their previous frame ("enc|+3|-1|0"), negative
+// ones counterclockwise.
+//
+// Frames sent by the host always start with a keyword:
+//
+//	hello|proto=2  start the handshake, see HelloCommand
+//	led|1|0|0      light (1) or darken (0) the LED of every button
+//	state|512|-    real volume of every slider, in the same 0..MaxValue range,
+//	               or "-" when nothing is playing on that slider
+//	ping           ask the device to answer with "pong"
+const (
+	lineTerminator = "\r\n"
+	fieldSeparator = "|"
+
+	// both directions
+	keywordHello = "hello"
+
+	// device -> host
+	keywordButtons  = "but"
+	keywordMute     = "mute"
+	keywordEncoders = "enc"
+	keywordPong     = "pong"
+
+	// host -> device
+	keywordLED   = "led"
+	keywordState = "state"
+	keywordPing  = "ping"
+)
+
+// unknownState marks slider without any session in a state frame
+const unknownState = "-"
+
+// MaxValue is the highest raw value a slider can report, and the highest
+// value the host sends back in a state frame.
+const MaxValue = 1023
+
+// Command is a single frame sent from the host to the device.
+type Command struct {
+	Keyword string
+	Fields  []string
+}
+
+// LEDCommand builds a frame lighting up LEDs of buttons that are on.
+func LEDCommand(leds []bool) Command {
+	fields := make([]string, len(leds))
+	for i, led := range leds {
+		fields[i] = "0"
+		if led {
+			fields[i] = "1"
+		}
+	}
+
+	return Command{Keyword: keywordLED, Fields: fields}
+}
+
+// StateCommand builds a frame reporting real volumes of sliders. Values are
+// clamped to MaxValue, negative values mark sliders with unknown volume.
+func StateCommand(volumes []int) Command {
+	fields := make([]string, len(volumes))
+	for i, volume := range volumes {
+		if volume < 0 {
+			fields[i] = unknownState
+			continue
+		}
+		fields[i] = strconv.Itoa(min(volume, MaxValue))
+	}
+
+	return Command{Keyword: keywordState, Fields: fields}
+}
+
+// PingCommand builds a frame the device should answer with "pong".
+func PingCommand() Command {
+	return Command{Keyword: keywordPing}
+}
+
+// String returns the frame without line terminator.
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Keyword}, c.Fields...), fieldSeparator)
+}
+
+func (c Command) encode() []byte {
+	return []byte(c.String() + lineTerminator)
+}
+
+// hasKeyword reports if line is a frame starting with given keyword.
+func hasKeyword(line, keyword string) bool {
+	return line == keyword || strings.HasPrefix(line, keyword+fieldSeparator)
+}
@@ -0,0 +1,130 @@
+package device
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.bug.st/serial"
+)
+
+// SerialOptions describes how serial transports are opened. Zero values
+// stand for the usual 9600 8N1, but with DTR and RTS lowered: see
+// DefaultSerialOptions for options raising them like the stock firmware expects.
+type SerialOptions struct {
+	BaudRate int
+	DataBits int
+	Parity   string // none, odd, even, mark or space
+	StopBits string // 1, 1.5 or 2
+
+	// modem lines state right after opening, raising DTR resets most arduinos
+	DTR bool
+	RTS bool
+}
+
+// DefaultSerialOptions returns options matching the stock deej firmware.
+func DefaultSerialOptions() SerialOptions {
+	return SerialOptions{
+		BaudRate: defaultBaudRate,
+		DataBits: 8,
+		Parity:   "none",
+		StopBits: "1",
+		DTR:      true,
+		RTS:      true,
+	}
+}
+
+// DefaultProbeBaudRates are tried in order when looking for a device.
+var DefaultProbeBaudRates = []int{9600, 115200, 57600, 38400, 19200}
+
+var parities = map[string]serial.Parity{
+	"":      serial.NoParity,
+	"none":  serial.NoParity,
+	"odd":   serial.OddParity,
+	"even":  serial.EvenParity,
+	"mark":  serial.MarkParity,
+	"space": serial.SpaceParity,
+}
+
+var stopBits = map[string]serial.StopBits{
+	"":    serial.OneStopBit,
+	"1":   serial.OneStopBit,
+	"1.5": serial.OnePointFiveStopBits,
+	"2":   serial.TwoStopBits,
+}
+
+// Mode translates options into serial.Mode, validating every field.
+func (opts SerialOptions) Mode() (*serial.Mode, error) {
+	mode := &serial.Mode{
+		BaudRate: opts.BaudRate,
+		DataBits: opts.DataBits,
+		InitialStatusBits: &serial.ModemOutputBits{
+			DTR: opts.DTR,
+			RTS: opts.RTS,
+		},
+	}
+
+	if mode.BaudRate <= 0 {
+		mode.BaudRate = defaultBaudRate
+	}
+
+	if mode.DataBits == 0 {
+		mode.DataBits = 8
+	}
+	if mode.DataBits < 5 || mode.DataBits > 8 {
+		return nil, fmt.Errorf("invalid data bits %d, expected 5 to 8", opts.DataBits)
+	}
+
+	var ok bool
+	if mode.Parity, ok = parities[strings.ToLower(opts.Parity)]; !ok {
+		return nil, fmt.Errorf("invalid parity %q", opts.Parity)
+	}
+	if mode.StopBits, ok = stopBits[opts.StopBits]; !ok {
+		return nil, fmt.Errorf("invalid stop bits %q", opts.StopBits)
+	}
+
+	return mode, nil
+}
+
+// withQuery overrides options with ones given in transport address,
+// e.g. "serial:///dev/ttyUSB0?baud=115200&parity=even&dtr=false".
+func (opts SerialOptions) withQuery(query url.Values) (SerialOptions, error) {
+	var err error
+
+	if value := query.Get("baud"); value != "" {
+		if opts.BaudRate, err = strconv.Atoi(value); err != nil {
+			return opts, fmt.Errorf("parse baud rate %q: %w", value, err)
+		}
+	}
+	if value := query.Get("databits"); value != "" {
+		if opts.DataBits, err = strconv.Atoi(value); err != nil {
+			return opts, fmt.Errorf("parse data bits %q: %w", value, err)
+		}
+	}
+	if value := query.Get("parity"); value != "" {
+		opts.Parity = value
+	}
+	if value := query.Get("stopbits"); value != "" {
+		opts.StopBits = value
+	}
+	if value := query.Get("dtr"); value != "" {
+		if opts.DTR, err = strconv.ParseBool(value); err != nil {
+			return opts, fmt.Errorf("parse dtr %q: %w", value, err)
+		}
+	}
+	if value := query.Get("rts"); value != "" {
+		if opts.RTS, err = strconv.ParseBool(value); err != nil {
+			return opts, fmt.Errorf("parse rts %q: %w", value, err)
+		}
+	}
+
+	return opts, nil
+}
+
+// SerialAddress builds transport address of a serial port using given baud rate.
+func SerialAddress(portName string, baudRate int) string {
+	// device paths already start with a slash, giving "serial:///dev/ttyUSB0"
+	query := url.Values{"baud": {strconv.Itoa(baudRate)}}
+	return fmt.Sprintf("%s://%s?%s", SchemeSerial, portName, query.Encode())
+}
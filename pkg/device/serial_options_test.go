@@ -0,0 +1,93 @@
+package device
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+func TestSerialOptions_Mode(t *testing.T) {
+	type testCase struct {
+		givenOptions SerialOptions
+		givenQuery   url.Values
+		expectedMode *serial.Mode
+		expectError  bool
+	}
+
+	testCases := map[string]testCase{
+		"defaults": {
+			givenOptions: DefaultSerialOptions(),
+			expectedMode: &serial.Mode{
+				BaudRate:          9600,
+				DataBits:          8,
+				InitialStatusBits: &serial.ModemOutputBits{DTR: true, RTS: true},
+			},
+		},
+		"zero-value": {
+			expectedMode: &serial.Mode{
+				BaudRate:          9600,
+				DataBits:          8,
+				InitialStatusBits: &serial.ModemOutputBits{},
+			},
+		},
+		"configured": {
+			givenOptions: SerialOptions{BaudRate: 115200, DataBits: 7, Parity: "Even", StopBits: "2"},
+			expectedMode: &serial.Mode{
+				BaudRate:          115200,
+				DataBits:          7,
+				Parity:            serial.EvenParity,
+				StopBits:          serial.TwoStopBits,
+				InitialStatusBits: &serial.ModemOutputBits{},
+			},
+		},
+		"query-overrides": {
+			givenOptions: DefaultSerialOptions(),
+			givenQuery:   url.Values{"baud": {"57600"}, "stopbits": {"1.5"}, "dtr": {"false"}},
+			expectedMode: &serial.Mode{
+				BaudRate:          57600,
+				DataBits:          8,
+				StopBits:          serial.OnePointFiveStopBits,
+				InitialStatusBits: &serial.ModemOutputBits{RTS: true},
+			},
+		},
+		"invalid-parity": {
+			givenOptions: SerialOptions{Parity: "maybe"},
+			expectError:  true,
+		},
+		"invalid-data-bits": {
+			givenOptions: SerialOptions{DataBits: 9},
+			expectError:  true,
+		},
+		"invalid-query": {
+			givenQuery:  url.Values{"baud": {"fast"}},
+			expectError: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			opts, err := testCase.givenOptions.withQuery(testCase.givenQuery)
+			if err == nil {
+				var mode *serial.Mode
+				mode, err = opts.Mode()
+				if !testCase.expectError {
+					assert.Equal(t, testCase.expectedMode, mode)
+				}
+			}
+
+			if testCase.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSerialAddress(t *testing.T) {
+	assert.Equal(t, "serial://COM3?baud=115200", SerialAddress("COM3", 115200))
+	assert.Equal(t, "serial:///dev/ttyUSB0?baud=115200", SerialAddress("/dev/ttyUSB0", 115200))
+}
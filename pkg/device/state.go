@@ -0,0 +1,248 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Typed reasons of a lost or failed connection, wrapping the original error.
+var (
+	ErrPortBusy      = errors.New("port busy")
+	ErrPortMissing   = errors.New("port missing")
+	ErrPortUnplugged = errors.New("device unplugged")
+)
+
+// State of a Connection, moving disconnected → probing → connected → stalled
+// and back to disconnected whenever the device goes away.
+type State int
+
+const (
+	// StateDisconnected means nothing is dispatched, usually waiting before the next attempt.
+	StateDisconnected State = iota
+
+	// StateProbing means the port is being opened and no line was read from it yet.
+	StateProbing
+
+	// StateConnected means lines keep coming from the device.
+	StateConnected
+
+	// StateStalled means the port is still open, but the device went quiet for a while.
+	StateStalled
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateProbing:
+		return "probing"
+	case StateConnected:
+		return "connected"
+	case StateStalled:
+		return "stalled"
+	}
+
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// StateChange tells what state a connection moved to.
+type StateChange struct {
+	Port  string
+	State State
+
+	// why the connection was lost or couldn't be made, only set along with StateDisconnected
+	Err error
+}
+
+// StateConsumer can be implemented by a VolumeConsumer to follow the connection state.
+type StateConsumer interface {
+	OnState(StateChange)
+}
+
+// how long a device can stay quiet before its connection counts as stalled,
+// stock firmware sends a line every few milliseconds
+const stallTimeout = time.Second
+
+// State returns the current state of the connection.
+func (ConnectAD *Connection) State() State {
+	if ConnectAD == nil {
+		return StateDisconnected
+	}
+
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
+
+	return ConnectAD.state
+}
+
+// setState moves the connection to another state and lets the consumer know. Every failed
+// attempt is reported, even when the connection already was disconnected, other states only once.
+func (ConnectAD *Connection) setState(change StateChange, volumeConsumer VolumeConsumer) {
+	ConnectAD.lock.Lock()
+	previous := ConnectAD.state
+	ConnectAD.state = change.State
+	ConnectAD.lock.Unlock()
+
+	if previous == change.State && change.State != StateDisconnected {
+		return
+	}
+
+	if stateConsumer, ok := volumeConsumer.(StateConsumer); ok {
+		stateConsumer.OnState(change)
+	}
+}
+
+// stall moves a connected connection to StateStalled, leaving any other state as it is.
+func (ConnectAD *Connection) stall(portName string, volumeConsumer VolumeConsumer) {
+	ConnectAD.lock.Lock()
+	connected := ConnectAD.state == StateConnected
+	ConnectAD.lock.Unlock()
+
+	if connected {
+		ConnectAD.setState(StateChange{Port: portName, State: StateStalled}, volumeConsumer)
+	}
+}
+
+// Reason returns the typed error a connection failed with, err itself when it's none of them.
+// Failures for the same reason in a row are usually worth reporting only once.
+func Reason(err error) error {
+	for _, reason := range []error{
+		ErrPortBusy, ErrPortMissing, ErrPortUnplugged, ErrConnectionTimeout, ErrDeviceNotFound,
+	} {
+		if errors.Is(err, reason) {
+			return reason
+		}
+	}
+
+	return err
+}
+
+// classifyOpenError wraps errors of opening a port with ErrPortBusy or ErrPortMissing, when it's one of them.
+func classifyOpenError(err error) error {
+	var portError *serial.PortError
+	if errors.As(err, &portError) {
+		switch portError.Code() {
+		case serial.PortBusy:
+			return fmt.Errorf("%w: %w", ErrPortBusy, err)
+		case serial.PortNotFound:
+			return fmt.Errorf("%w: %w", ErrPortMissing, err)
+		}
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrPortMissing, err)
+	}
+
+	return err
+}
+
+// Backoff tells how long to wait before each of consecutive connection attempts.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// DefaultBackoff doubles the delay from a second up to half a minute.
+var DefaultBackoff = Backoff{Min: time.Second, Max: 30 * time.Second}
+
+// Delay returns how long to wait after given number of failed attempts in a row.
+func (b Backoff) Delay(failures int) time.Duration {
+	delay := b.Min
+	for i := 1; i < failures && delay < b.Max; i++ {
+		delay *= 2
+	}
+
+	return min(delay, b.Max)
+}
+
+// KeepConnected connects and dispatches until the context ends, resolving the port anew before
+// every attempt. Attempts back off while they keep failing, and start over from the shortest
+// delay once a device got connected. DevicePortSet and RetryNow cut a pending wait short.
+func (ConnectAD *Connection) KeepConnected(
+	ctx context.Context,
+	resolvePort func() (string, error),
+	volumeConsumer VolumeConsumer,
+	backoff Backoff,
+) {
+	portNameChannel := ConnectAD.portNames()
+	retryChannel := ConnectAD.retries()
+
+	failures := 0
+	var lastReason error
+	for ctx.Err() == nil {
+		ConnectAD.setConnected(false)
+
+		portName, err := resolvePort()
+		if err == nil {
+			err = ConnectAD.ConnectAndDispatch(ctx, portName, volumeConsumer)
+		} else {
+			ConnectAD.setState(StateChange{State: StateDisconnected, Err: err}, volumeConsumer)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if ConnectAD.everConnected() {
+			failures = 0
+		}
+		failures++
+
+		// a port held by a flashing tool fails the same way for a while, there's no point in repeating it
+		delay := backoff.Delay(failures)
+		if reason := Reason(err); failures == 1 || !sameError(reason, lastReason) {
+			log.Printf("connection failed, retrying in %s: %s", delay, err)
+			lastReason = reason
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		case newPortName := <-portNameChannel:
+			log.Println("Port changed to:", newPortName)
+			failures = 0
+		case <-retryChannel:
+			failures = 0
+		}
+		timer.Stop()
+	}
+}
+
+// RetryNow makes a disconnected connection try again right away, e.g. once its device is plugged in.
+// It does nothing while connected.
+func (ConnectAD *Connection) RetryNow() {
+	if ConnectAD == nil || ConnectAD.State() != StateDisconnected {
+		return
+	}
+
+	select {
+	case ConnectAD.retries() <- struct{}{}:
+	default:
+	}
+}
+
+func (ConnectAD *Connection) retries() chan struct{} {
+	ConnectAD.lock.Lock()
+	defer ConnectAD.lock.Unlock()
+
+	if ConnectAD.retryChannel == nil {
+		ConnectAD.retryChannel = make(chan struct{}, 1)
+	}
+
+	return ConnectAD.retryChannel
+}
+
+func sameError(a error, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a == b || a.Error() == b.Error()
+}
@@ -0,0 +1,156 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	backoff := Backoff{Min: time.Second, Max: 10 * time.Second}
+
+	delays := []time.Duration{}
+	for failures := 1; failures <= 6; failures++ {
+		delays = append(delays, backoff.Delay(failures))
+	}
+
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	}, delays)
+}
+
+func TestClassifyOpenError(t *testing.T) {
+	testCases := map[string]struct {
+		given    error
+		expected error
+	}{
+		"not found":    {given: fs.ErrNotExist, expected: ErrPortMissing},
+		"wrapped path": {given: &fs.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: fs.ErrNotExist}, expected: ErrPortMissing},
+		"other":        {given: fs.ErrPermission, expected: fs.ErrPermission},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			err := classifyOpenError(testCase.given)
+			assert.ErrorIs(t, err, testCase.given, "original error is kept")
+			assert.Equal(t, testCase.expected, Reason(err))
+		})
+	}
+
+	assert.Equal(t, ErrDeviceNotFound, Reason(fmt.Errorf("%w: serial number 1234", ErrDeviceNotFound)))
+}
+
+// stateMikser records every state its connection went through
+type stateMikser struct {
+	echoMikser
+
+	lock   sync.Mutex
+	states []StateChange
+}
+
+func (fak *stateMikser) OnState(change StateChange) {
+	fak.lock.Lock()
+	defer fak.lock.Unlock()
+
+	fak.states = append(fak.states, change)
+}
+
+func (fak *stateMikser) stateNames() []string {
+	fak.lock.Lock()
+	defer fak.lock.Unlock()
+
+	names := []string{}
+	for _, change := range fak.states {
+		names = append(names, change.State.String())
+	}
+
+	return names
+}
+
+func TestConnection_dispatchStates(t *testing.T) {
+	reader, writer := io.Pipe()
+	port := NewPipeTransport(reader, io.Discard)
+
+	var connection Connection
+	mikser := &stateMikser{echoMikser: echoMikser{connection: &connection}}
+
+	done := make(chan error)
+	go func() {
+		done <- connection.DispatchTransport(context.Background(), "fake", port, mikser)
+	}()
+
+	fmt.Fprint(writer, "12|1023\r\n")
+	fmt.Fprint(writer, "13|1023\r\n")
+
+	// quiet for longer than the stall timeout, but not long enough to be dropped
+	time.Sleep(stallTimeout + 200*time.Millisecond)
+	assert.Equal(t, StateStalled, connection.State())
+
+	fmt.Fprint(writer, "14|1023\r\n")
+	writer.Close()
+
+	err := <-done
+	require.ErrorIs(t, err, ErrPortUnplugged)
+	assert.ErrorIs(t, err, io.EOF)
+
+	assert.Equal(t, []string{"connected", "stalled", "connected", "disconnected"}, mikser.stateNames())
+	assert.Equal(t, StateDisconnected, connection.State())
+	assert.ErrorIs(t, mikser.states[3].Err, ErrPortUnplugged)
+}
+
+func TestConnection_KeepConnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var connection Connection
+	mikser := &stateMikser{echoMikser: echoMikser{connection: &connection}}
+
+	attempts := make(chan int)
+	attempt := 0
+	resolvePort := func() (string, error) {
+		attempt++
+		attempts <- attempt
+
+		return "", fmt.Errorf("%w: serial number 1234", ErrDeviceNotFound)
+	}
+
+	// waits are way longer than the test, only a port change can cut them short
+	done := make(chan struct{})
+	go func() {
+		connection.KeepConnected(ctx, resolvePort, mikser, Backoff{Min: time.Hour, Max: time.Hour})
+		close(done)
+	}()
+
+	require.Equal(t, 1, <-attempts)
+
+	connection.DevicePortSet("COM4")
+	select {
+	case n := <-attempts:
+		assert.Equal(t, 2, n)
+	case <-time.After(time.Second):
+		t.Fatal("port change didn't cut the wait short")
+	}
+
+	// the same goes for a device that was just plugged in
+	connection.RetryNow()
+	select {
+	case n := <-attempts:
+		assert.Equal(t, 3, n)
+	case <-time.After(time.Second):
+		t.Fatal("retry didn't cut the wait short")
+	}
+
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{"disconnected", "disconnected", "disconnected"}, mikser.stateNames(),
+		"every failed attempt is reported")
+	assert.ErrorIs(t, mikser.states[0].Err, ErrDeviceNotFound)
+}
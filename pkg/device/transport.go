@@ -0,0 +1,207 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"go.bug.st/serial"
+)
+
+// Transport is a bidirectional byte stream to a mixer. Lines are framed
+// the same way over every transport, see Command.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// Schemes understood by OpenTransport. Address without a scheme is a serial port name.
+const (
+	SchemeSerial    = "serial"     // serial:///dev/ttyUSB0?baud=115200, serial://COM3
+	SchemeTCP       = "tcp"        // tcp://mixer.local:7777, connect to a mixer
+	SchemeTCPListen = "tcp-listen" // tcp-listen://:7777, wait for a mixer to connect
+	SchemeUDP       = "udp"        // udp://mixer.local:7777, one line per datagram
+	SchemeUDPListen = "udp-listen" // udp-listen://:7777, answer whoever sent last datagram
+	SchemeStdio     = "stdio"      // stdio://, read stdin and write stdout
+
+	defaultBaudRate = 9600
+)
+
+var ErrUnknownScheme = errors.New("unknown transport scheme")
+
+// OpenTransport opens a transport described by URL-style address,
+// e.g. "tcp://mixer.local:7777". Plain port names like "COM3" open serial port
+// using given options, which can be overridden by the address query.
+func OpenTransport(ctx context.Context, address string, serialOptions SerialOptions) (Transport, error) {
+	scheme, target, query, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case SchemeSerial:
+		return openSerial(target, serialOptions, query)
+
+	case SchemeTCP:
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", target)
+
+	case SchemeTCPListen:
+		return acceptTCP(ctx, target)
+
+	case SchemeUDP:
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "udp", target)
+
+	case SchemeUDPListen:
+		return listenUDP(target)
+
+	case SchemeStdio:
+		// they're ours for the whole run and may be reopened after a reconnect, hide their Close
+		return NewPipeTransport(struct{ io.Reader }{os.Stdin}, struct{ io.Writer }{os.Stdout}), nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownScheme, scheme)
+}
+
+// parseAddress splits transport address into its scheme, target (port name or host:port) and options.
+func parseAddress(address string) (string, string, url.Values, error) {
+	if address == "-" {
+		return SchemeStdio, "", url.Values{}, nil
+	}
+
+	// windows port names and device paths are not URLs
+	if !strings.Contains(address, "://") {
+		return SchemeSerial, address, url.Values{}, nil
+	}
+
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("parse transport address %q: %w", address, err)
+	}
+
+	target := parsed.Host + parsed.Path
+	if parsed.Scheme == SchemeSerial && parsed.Host == "" && runtime.GOOS == "windows" {
+		// serial:///COM3
+		target = strings.TrimPrefix(target, "/")
+	}
+
+	return parsed.Scheme, target, parsed.Query(), nil
+}
+
+func openSerial(portName string, opts SerialOptions, query url.Values) (Transport, error) {
+	opts, err := opts.withQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := opts.Mode()
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := serial.Open(portName, mode)
+	if err != nil {
+		return nil, classifyOpenError(err)
+	}
+
+	return port, nil
+}
+
+// acceptTCP waits for a single mixer to connect, listener is closed right after.
+func acceptTCP(ctx context.Context, address string) (Transport, error) {
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		listener.Close()
+	})
+	defer stop()
+
+	conn, err := listener.Accept()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return conn, err
+}
+
+// udpListenTransport answers to whoever sent the last datagram.
+type udpListenTransport struct {
+	*net.UDPConn
+
+	lock sync.Mutex
+	peer net.Addr
+}
+
+func listenUDP(address string) (Transport, error) {
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpListenTransport{UDPConn: conn}, nil
+}
+
+func (t *udpListenTransport) Read(data []byte) (int, error) {
+	n, peer, err := t.UDPConn.ReadFrom(data)
+	if peer != nil {
+		t.lock.Lock()
+		t.peer = peer
+		t.lock.Unlock()
+	}
+
+	return n, err
+}
+
+func (t *udpListenTransport) Write(data []byte) (int, error) {
+	t.lock.Lock()
+	peer := t.peer
+	t.lock.Unlock()
+
+	if peer == nil {
+		return 0, ErrNotConnected
+	}
+
+	return t.UDPConn.WriteTo(data, peer)
+}
+
+// pipeTransport glues separate reader and writer into a Transport.
+type pipeTransport struct {
+	io.Reader
+	io.Writer
+}
+
+// NewPipeTransport makes a Transport out of a reader and a writer. Both are
+// closed along with the transport, if they can be.
+func NewPipeTransport(reader io.Reader, writer io.Writer) Transport {
+	return pipeTransport{Reader: reader, Writer: writer}
+}
+
+func (t pipeTransport) Close() error {
+	var errs []error
+
+	if closer, ok := t.Reader.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	if closer, ok := t.Writer.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,173 @@
+package device
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	type testCase struct {
+		givenAddress   string
+		expectedScheme string
+		expectedTarget string
+		expectedQuery  url.Values
+	}
+
+	testCases := map[string]testCase{
+		"windows-port": {
+			givenAddress:   "COM3",
+			expectedScheme: SchemeSerial,
+			expectedTarget: "COM3",
+			expectedQuery:  url.Values{},
+		},
+		"linux-port": {
+			givenAddress:   "/dev/ttyUSB0",
+			expectedScheme: SchemeSerial,
+			expectedTarget: "/dev/ttyUSB0",
+			expectedQuery:  url.Values{},
+		},
+		"serial-url": {
+			givenAddress:   "serial:///dev/ttyUSB0?baud=115200",
+			expectedScheme: SchemeSerial,
+			expectedTarget: "/dev/ttyUSB0",
+			expectedQuery:  url.Values{"baud": {"115200"}},
+		},
+		"serial-host-url": {
+			givenAddress:   "serial://COM3",
+			expectedScheme: SchemeSerial,
+			expectedTarget: "COM3",
+			expectedQuery:  url.Values{},
+		},
+		"tcp": {
+			givenAddress:   "tcp://mixer.local:7777",
+			expectedScheme: SchemeTCP,
+			expectedTarget: "mixer.local:7777",
+			expectedQuery:  url.Values{},
+		},
+		"udp-listen": {
+			givenAddress:   "udp-listen://:7777",
+			expectedScheme: SchemeUDPListen,
+			expectedTarget: ":7777",
+			expectedQuery:  url.Values{},
+		},
+		"stdio-dash": {
+			givenAddress:   "-",
+			expectedScheme: SchemeStdio,
+			expectedTarget: "",
+			expectedQuery:  url.Values{},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if runtime.GOOS == "windows" && testName == "serial-url" {
+				t.Skip("device paths are trimmed on windows")
+			}
+
+			scheme, target, query, err := parseAddress(testCase.givenAddress)
+			require.NoError(t, err)
+
+			assert.Equal(t, testCase.expectedScheme, scheme)
+			assert.Equal(t, testCase.expectedTarget, target)
+			assert.Equal(t, testCase.expectedQuery, query)
+		})
+	}
+}
+
+func TestOpenTransport_unknownScheme(t *testing.T) {
+	_, err := OpenTransport(context.Background(), "carrier-pigeon://coop", DefaultSerialOptions())
+	assert.ErrorIs(t, err, ErrUnknownScheme)
+}
+
+func TestOpenTransport_tcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// fake mixer: answer the handshake, report sliders and hang up
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		hello, _ := bufio.NewReader(conn).ReadString('\n')
+		if hello != "hello|proto=2\r\n" {
+			return
+		}
+		fmt.Fprint(conn, "hello|fw=1.0|sliders=2|buttons=0|proto=2\r\n")
+		fmt.Fprint(conn, "100|200\r\n")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	address := "tcp://" + listener.Addr().String()
+	transport, err := OpenTransport(ctx, address, DefaultSerialOptions())
+	require.NoError(t, err)
+	defer transport.Close()
+
+	var connection Connection
+	mikser := &infoMikser{}
+	err = connection.DispatchTransport(ctx, address, transport, mikser)
+	require.ErrorIs(t, err, io.EOF)
+
+	require.Len(t, mikser.infos, 1)
+	assert.Equal(t, 2, mikser.infos[0].Sliders)
+}
+
+func TestOpenTransport_stdio(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	defer reader.Close()
+	defer writer.Close()
+
+	previousStdin := os.Stdin
+	os.Stdin = reader
+	t.Cleanup(func() { os.Stdin = previousStdin })
+
+	// reconnecting opens and closes it again, stdin has to survive that
+	for attempt := 0; attempt < 2; attempt++ {
+		transport, err := OpenTransport(context.Background(), "stdio://", DefaultSerialOptions())
+		require.NoError(t, err)
+		require.NoError(t, transport.Close())
+	}
+
+	fmt.Fprint(writer, "100\r\n")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "100\r\n", line)
+}
+
+func TestPipeTransport(t *testing.T) {
+	hostReader, deviceWriter := io.Pipe()
+	deviceReader, hostWriter := io.Pipe()
+
+	go func() {
+		fmt.Fprint(deviceWriter, "but|1\r\n")
+		deviceWriter.Close()
+	}()
+	go io.Copy(io.Discard, deviceReader)
+
+	transport := NewPipeTransport(hostReader, hostWriter)
+
+	var connection Connection
+	mikser := &echoMikser{connection: &connection}
+	err := connection.DispatchTransport(context.Background(), "pipe", transport, mikser)
+	require.ErrorIs(t, err, io.EOF)
+	require.NoError(t, transport.Close())
+
+	assert.Equal(t, [][]bool{{true}}, mikser.mutes)
+}
@@ -0,0 +1,88 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// USBFilter picks USB serial adapters by vendor and product ID, serial number, or any
+// combination of these. Empty fields match anything, so a zero filter matches nothing at all.
+type USBFilter struct {
+	VID          string
+	PID          string
+	SerialNumber string
+}
+
+// ParseUSBID parses a "vid:pid" pair of hexadecimal IDs, e.g. "2341:8036", into a filter.
+func ParseUSBID(usbID string) (USBFilter, error) {
+	vid, pid, found := strings.Cut(strings.TrimSpace(usbID), ":")
+	if !found || !isHexID(vid) || !isHexID(pid) {
+		return USBFilter{}, fmt.Errorf("invalid USB ID %q, expected vid:pid like 2341:8036", usbID)
+	}
+
+	return USBFilter{VID: vid, PID: pid}, nil
+}
+
+func isHexID(id string) bool {
+	if len(id) != 4 {
+		return false
+	}
+
+	for _, r := range strings.ToLower(id) {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsZero tells if the filter has nothing to match by.
+func (f USBFilter) IsZero() bool {
+	return f == USBFilter{}
+}
+
+// Matches tells if a USB device with given IDs and serial number passes the filter.
+func (f USBFilter) Matches(vid string, pid string, serialNumber string) bool {
+	if f.IsZero() {
+		return false
+	}
+
+	return matchesField(f.VID, vid) && matchesField(f.PID, pid) && matchesField(f.SerialNumber, serialNumber)
+}
+
+func matchesField(expected string, actual string) bool {
+	return expected == "" || strings.EqualFold(expected, actual)
+}
+
+func (f USBFilter) String() string {
+	var parts []string
+	if f.VID != "" || f.PID != "" {
+		parts = append(parts, fmt.Sprintf("USB ID %s:%s", f.VID, f.PID))
+	}
+	if f.SerialNumber != "" {
+		parts = append(parts, "serial number "+f.SerialNumber)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// FindUSBPort finds the port of the USB device passing the filter, so a mixer can be told apart
+// from others no matter which port it lands on. Ports are only listed, never opened.
+// It returns ErrDeviceNotFound when no such device is plugged in.
+func FindUSBPort(filter USBFilter) (string, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return "", fmt.Errorf("can't get port details: %w", err)
+	}
+
+	for _, port := range ports {
+		if port.IsUSB && filter.Matches(port.VID, port.PID, port.SerialNumber) {
+			return port.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: no USB device with %s", ErrDeviceNotFound, filter)
+}